@@ -0,0 +1,594 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	AccessLogSyntaxAuto   = "auto"
+	AccessLogSyntaxApache = "apache"
+	AccessLogSyntaxNginx  = "nginx"
+
+	// layout of Apache's %t and nginx's $time_local without the brackets
+	accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+)
+
+var (
+	// named formats are written using Apache's LogFormat syntax
+	accessLogFormats = map[string]string{
+		"common":   `%h %l %u %t "%r" %>s %b`,
+		"combined": `%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"`,
+	}
+
+	// %[<>]{param}X where X is the directive letter
+	apacheDirective = regexp.MustCompile(`%[<>]?(?:\{([^}]*)\})?([a-zA-Z%])`)
+
+	// $name or ${name}
+	nginxVariable = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+)
+
+// AccessLogConfig holds the options for the access-log codec.
+type AccessLogConfig struct {
+	// Format is either the name of a built-in format or an Apache LogFormat or
+	// nginx log_format string.
+	Format string `config:"format"`
+
+	// Syntax says how to read a custom format, by default nginx is assumed if
+	// the format contains variables starting with $.
+	Syntax string `config:"syntax"`
+}
+
+var defaultAccessLogConfig = AccessLogConfig{
+	Format: "combined",
+	Syntax: AccessLogSyntaxAuto,
+}
+
+func (c *AccessLogConfig) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+func (c *AccessLogConfig) compile() (*accessLogParser, error) {
+	format, syntax := c.Format, c.Syntax
+	if named, ok := accessLogFormats[format]; ok {
+		format, syntax = named, AccessLogSyntaxApache
+	}
+
+	if strings.TrimSpace(format) == "" {
+		return nil, fmt.Errorf("access log format must not be blank")
+	}
+
+	switch syntax {
+	case AccessLogSyntaxAuto:
+		if nginxVariable.MatchString(format) {
+			return compileAccessLogFormat(format, parseNginxFormat)
+		}
+
+		return compileAccessLogFormat(format, parseApacheFormat)
+	case AccessLogSyntaxApache:
+		return compileAccessLogFormat(format, parseApacheFormat)
+	case AccessLogSyntaxNginx:
+		return compileAccessLogFormat(format, parseNginxFormat)
+	default:
+		return nil, fmt.Errorf("access log syntax must be one of %q, %q or %q, got %q",
+			AccessLogSyntaxAuto, AccessLogSyntaxApache, AccessLogSyntaxNginx, syntax)
+	}
+}
+
+func newAccessLogConfig(options *common.Config) (*AccessLogConfig, error) {
+	config, err := unpackCodecConfig("access-log", defaultAccessLogConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*AccessLogConfig), nil
+}
+
+func init() {
+	Register(AccessLogCodecId, NewAccessLogCodec, defaultAccessLogConfig)
+}
+
+func NewAccessLogCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newAccessLogConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	parser, err := config.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessLogCodec{
+		scanner: bufio.NewScanner(input),
+		parser:  parser,
+		path:    path,
+	}, nil
+}
+
+// AccessLogCodec reads web server access logs line by line, parsing each
+// line with a regular expression generated from the log format.
+type AccessLogCodec struct {
+	scanner    *bufio.Scanner
+	parser     *accessLogParser
+	value      common.MapStr
+	err        error
+	bad        []byte
+	lineNumber int
+	path       string
+}
+
+func (codec *AccessLogCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for codec.scanner.Scan() {
+		codec.lineNumber++
+		line := codec.scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields, err := codec.parser.parse(line)
+		if err != nil {
+			codec.err = fmt.Errorf("line %d: %v", codec.lineNumber, err)
+			codec.bad = []byte(line)
+			return false
+		}
+
+		codec.value = common.MapStr{
+			"access_log": fields,
+			"event":      line,
+			"file":       codec.path,
+			"line":       codec.lineNumber,
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (codec *AccessLogCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *AccessLogCodec) Err() error {
+	if codec.err != nil {
+		return codec.err
+	}
+
+	return codec.scanner.Err()
+}
+
+// Recover skips the line that couldn't be parsed.
+func (codec *AccessLogCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.lineNumber, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
+
+// accessLogField describes where a value in the log line ends up.
+type accessLogField struct {
+	// name is the dotted path of the field in the event
+	name string
+
+	// pattern is the regular expression that matches the value, if empty a
+	// pattern is chosen based on the surrounding text.
+	pattern string
+
+	// convert turns the matched text into the field's value(s)
+	convert func(name, text string, fields common.MapStr) error
+}
+
+// accessLogToken is either literal text or a field from a format string.
+type accessLogToken struct {
+	literal string
+	field   *accessLogField
+}
+
+type accessLogFormatParser func(format string) ([]accessLogToken, error)
+
+type accessLogParser struct {
+	regexp *regexp.Regexp
+	fields []*accessLogField
+}
+
+func compileAccessLogFormat(format string, parseFormat accessLogFormatParser) (*accessLogParser, error) {
+	tokens, err := parseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var expr bytes.Buffer
+	var fields []*accessLogField
+
+	expr.WriteString("^")
+	for i, token := range tokens {
+		if token.field == nil {
+			expr.WriteString(regexp.QuoteMeta(token.literal))
+			continue
+		}
+
+		pattern := token.field.pattern
+		if pattern == "" {
+			quoted := i > 0 && strings.HasSuffix(tokens[i-1].literal, `"`) &&
+				i+1 < len(tokens) && strings.HasPrefix(tokens[i+1].literal, `"`)
+
+			switch {
+			case quoted:
+				pattern = `(?:[^"\\]|\\.)*`
+			case i+1 == len(tokens):
+				pattern = `.*`
+			default:
+				pattern = `.*?`
+			}
+		}
+
+		fmt.Fprintf(&expr, "(%s)", pattern)
+		fields = append(fields, token.field)
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessLogParser{
+		regexp: re,
+		fields: fields,
+	}, nil
+}
+
+func (p *accessLogParser) parse(line string) (common.MapStr, error) {
+	matches := p.regexp.FindStringSubmatch(line)
+	if matches == nil {
+		return nil, fmt.Errorf("line doesn't match the log format")
+	}
+
+	fields := common.MapStr{}
+	for i, field := range p.fields {
+		text := unescapeAccessLogValue(matches[i+1])
+
+		// servers log a dash for missing values
+		if text == "-" || text == "" {
+			continue
+		}
+
+		if err := field.convert(field.name, text, fields); err != nil {
+			return nil, fmt.Errorf("field %q: %v", field.name, err)
+		}
+	}
+
+	return fields, nil
+}
+
+// unescapeAccessLogValue undoes the escaping Apache (\" and \\) and nginx
+// (\xHH) apply to values that could contain quotes.
+func unescapeAccessLogValue(text string) string {
+	if !strings.Contains(text, `\`) {
+		return text
+	}
+
+	var out bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			switch text[i+1] {
+			case '"', '\\':
+				out.WriteByte(text[i+1])
+				i++
+				continue
+			case 'x':
+				if i+3 < len(text) {
+					if b, err := strconv.ParseUint(text[i+2:i+4], 16, 8); err == nil {
+						out.WriteByte(byte(b))
+						i += 3
+						continue
+					}
+				}
+			}
+		}
+
+		out.WriteByte(text[i])
+	}
+
+	return out.String()
+}
+
+func convertAccessLogString(name, text string, fields common.MapStr) error {
+	_, err := fields.Put(name, text)
+	return err
+}
+
+func convertAccessLogInt(name, text string, fields common.MapStr) error {
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	_, err = fields.Put(name, value)
+	return err
+}
+
+func convertAccessLogFloat(name, text string, fields common.MapStr) error {
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		// e.g. nginx lists the time of each upstream that was tried
+		_, err = fields.Put(name, text)
+		return err
+	}
+
+	_, err = fields.Put(name, value)
+	return err
+}
+
+// convertAccessLogDuration returns a converter that scales a duration to seconds.
+func convertAccessLogDuration(unitsPerSecond float64) func(string, string, common.MapStr) error {
+	return func(name, text string, fields common.MapStr) error {
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+
+		_, err = fields.Put(name, value/unitsPerSecond)
+		return err
+	}
+}
+
+func convertAccessLogTime(name, text string, fields common.MapStr) error {
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "["), "]")
+
+	ts, err := time.Parse(accessLogTimeLayout, text)
+	if err != nil {
+		return err
+	}
+
+	_, err = fields.Put(name, ts)
+	return err
+}
+
+func convertAccessLogIsoTime(name, text string, fields common.MapStr) error {
+	ts, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return err
+	}
+
+	_, err = fields.Put(name, ts)
+	return err
+}
+
+// convertAccessLogRequest splits a request line e.g. "GET /index.html HTTP/1.1"
+// into its parts. Malformed requests are kept as they are.
+func convertAccessLogRequest(name, text string, fields common.MapStr) error {
+	parts := strings.Split(text, " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		_, err := fields.Put(name, text)
+		return err
+	}
+
+	fields.Put("method", parts[0])
+	fields.Put("path", parts[1])
+	fields.Put("http_version", strings.TrimPrefix(parts[2], "HTTP/"))
+	return nil
+}
+
+func newAccessLogField(name, pattern string, convert func(string, string, common.MapStr) error) *accessLogField {
+	return &accessLogField{name: name, pattern: pattern, convert: convert}
+}
+
+// headerFieldName converts a header name to a field name e.g. X-Forwarded-For
+// becomes x_forwarded_for.
+func headerFieldName(prefix, header string) string {
+	return prefix + "." + strings.Replace(strings.ToLower(header), "-", "_", -1)
+}
+
+func parseApacheFormat(format string) ([]accessLogToken, error) {
+	var tokens []accessLogToken
+	literal := ""
+	last := 0
+
+	for _, m := range apacheDirective.FindAllStringSubmatchIndex(format, -1) {
+		literal += format[last:m[0]]
+		last = m[1]
+
+		param := ""
+		if m[2] >= 0 {
+			param = format[m[2]:m[3]]
+		}
+
+		directive := format[m[4]:m[5]]
+		if directive == "%" {
+			literal += "%"
+			continue
+		}
+
+		field, err := apacheField(directive, param)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, accessLogToken{literal: literal}, accessLogToken{field: field})
+		literal = ""
+	}
+
+	literal += format[last:]
+	return append(tokens, accessLogToken{literal: literal}), nil
+}
+
+func apacheField(directive, param string) (*accessLogField, error) {
+	switch directive {
+	case "h", "a":
+		return newAccessLogField("client_ip", `\S+`, convertAccessLogString), nil
+	case "A":
+		return newAccessLogField("server_ip", `\S+`, convertAccessLogString), nil
+	case "l":
+		return newAccessLogField("ident", `\S+`, convertAccessLogString), nil
+	case "u":
+		return newAccessLogField("user", "", convertAccessLogString), nil
+	case "t":
+		if param != "" {
+			return nil, fmt.Errorf("custom time formats %%{%s}t aren't supported", param)
+		}
+		return newAccessLogField("timestamp", `\[[^\]]*\]`, convertAccessLogTime), nil
+	case "r":
+		return newAccessLogField("request", "", convertAccessLogRequest), nil
+	case "s":
+		return newAccessLogField("status", `\d{3}|-`, convertAccessLogInt), nil
+	case "b", "B":
+		return newAccessLogField("bytes", `\d+|-`, convertAccessLogInt), nil
+	case "I":
+		return newAccessLogField("bytes_received", `\d+|-`, convertAccessLogInt), nil
+	case "O":
+		return newAccessLogField("bytes_sent", `\d+|-`, convertAccessLogInt), nil
+	case "D":
+		return newAccessLogField("request_time", `\d+|-`, convertAccessLogDuration(1e6)), nil
+	case "T":
+		units := map[string]float64{"": 1, "s": 1, "ms": 1e3, "us": 1e6}
+		perSecond, ok := units[param]
+		if !ok {
+			return nil, fmt.Errorf("unknown time unit %%{%s}T", param)
+		}
+		return newAccessLogField("request_time", `\d+|-`, convertAccessLogDuration(perSecond)), nil
+	case "m":
+		return newAccessLogField("method", `\S+`, convertAccessLogString), nil
+	case "U":
+		return newAccessLogField("path", `\S+`, convertAccessLogString), nil
+	case "q":
+		return newAccessLogField("query", `\S*`, convertAccessLogString), nil
+	case "H":
+		return newAccessLogField("protocol", `\S+`, convertAccessLogString), nil
+	case "v", "V":
+		return newAccessLogField("server_name", `\S+`, convertAccessLogString), nil
+	case "p":
+		return newAccessLogField("server_port", `\d+`, convertAccessLogInt), nil
+	case "P":
+		return newAccessLogField("pid", `\d+`, convertAccessLogInt), nil
+	case "i":
+		switch strings.ToLower(param) {
+		case "referer":
+			return newAccessLogField("referrer", "", convertAccessLogString), nil
+		case "user-agent":
+			return newAccessLogField("user_agent", "", convertAccessLogString), nil
+		}
+		return newAccessLogField(headerFieldName("request_headers", param), "", convertAccessLogString), nil
+	case "o":
+		return newAccessLogField(headerFieldName("response_headers", param), "", convertAccessLogString), nil
+	case "C":
+		return newAccessLogField("cookies."+param, "", convertAccessLogString), nil
+	case "e":
+		return newAccessLogField("env."+param, "", convertAccessLogString), nil
+	case "n":
+		return newAccessLogField("notes."+param, "", convertAccessLogString), nil
+	default:
+		return nil, fmt.Errorf("unsupported LogFormat directive %%%s", directive)
+	}
+}
+
+func parseNginxFormat(format string) ([]accessLogToken, error) {
+	var tokens []accessLogToken
+	last := 0
+
+	for _, m := range nginxVariable.FindAllStringSubmatchIndex(format, -1) {
+		name := ""
+		if m[2] >= 0 {
+			name = format[m[2]:m[3]]
+		} else {
+			name = format[m[4]:m[5]]
+		}
+
+		tokens = append(tokens, accessLogToken{literal: format[last:m[0]]}, accessLogToken{field: nginxField(name)})
+		last = m[1]
+	}
+
+	return append(tokens, accessLogToken{literal: format[last:]}), nil
+}
+
+func nginxField(variable string) *accessLogField {
+	switch variable {
+	case "remote_addr", "realip_remote_addr":
+		return newAccessLogField("client_ip", `\S+`, convertAccessLogString)
+	case "remote_port":
+		return newAccessLogField("client_port", `\d+|-`, convertAccessLogInt)
+	case "remote_user":
+		return newAccessLogField("user", "", convertAccessLogString)
+	case "time_local":
+		return newAccessLogField("timestamp", `[^\]]+`, convertAccessLogTime)
+	case "time_iso8601":
+		return newAccessLogField("timestamp", `\S+`, convertAccessLogIsoTime)
+	case "request":
+		return newAccessLogField("request", "", convertAccessLogRequest)
+	case "request_method":
+		return newAccessLogField("method", `\S+`, convertAccessLogString)
+	case "request_uri", "uri", "document_uri":
+		return newAccessLogField("path", `\S+`, convertAccessLogString)
+	case "args", "query_string":
+		return newAccessLogField("query", `\S*`, convertAccessLogString)
+	case "server_protocol":
+		return newAccessLogField("protocol", `\S+`, convertAccessLogString)
+	case "status":
+		return newAccessLogField("status", `\d{3}|-`, convertAccessLogInt)
+	case "body_bytes_sent":
+		return newAccessLogField("bytes", `\d+|-`, convertAccessLogInt)
+	case "bytes_sent":
+		return newAccessLogField("bytes_sent", `\d+|-`, convertAccessLogInt)
+	case "request_length":
+		return newAccessLogField("bytes_received", `\d+|-`, convertAccessLogInt)
+	case "request_time":
+		return newAccessLogField("request_time", `[\d.]+|-`, convertAccessLogFloat)
+	case "upstream_response_time", "upstream_connect_time", "upstream_header_time":
+		return newAccessLogField(variable, "", convertAccessLogFloat)
+	case "http_referer":
+		return newAccessLogField("referrer", "", convertAccessLogString)
+	case "http_user_agent":
+		return newAccessLogField("user_agent", "", convertAccessLogString)
+	case "host", "server_name":
+		return newAccessLogField("server_name", `\S+`, convertAccessLogString)
+	case "server_addr":
+		return newAccessLogField("server_ip", `\S+`, convertAccessLogString)
+	case "server_port":
+		return newAccessLogField("server_port", `\d+`, convertAccessLogInt)
+	case "pid":
+		return newAccessLogField("pid", `\d+`, convertAccessLogInt)
+	}
+
+	switch {
+	case strings.HasPrefix(variable, "http_"):
+		return newAccessLogField(headerFieldName("request_headers", strings.TrimPrefix(variable, "http_")), "", convertAccessLogString)
+	case strings.HasPrefix(variable, "sent_http_"):
+		return newAccessLogField(headerFieldName("response_headers", strings.TrimPrefix(variable, "sent_http_")), "", convertAccessLogString)
+	case strings.HasPrefix(variable, "cookie_"):
+		return newAccessLogField("cookies."+strings.TrimPrefix(variable, "cookie_"), "", convertAccessLogString)
+	default:
+		return newAccessLogField(variable, "", convertAccessLogString)
+	}
+}
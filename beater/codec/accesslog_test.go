@@ -0,0 +1,212 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestAccessLogCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"blank format":        {"format": " "},
+		"unknown syntax":      {"format": "%h", "syntax": "iis"},
+		"unknown directive":   {"format": "%h %J"},
+		"custom time format":  {"format": "%{%Y}t"},
+		"unknown time units":  {"format": "%{ns}T"},
+		"apache syntax nginx": {"format": "$remote_addr %J", "syntax": "apache"},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewAccessLogCodec(testOptions(t, tc), "testfile", strings.NewReader(""))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestAccessLogCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   "",
+			Length: 0,
+		},
+		"blank lines": {
+			Data:   "\n" + `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326 "-" "-"` + "\n\n",
+			Length: 1,
+		},
+		"not a log line": {
+			Data:      `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326 "-" "-"` + "\nfoo\n",
+			Length:    1,
+			ExpectErr: true,
+		},
+		"bad timestamp": {
+			Data:      `127.0.0.1 - - [yesterday] "GET / HTTP/1.0" 200 2326 "-" "-"`,
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewAccessLogCodec(nil, "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d lines, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestAccessLogCodecValue(t *testing.T) {
+	ts := time.Date(2000, 10, 10, 20, 55, 36, 0, time.UTC)
+
+	cases := map[string]struct {
+		Data     string
+		Options  map[string]interface{}
+		Expected common.MapStr
+	}{
+		"common": {
+			Data:    `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 -`,
+			Options: map[string]interface{}{"format": "common"},
+			Expected: common.MapStr{
+				"client_ip":    "127.0.0.1",
+				"user":         "frank",
+				"timestamp":    ts,
+				"method":       "GET",
+				"path":         "/apache_pb.gif",
+				"http_version": "1.0",
+				"status":       int64(200),
+			},
+		},
+		"combined": {
+			Data: `::1 - - [10/Oct/2000:13:55:36 -0700] "POST /a?b=c HTTP/1.1" 404 12 "http://example.com/\"x\"" "Mozilla/5.0 (X11; Linux)"`,
+			Expected: common.MapStr{
+				"client_ip":    "::1",
+				"timestamp":    ts,
+				"method":       "POST",
+				"path":         "/a?b=c",
+				"http_version": "1.1",
+				"status":       int64(404),
+				"bytes":        int64(12),
+				"referrer":     `http://example.com/"x"`,
+				"user_agent":   "Mozilla/5.0 (X11; Linux)",
+			},
+		},
+		"malformed request": {
+			Data: `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "\x16\x03\x01" 400 0 "-" "-"`,
+			Expected: common.MapStr{
+				"client_ip": "10.0.0.1",
+				"timestamp": ts,
+				"request":   "\x16\x03\x01",
+				"status":    int64(400),
+				"bytes":     int64(0),
+			},
+		},
+		"apache custom": {
+			Data:    `example.com:443 10.0.0.1 1500 "curl/7.1" "1.2.3.4, 5.6.7.8" 100%`,
+			Options: map[string]interface{}{"format": `%v:%p %a %D "%{User-Agent}i" "%{X-Forwarded-For}i" 100%%`},
+			Expected: common.MapStr{
+				"server_name":     "example.com",
+				"server_port":     int64(443),
+				"client_ip":       "10.0.0.1",
+				"request_time":    0.0015,
+				"user_agent":      "curl/7.1",
+				"request_headers": common.MapStr{"x_forwarded_for": "1.2.3.4, 5.6.7.8"},
+			},
+		},
+		"nginx": {
+			Data: `10.0.0.1 - bob [10/Oct/2000:13:55:36 -0700] "GET / HTTP/2.0" 301 169 "-" "Go-http-client/1.1" 0.005 "0.004, 0.001" example.com`,
+			Options: map[string]interface{}{
+				"format": `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" $request_time "$upstream_response_time" $host`,
+			},
+			Expected: common.MapStr{
+				"client_ip":              "10.0.0.1",
+				"user":                   "bob",
+				"timestamp":              ts,
+				"method":                 "GET",
+				"path":                   "/",
+				"http_version":           "2.0",
+				"status":                 int64(301),
+				"bytes":                  int64(169),
+				"user_agent":             "Go-http-client/1.1",
+				"request_time":           0.005,
+				"upstream_response_time": "0.004, 0.001",
+				"server_name":            "example.com",
+			},
+		},
+		"nginx escapes and iso time": {
+			Data:    `2000-10-10T20:55:36+00:00 "say \x22hi\x22" a.b`,
+			Options: map[string]interface{}{"format": `$time_iso8601 "$http_x_note" $custom_var`},
+			Expected: common.MapStr{
+				"timestamp":       ts,
+				"request_headers": common.MapStr{"x_note": `say "hi"`},
+				"custom_var":      "a.b",
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewAccessLogCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		if !c.Next() {
+			t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+		}
+
+		val := c.Value()
+		if val["event"] != tc.Data || val["file"] != "testfile" || val["line"] != 1 {
+			t.Errorf("%q | Unexpected event metadata %v", tn, val)
+		}
+
+		actual := val["access_log"].(common.MapStr)
+		if ts, ok := actual["timestamp"].(time.Time); ok {
+			expected := tc.Expected["timestamp"].(time.Time)
+			if !ts.Equal(expected) {
+				t.Errorf("%q | Expected timestamp %v, got %v", tn, expected, ts)
+			}
+
+			delete(actual, "timestamp")
+			delete(tc.Expected, "timestamp")
+		}
+
+		expectedS := fmt.Sprintf("%v", tc.Expected)
+		actualS := fmt.Sprintf("%v", actual)
+		if expectedS != actualS {
+			t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+		}
+	}
+}
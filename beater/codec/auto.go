@@ -0,0 +1,382 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	// maxAutoSampleSize bounds the bytes buffered to detect the format.
+	maxAutoSampleSize = 1024 * 1024
+
+	// autoSampleLines is how many lines are compared to detect CSV.
+	autoSampleLines = 10
+)
+
+// autoContentTypes maps the content types that identify a codec, JSON and CSV
+// are handled separately because they need to be looked at or need options. Generic types
+// like text/plain and application/octet-stream are detected from the content.
+var autoContentTypes = map[string]string{
+	"application/x-ndjson":           JsonStreamcodecId,
+	"application/x-jsonlines":        JsonStreamcodecId,
+	"application/jsonl":              JsonStreamcodecId,
+	"application/msgpack":            MsgpackCodecId,
+	"application/x-msgpack":          MsgpackCodecId,
+	"application/cbor":               CborCodecId,
+	"application/avro":               AvroCodecId,
+	"avro/binary":                    AvroCodecId,
+	"application/vnd.apache.parquet": ParquetCodecId,
+}
+
+// csvSeparators are tried in order to detect CSV files.
+var csvSeparators = []rune{',', '\t', ';', '|'}
+
+// AutoConfig holds the options for the auto codec.
+type AutoConfig struct {
+	// MetadataKey is the object metadata key that names the codec to use, it
+	// takes precedence over the content type and the content. Empty turns it off.
+	MetadataKey string `config:"metadata_key"`
+
+	// SampleSize is how many bytes at the start of the file are used to detect
+	// the format.
+	SampleSize int `config:"sample_size"`
+}
+
+var defaultAutoConfig = AutoConfig{
+	MetadataKey: "x-gcsbeat-codec",
+	SampleSize:  4096,
+}
+
+func (c *AutoConfig) Validate() error {
+	if c.SampleSize < 16 || c.SampleSize > maxAutoSampleSize {
+		return fmt.Errorf("auto sample_size must be between 16 and %d, got %d", maxAutoSampleSize, c.SampleSize)
+	}
+
+	return nil
+}
+
+func newAutoConfig(options *common.Config) (*AutoConfig, error) {
+	config, err := unpackCodecConfig("auto", defaultAutoConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*AutoConfig), nil
+}
+
+// objectInput carries the attributes of the object being read.
+type objectInput struct {
+	io.Reader
+	contentType string
+	metadata    map[string]string
+}
+
+// WithObjectAttributes attaches the content type and metadata of an object to
+// its (decompressed) content so the auto codec can use them. Other codecs
+// ignore them.
+func WithObjectAttributes(input io.Reader, contentType string, metadata map[string]string) io.Reader {
+	return &objectInput{
+		Reader:      input,
+		contentType: contentType,
+		metadata:    metadata,
+	}
+}
+
+func init() {
+	Register(AutoCodecId, NewAutoCodec, defaultAutoConfig)
+}
+
+// NewAutoCodec detects the format of the file and reads it with the matching
+// codec using that codec's defaults.
+func NewAutoCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newAutoConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var contentType string
+	var metadata map[string]string
+	if object, ok := input.(*objectInput); ok {
+		contentType = object.contentType
+		metadata = object.metadata
+		input = object.Reader
+	}
+
+	reader := bufio.NewReaderSize(input, config.SampleSize)
+	sample, err := reader.Peek(config.SampleSize)
+	complete := err == io.EOF
+	if err != nil && !complete {
+		return nil, err
+	}
+
+	id, codecOptions, err := detectCodec(config, contentType, metadata, sample, complete)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := NewCodec(id, codecOptions, path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating detected %s codec: %v", id, err)
+	}
+
+	return &AutoCodec{codec: codec, id: id}, nil
+}
+
+// detectCodec picks the codec from the object's metadata, then its content
+// type, then the sample of its content. complete is true if the sample is
+// the whole file.
+func detectCodec(config *AutoConfig, contentType string, metadata map[string]string, sample []byte, complete bool) (string, *common.Config, error) {
+	if id, ok := metadata[config.MetadataKey]; ok && config.MetadataKey != "" {
+		id = strings.TrimSpace(id)
+		if id == AutoCodecId || !IsValidCodec(id) {
+			return "", nil, fmt.Errorf("metadata %s names unknown codec %q. Use one of: %v", config.MetadataKey, id, ValidCodecs())
+		}
+
+		return id, nil, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	switch mediaType {
+	case "application/json", "text/json":
+		if bytes.HasPrefix(trimSample(sample), []byte("[")) {
+			return JsonArrayCodecId, nil, nil
+		}
+
+		return JsonStreamcodecId, nil, nil
+
+	case "text/csv":
+		return csvWithHeader(',')
+
+	case "text/tab-separated-values":
+		return csvWithHeader('\t')
+	}
+
+	if id, ok := autoContentTypes[mediaType]; ok {
+		return id, nil, nil
+	}
+
+	return sniffCodec(sample, complete)
+}
+
+// sniffCodec detects the codec from the start of the file.
+func sniffCodec(sample []byte, complete bool) (string, *common.Config, error) {
+	switch {
+	case bytes.HasPrefix(sample, []byte(avroMagic)):
+		return AvroCodecId, nil, nil
+	case bytes.HasPrefix(sample, []byte(parquetMagic)):
+		return ParquetCodecId, nil, nil
+	case isBinarySample(sample, complete):
+		return BlobCodecId, nil, nil
+	}
+
+	// only the first line is checked so a bad record later on doesn't stop
+	// the file being read as JSON
+	trimmed := trimSample(sample)
+	firstLine := trimmed
+	if newline := bytes.IndexByte(trimmed, '\n'); newline >= 0 {
+		firstLine = trimmed[:newline]
+	}
+
+	if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') && isJsonPrefix(firstLine) {
+		if trimmed[0] == '[' {
+			return JsonArrayCodecId, nil, nil
+		}
+
+		return JsonStreamcodecId, nil, nil
+	}
+
+	lines := sampleLines(trimmed, complete)
+	if len(lines) > 0 && isSyslogLine(lines[0]) {
+		return SyslogCodecId, nil, nil
+	}
+
+	for _, separator := range csvSeparators {
+		if isCsvWithHeader(lines, separator) {
+			return csvWithHeader(separator)
+		}
+	}
+
+	return TextCodecId, nil, nil
+}
+
+func csvWithHeader(separator rune) (string, *common.Config, error) {
+	options, err := common.NewConfigFrom(map[string]interface{}{
+		"header":    true,
+		"separator": string(separator),
+	})
+
+	return CsvCodecId, options, err
+}
+
+// trimSample removes a byte order mark and leading whitespace.
+func trimSample(sample []byte) []byte {
+	sample = bytes.TrimPrefix(sample, []byte("\xef\xbb\xbf"))
+	return bytes.TrimLeft(sample, " \t\r\n")
+}
+
+// isBinarySample reports whether the sample has NUL bytes or isn't UTF-8. A
+// rune cut off at the end of an incomplete sample is allowed.
+func isBinarySample(sample []byte, complete bool) bool {
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+
+	if !complete {
+		for i := 0; i < utf8.UTFMax && len(sample) > 0; i++ {
+			if r, _ := utf8.DecodeLastRune(sample); r != utf8.RuneError {
+				break
+			}
+
+			sample = sample[:len(sample)-1]
+		}
+	}
+
+	return !utf8.Valid(sample)
+}
+
+// isJsonPrefix reports whether the data is valid JSON up to where it's cut.
+func isJsonPrefix(sample []byte) bool {
+	decoder := json.NewDecoder(bytes.NewReader(sample))
+	for {
+		_, err := decoder.Token()
+		if err == nil {
+			continue
+		}
+
+		_, syntaxErr := err.(*json.SyntaxError)
+		return !syntaxErr
+	}
+}
+
+// sampleLines splits the sample into lines, dropping the last one if it may
+// have been cut off.
+func sampleLines(sample []byte, complete bool) []string {
+	lines := strings.Split(string(sample), "\n")
+	if !complete || lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) > autoSampleLines {
+		lines = lines[:autoSampleLines]
+	}
+
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	return lines
+}
+
+// isSyslogLine checks for a syslog priority or an RFC 3164 timestamp.
+func isSyslogLine(line string) bool {
+	if strings.HasPrefix(line, "<") {
+		_, err := parseSyslogPriority(line, common.MapStr{})
+		return err == nil
+	}
+
+	if len(line) < len(time.Stamp) {
+		return false
+	}
+
+	_, err := time.Parse(time.Stamp, line[:len(time.Stamp)])
+	return err == nil
+}
+
+// isCsvWithHeader checks that there are at least two lines with the same
+// number of fields and the first looks like column names: unique, not empty
+// and not numbers.
+func isCsvWithHeader(lines []string, separator rune) bool {
+	if len(lines) < 2 {
+		return false
+	}
+
+	reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	reader.Comma = separator
+
+	records, err := reader.ReadAll()
+	if err != nil || len(records) < 2 || len(records[0]) < 2 {
+		return false
+	}
+
+	seen := make(map[string]bool)
+	for _, column := range records[0] {
+		column = strings.TrimSpace(column)
+		if column == "" || seen[column] {
+			return false
+		}
+
+		if _, err := strconv.ParseFloat(column, 64); err == nil {
+			return false
+		}
+
+		seen[column] = true
+	}
+
+	return true
+}
+
+// AutoCodec reads a file with the codec detected for it and records the
+// codec's ID on each event.
+type AutoCodec struct {
+	codec Codec
+	id    string
+}
+
+func (codec *AutoCodec) Next() bool {
+	return codec.codec.Next()
+}
+
+func (codec *AutoCodec) Value() common.MapStr {
+	value := codec.codec.Value()
+	value["codec"] = codec.id
+	return value
+}
+
+func (codec *AutoCodec) Err() error {
+	return codec.codec.Err()
+}
+
+// Recover skips bad records if the detected codec can.
+func (codec *AutoCodec) Recover() (common.MapStr, error) {
+	recoverable, ok := codec.codec.(RecoverableCodec)
+	if !ok {
+		return nil, errNotRecoverable
+	}
+
+	event, err := recoverable.Recover()
+	if err != nil {
+		return nil, err
+	}
+
+	event["codec"] = codec.id
+	return event, nil
+}
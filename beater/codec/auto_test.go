@@ -0,0 +1,144 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestAutoCodecDetect(t *testing.T) {
+	cases := map[string]struct {
+		Content     string
+		ContentType string
+		Metadata    map[string]string
+		Options     map[string]interface{}
+		Codec       string
+		ExpectErr   bool
+	}{
+		"json array":            {Content: "  [{\"a\":1},\n{\"a\":2}]", Codec: JsonArrayCodecId},
+		"ndjson":                {Content: "{\"a\":1}\n{\"a\":2}\n", Codec: JsonStreamcodecId},
+		"byte order mark":       {Content: "\xef\xbb\xbf{\"a\":1}", Codec: JsonStreamcodecId},
+		"bracketed log":         {Content: "[2018-01-01] INFO started\n", Codec: TextCodecId},
+		"braced log":            {Content: "{main} started\n", Codec: TextCodecId},
+		"bad later record":      {Content: "{\"a\":1}\nnope\n", Codec: JsonStreamcodecId},
+		"pretty printed":        {Content: "{\n  \"a\": 1\n}\n", Codec: JsonStreamcodecId},
+		"csv":                   {Content: "name,size\nfoo,1\nbar,2\n", Codec: CsvCodecId},
+		"tsv":                   {Content: "name\tsize\nfoo\t1\n", Codec: CsvCodecId},
+		"numeric header":        {Content: "1,2\n3,4\n", Codec: TextCodecId},
+		"ragged csv":            {Content: "a,b\nc,d,e\n", Codec: TextCodecId},
+		"header only":           {Content: "name,size\n", Codec: TextCodecId},
+		"syslog priority":       {Content: "<34>1 2003-10-11T22:14:15.003Z host app - - - msg\n", Codec: SyslogCodecId},
+		"syslog timestamp":      {Content: "Oct 11 22:14:15 host app: msg\n", Codec: SyslogCodecId},
+		"text":                  {Content: "hello world\nsecond line\n", Codec: TextCodecId},
+		"empty":                 {Content: "", Codec: TextCodecId},
+		"binary":                {Content: "\x1f\x8b\x08\x00\x00", Codec: BlobCodecId},
+		"invalid utf8":          {Content: "caf\xe9\n", Codec: BlobCodecId},
+		"avro":                  {Content: avroMagic, Codec: AvroCodecId},
+		"cut off rune":          {Content: "caf\xc3\xa9\xc3\xa9", Options: map[string]interface{}{"sample_size": 16}, Codec: TextCodecId},
+		"json content type":     {Content: "{\"a\":1}", ContentType: "application/json; charset=utf-8", Codec: JsonStreamcodecId},
+		"json array type":       {Content: "[]", ContentType: "application/json", Codec: JsonArrayCodecId},
+		"ndjson content type":   {Content: "x", ContentType: "application/x-ndjson", Codec: JsonStreamcodecId},
+		"csv content type":      {Content: "1,2\n", ContentType: "text/csv", Codec: CsvCodecId},
+		"generic content type":  {Content: "{\"a\":1}", ContentType: "application/octet-stream", Codec: JsonStreamcodecId},
+		"metadata":              {Content: "{\"a\":1}", ContentType: "application/json", Metadata: map[string]string{"x-gcsbeat-codec": "logfmt"}, Codec: LogfmtCodecId},
+		"custom metadata key":   {Content: "a=1", Metadata: map[string]string{"codec": " logfmt "}, Options: map[string]interface{}{"metadata_key": "codec"}, Codec: LogfmtCodecId},
+		"other metadata":        {Content: "a=1", Metadata: map[string]string{"codec": "logfmt"}, Codec: TextCodecId},
+		"unknown metadata":      {Metadata: map[string]string{"x-gcsbeat-codec": "foo"}, ExpectErr: true},
+		"auto metadata":         {Metadata: map[string]string{"x-gcsbeat-codec": "auto"}, ExpectErr: true},
+		"detected needs option": {Metadata: map[string]string{"x-gcsbeat-codec": "xml"}, ExpectErr: true},
+		"bad sample size":       {Options: map[string]interface{}{"sample_size": 1}, ExpectErr: true},
+	}
+
+	for tn, tc := range cases {
+		input := WithObjectAttributes(strings.NewReader(tc.Content), tc.ContentType, tc.Metadata)
+		c, err := NewAutoCodec(testOptions(t, tc.Options), "testfile", input)
+
+		hasErr := err != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, err, tc.ExpectErr)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if id := c.(*AutoCodec).id; id != tc.Codec {
+			t.Errorf("%q | Expected the %s codec, got %s", tn, tc.Codec, id)
+		}
+	}
+}
+
+func TestAutoCodecValue(t *testing.T) {
+	c, err := NewAutoCodec(nil, "file/path", strings.NewReader("name,size\nfoo,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.Next() {
+		t.Fatalf("Quit too early: %v", c.Err())
+	}
+
+	expected := fmt.Sprintf("%v", common.MapStr{
+		"codec": CsvCodecId,
+		"csv":   common.MapStr{"name": "foo", "size": "1"},
+		"file":  "file/path",
+		"line":  1,
+	})
+
+	actual := fmt.Sprintf("%v", c.Value())
+	if expected != actual {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
+
+func TestAutoCodecRecover(t *testing.T) {
+	c, err := NewAutoCodec(nil, "file/path", strings.NewReader("{\"a\":1}\nnope\n{\"a\":2}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for c.Next() {
+	}
+
+	if c.Err() == nil {
+		t.Fatal("Expected an error")
+	}
+
+	event, err := c.(RecoverableCodec).Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if event["codec"] != JsonStreamcodecId {
+		t.Errorf("Expected the codec on the error event, got %v", event)
+	}
+
+	if !c.Next() {
+		t.Errorf("Expected to continue after the bad record: %v", c.Err())
+	}
+
+	text, _ := NewAutoCodec(nil, "file/path", strings.NewReader("line"))
+	if _, err := text.(RecoverableCodec).Recover(); err != errNotRecoverable {
+		t.Errorf("Expected text not to be recoverable, got %v", err)
+	}
+}
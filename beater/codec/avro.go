@@ -0,0 +1,823 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec/internal/zstd"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	// maxAvroBlockSize protects against allocating huge buffers for corrupt
+	// block and value lengths.
+	maxAvroBlockSize = 256 * 1024 * 1024
+
+	avroMagic = "Obj\x01"
+)
+
+// avroInput is satisfied by both the buffered file reader and block readers.
+type avroInput interface {
+	io.Reader
+	io.ByteReader
+}
+
+func init() {
+	Register(AvroCodecId, withoutOptions(NewAvroCodec), nil)
+}
+
+func NewAvroCodec(path string, input io.Reader) Codec {
+	codec := &AvroCodec{
+		input: bufio.NewReader(input),
+		path:  path,
+	}
+
+	codec.init()
+
+	return codec
+}
+
+// AvroCodec reads an Avro Object Container File one block at a time, sending
+// one event per datum with the record fields under "avro". The writer schema
+// is read from the file header.
+type AvroCodec struct {
+	input       *bufio.Reader
+	schema      *avroSchema
+	compression string
+	sync        []byte
+	block       *bytes.Reader
+	remaining   int64
+	value       common.MapStr
+	err         error
+	lineNumber  int
+	path        string
+}
+
+func (codec *AvroCodec) init() {
+	magic := make([]byte, len(avroMagic))
+	if _, err := io.ReadFull(codec.input, magic); err != nil || string(magic) != avroMagic {
+		codec.err = errors.New("Not an Avro object container file.")
+		return
+	}
+
+	metadata, err := readAvroMetadata(codec.input)
+	if err != nil {
+		codec.err = fmt.Errorf("error reading avro header: %v", err)
+		return
+	}
+
+	codec.schema, err = parseAvroSchema(metadata["avro.schema"])
+	if err != nil {
+		codec.err = fmt.Errorf("error parsing avro schema: %v", err)
+		return
+	}
+
+	codec.compression = string(metadata["avro.codec"])
+	switch codec.compression {
+	case "", "null", "deflate", "snappy", "zstandard":
+	default:
+		codec.err = fmt.Errorf("unsupported avro codec %q, supported codecs are null, deflate, snappy and zstandard", codec.compression)
+		return
+	}
+
+	codec.sync = make([]byte, 16)
+	if _, err := io.ReadFull(codec.input, codec.sync); err != nil {
+		codec.err = fmt.Errorf("error reading avro header: %v", err)
+	}
+}
+
+func readAvroMetadata(input avroInput) (map[string][]byte, error) {
+	metadata := make(map[string][]byte)
+
+	for {
+		count, err := readAvroLong(input)
+		if err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			return metadata, nil
+		}
+
+		if count < 0 {
+			// the block size follows negative counts
+			count = -count
+			if _, err := readAvroLong(input); err != nil {
+				return nil, err
+			}
+		}
+
+		for ; count > 0; count-- {
+			key, err := readAvroBytes(input)
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := readAvroBytes(input)
+			if err != nil {
+				return nil, err
+			}
+
+			metadata[string(key)] = value
+		}
+	}
+}
+
+func (codec *AvroCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for codec.remaining == 0 {
+		if !codec.nextBlock() {
+			return false
+		}
+	}
+
+	datum, err := decodeAvro(codec.block, codec.schema)
+	if err != nil {
+		codec.err = fmt.Errorf("error decoding avro datum %d: %v", codec.lineNumber+1, err)
+		return false
+	}
+
+	fields, ok := datum.(common.MapStr)
+	if !ok {
+		fields = common.MapStr{"value": datum}
+	}
+
+	codec.remaining--
+	codec.lineNumber++
+	codec.value = common.MapStr{
+		"avro": fields,
+		"file": codec.path,
+		"line": codec.lineNumber,
+	}
+
+	return true
+}
+
+// nextBlock reads and decompresses the next block, it returns false at the
+// end of the file or on error.
+func (codec *AvroCodec) nextBlock() bool {
+	count, err := readAvroLong(codec.input)
+	if err == io.EOF {
+		return false
+	}
+
+	if err != nil {
+		codec.err = err
+		return false
+	}
+
+	size, err := readAvroLong(codec.input)
+	if err != nil {
+		codec.err = err
+		return false
+	}
+
+	if count < 0 || size < 0 || size > maxAvroBlockSize {
+		codec.err = fmt.Errorf("invalid avro block of %d objects in %d bytes", count, size)
+		return false
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(codec.input, data); err != nil {
+		codec.err = fmt.Errorf("error reading avro block: %v", err)
+		return false
+	}
+
+	sync := make([]byte, len(codec.sync))
+	if _, err := io.ReadFull(codec.input, sync); err != nil || !bytes.Equal(sync, codec.sync) {
+		codec.err = errors.New("avro block isn't followed by the file's sync marker")
+		return false
+	}
+
+	data, err = decompressAvroBlock(codec.compression, data)
+	if err != nil {
+		codec.err = fmt.Errorf("error decompressing avro block: %v", err)
+		return false
+	}
+
+	codec.block = bytes.NewReader(data)
+	codec.remaining = count
+	return true
+}
+
+func decompressAvroBlock(compression string, data []byte) ([]byte, error) {
+	switch compression {
+	case "deflate":
+		return ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+
+	case "snappy":
+		// blocks end with the CRC-32 of the uncompressed data
+		if len(data) < 4 {
+			return nil, errors.New("snappy block is missing its checksum")
+		}
+
+		decoded, err := decodeSnappy(data[:len(data)-4])
+		if err != nil {
+			return nil, err
+		}
+
+		if crc32.ChecksumIEEE(decoded) != binary.BigEndian.Uint32(data[len(data)-4:]) {
+			return nil, errors.New("snappy block checksum mismatch")
+		}
+
+		return decoded, nil
+
+	case "zstandard":
+		return decodeZstd(data, maxAvroBlockSize)
+	}
+
+	return data, nil
+}
+
+// decodeZstd decodes zstd compressed data, which must be no larger than limit
+// bytes once it's decoded.
+func decodeZstd(data []byte, limit int64) ([]byte, error) {
+	// one more byte than allowed tells if the data is too large
+	decoded, err := ioutil.ReadAll(io.LimitReader(zstd.NewReader(bytes.NewReader(data)), limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(decoded)) > limit {
+		return nil, fmt.Errorf("zstd data is larger than %d bytes", limit)
+	}
+
+	return decoded, nil
+}
+
+// decodeSnappy decodes a block in the snappy format (not the framed stream
+// format) https://github.com/google/snappy/blob/master/format_description.txt
+func decodeSnappy(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > maxAvroBlockSize {
+		return nil, errors.New("invalid snappy length")
+	}
+
+	src = src[n:]
+	dst := make([]byte, 0, length)
+
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+
+		var size, offset int
+		switch tag & 0x03 {
+		case 0x00:
+			size = int(tag >> 2)
+			if size >= 60 {
+				extra := size - 59
+				if len(src) < extra {
+					return nil, errors.New("truncated snappy literal")
+				}
+
+				size = 0
+				for i := extra - 1; i >= 0; i-- {
+					size = size<<8 | int(src[i])
+				}
+
+				src = src[extra:]
+			}
+
+			size++
+			if size <= 0 || len(src) < size {
+				return nil, errors.New("truncated snappy literal")
+			}
+
+			if uint64(len(dst)+size) > length {
+				return nil, errors.New("snappy block is longer than its declared length")
+			}
+
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+
+		case 0x01:
+			if len(src) < 1 {
+				return nil, errors.New("truncated snappy copy")
+			}
+
+			size = 4 + int(tag>>2)&0x07
+			offset = int(tag&0xe0)<<3 | int(src[0])
+			src = src[1:]
+
+		case 0x02:
+			if len(src) < 2 {
+				return nil, errors.New("truncated snappy copy")
+			}
+
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+
+		case 0x03:
+			if len(src) < 4 {
+				return nil, errors.New("truncated snappy copy")
+			}
+
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src))
+			src = src[4:]
+		}
+
+		if offset <= 0 || offset > len(dst) {
+			return nil, fmt.Errorf("invalid snappy copy offset %d", offset)
+		}
+
+		if uint64(len(dst)+size) > length {
+			return nil, errors.New("snappy block is longer than its declared length")
+		}
+
+		// copies may overlap the bytes they produce
+		start := len(dst) - offset
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, fmt.Errorf("snappy block decoded to %d bytes, expected %d", len(dst), length)
+	}
+
+	return dst, nil
+}
+
+func readAvroLong(input avroInput) (int64, error) {
+	// Avro longs are zig-zag varints, the same as encoding/binary
+	return binary.ReadVarint(input)
+}
+
+func readAvroBytes(input avroInput) ([]byte, error) {
+	length, err := readAvroLong(input)
+	if err != nil {
+		return nil, err
+	}
+
+	if length < 0 || length > maxAvroBlockSize {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(input, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// avroSchema is a parsed Avro schema, Type is a primitive type name or one of
+// record, enum, array, map, union or fixed.
+type avroSchema struct {
+	Type        string
+	Name        string
+	Fields      []avroField
+	Symbols     []string
+	Items       *avroSchema
+	Values      *avroSchema
+	Branches    []*avroSchema
+	Size        int
+	LogicalType string
+	Scale       int
+}
+
+type avroField struct {
+	Name   string
+	Schema *avroSchema
+}
+
+var avroPrimitives = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"int":     true,
+	"long":    true,
+	"float":   true,
+	"double":  true,
+	"bytes":   true,
+	"string":  true,
+}
+
+func parseAvroSchema(data []byte) (*avroSchema, error) {
+	if len(data) == 0 {
+		return nil, errors.New("the file has no schema")
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	parser := &avroSchemaParser{named: make(map[string]*avroSchema)}
+	return parser.parse(raw, "")
+}
+
+// avroSchemaParser tracks named types so later parts of a schema, or the
+// type itself, can refer to them.
+type avroSchemaParser struct {
+	named map[string]*avroSchema
+}
+
+func (p *avroSchemaParser) parse(raw interface{}, namespace string) (*avroSchema, error) {
+	switch t := raw.(type) {
+	case string:
+		return p.lookup(t, namespace)
+
+	case []interface{}:
+		union := &avroSchema{Type: "union"}
+		for _, branch := range t {
+			schema, err := p.parse(branch, namespace)
+			if err != nil {
+				return nil, err
+			}
+
+			union.Branches = append(union.Branches, schema)
+		}
+
+		return union, nil
+
+	case map[string]interface{}:
+		return p.parseComplex(t, namespace)
+	}
+
+	return nil, fmt.Errorf("invalid schema %v", raw)
+}
+
+func (p *avroSchemaParser) lookup(name, namespace string) (*avroSchema, error) {
+	if avroPrimitives[name] {
+		return &avroSchema{Type: name}, nil
+	}
+
+	if schema, ok := p.named[name]; ok {
+		return schema, nil
+	}
+
+	if schema, ok := p.named[namespace+"."+name]; ok {
+		return schema, nil
+	}
+
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+func (p *avroSchemaParser) parseComplex(raw map[string]interface{}, namespace string) (*avroSchema, error) {
+	typeName, ok := raw["type"].(string)
+	if !ok {
+		// e.g. {"type": {"type": "array", ...}}
+		return p.parse(raw["type"], namespace)
+	}
+
+	schema := &avroSchema{Type: typeName}
+	logicalType, _ := raw["logicalType"].(string)
+	scale, _ := raw["scale"].(float64)
+
+	switch typeName {
+	case "record", "error", "enum", "fixed":
+		name, ok := raw["name"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s schema has no name", typeName)
+		}
+
+		if ns, ok := raw["namespace"].(string); ok {
+			namespace = ns
+		}
+
+		if i := strings.LastIndex(name, "."); i >= 0 {
+			namespace = name[:i]
+		} else if namespace != "" {
+			name = namespace + "." + name
+		}
+
+		schema.Name = name
+		p.named[name] = schema
+
+	case "array", "map":
+
+	default:
+		if !avroPrimitives[typeName] {
+			return p.lookup(typeName, namespace)
+		}
+	}
+
+	switch typeName {
+	case "record", "error":
+		schema.Type = "record"
+		fields, _ := raw["fields"].([]interface{})
+		for _, f := range fields {
+			field, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("invalid field in record %q", schema.Name)
+			}
+
+			name, _ := field["name"].(string)
+			fieldSchema, err := p.parse(field["type"], namespace)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %v", name, err)
+			}
+
+			schema.Fields = append(schema.Fields, avroField{Name: name, Schema: fieldSchema})
+		}
+
+	case "enum":
+		symbols, _ := raw["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			schema.Symbols = append(schema.Symbols, fmt.Sprintf("%v", symbol))
+		}
+
+	case "array":
+		items, err := p.parse(raw["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		schema.Items = items
+
+	case "map":
+		values, err := p.parse(raw["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		schema.Values = values
+
+	case "fixed":
+		size, _ := raw["size"].(float64)
+		if size < 0 {
+			return nil, fmt.Errorf("fixed %q has a negative size", schema.Name)
+		}
+
+		schema.Size = int(size)
+	}
+
+	schema.LogicalType = logicalType
+	schema.Scale = int(scale)
+	return schema, nil
+}
+
+// decodeAvro decodes one datum. Records and maps become common.MapStr, unions
+// become the value of the branch that was written and logical types are
+// converted: dates and timestamps to time.Time, times of day and decimals to
+// strings.
+func decodeAvro(input *bytes.Reader, schema *avroSchema) (interface{}, error) {
+	switch schema.Type {
+	case "null":
+		return nil, nil
+
+	case "boolean":
+		b, err := input.ReadByte()
+		return b != 0, err
+
+	case "int":
+		v, err := readAvroLong(input)
+		if err != nil {
+			return nil, err
+		}
+
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("int %d out of range", v)
+		}
+
+		switch schema.LogicalType {
+		case "date":
+			return time.Unix(v*24*60*60, 0).UTC(), nil
+		case "time-millis":
+			return formatTimeOfDay(time.Duration(v)*time.Millisecond, "15:04:05.000"), nil
+		}
+
+		return int32(v), nil
+
+	case "long":
+		v, err := readAvroLong(input)
+		if err != nil {
+			return nil, err
+		}
+
+		switch schema.LogicalType {
+		case "timestamp-millis", "local-timestamp-millis":
+			return time.Unix(v/1e3, (v%1e3)*1e6).UTC(), nil
+		case "timestamp-micros", "local-timestamp-micros":
+			return time.Unix(v/1e6, (v%1e6)*1e3).UTC(), nil
+		case "timestamp-nanos", "local-timestamp-nanos":
+			return time.Unix(0, v).UTC(), nil
+		case "time-micros":
+			return formatTimeOfDay(time.Duration(v)*time.Microsecond, "15:04:05.000000"), nil
+		}
+
+		return v, nil
+
+	case "float":
+		var bits [4]byte
+		if _, err := io.ReadFull(input, bits[:]); err != nil {
+			return nil, err
+		}
+
+		return math.Float32frombits(binary.LittleEndian.Uint32(bits[:])), nil
+
+	case "double":
+		var bits [8]byte
+		if _, err := io.ReadFull(input, bits[:]); err != nil {
+			return nil, err
+		}
+
+		return math.Float64frombits(binary.LittleEndian.Uint64(bits[:])), nil
+
+	case "bytes", "string":
+		data, err := readAvroBytes(input)
+		if err != nil {
+			return nil, err
+		}
+
+		if schema.Type == "string" {
+			return string(data), nil
+		}
+
+		return avroBytesValue(data, schema), nil
+
+	case "fixed":
+		data := make([]byte, schema.Size)
+		if _, err := io.ReadFull(input, data); err != nil {
+			return nil, err
+		}
+
+		return avroBytesValue(data, schema), nil
+
+	case "enum":
+		index, err := readAvroLong(input)
+		if err != nil {
+			return nil, err
+		}
+
+		if index < 0 || index >= int64(len(schema.Symbols)) {
+			return nil, fmt.Errorf("enum index %d out of range", index)
+		}
+
+		return schema.Symbols[index], nil
+
+	case "union":
+		index, err := readAvroLong(input)
+		if err != nil {
+			return nil, err
+		}
+
+		if index < 0 || index >= int64(len(schema.Branches)) {
+			return nil, fmt.Errorf("union index %d out of range", index)
+		}
+
+		return decodeAvro(input, schema.Branches[index])
+
+	case "record":
+		fields := common.MapStr{}
+		for _, field := range schema.Fields {
+			value, err := decodeAvro(input, field.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %v", field.Name, err)
+			}
+
+			fields[field.Name] = value
+		}
+
+		return fields, nil
+
+	case "array":
+		items := []interface{}{}
+		err := readAvroBlocks(input, func() error {
+			item, err := decodeAvro(input, schema.Items)
+			items = append(items, item)
+			return err
+		})
+
+		return items, err
+
+	case "map":
+		values := common.MapStr{}
+		err := readAvroBlocks(input, func() error {
+			key, err := readAvroBytes(input)
+			if err != nil {
+				return err
+			}
+
+			value, err := decodeAvro(input, schema.Values)
+			values[string(key)] = value
+			return err
+		})
+
+		return values, err
+	}
+
+	return nil, fmt.Errorf("unknown type %q", schema.Type)
+}
+
+// readAvroBlocks calls readItem for each item of an array or map.
+func readAvroBlocks(input *bytes.Reader, readItem func() error) error {
+	for {
+		count, err := readAvroLong(input)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		if count < 0 {
+			// the block size follows negative counts
+			count = -count
+			if _, err := readAvroLong(input); err != nil {
+				return err
+			}
+		}
+
+		for ; count > 0; count-- {
+			if err := readItem(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func avroBytesValue(data []byte, schema *avroSchema) interface{} {
+	switch schema.LogicalType {
+	case "decimal":
+		return formatDecimal(decimalFromBytes(data), schema.Scale)
+
+	case "duration":
+		if len(data) == 12 {
+			return common.MapStr{
+				"months":       binary.LittleEndian.Uint32(data[0:4]),
+				"days":         binary.LittleEndian.Uint32(data[4:8]),
+				"milliseconds": binary.LittleEndian.Uint32(data[8:12]),
+			}
+		}
+	}
+
+	return data
+}
+
+// decimalFromBytes reads a big-endian two's complement unscaled decimal value.
+func decimalFromBytes(data []byte) *big.Int {
+	unscaled := new(big.Int).SetBytes(data)
+	if len(data) > 0 && data[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(data)*8)))
+	}
+
+	return unscaled
+}
+
+// formatDecimal formats an unscaled value as a decimal string so no precision
+// is lost.
+func formatDecimal(unscaled *big.Int, scale int) string {
+	digits := unscaled.String()
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	if scale <= 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+
+	point := len(digits) - scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+func formatTimeOfDay(d time.Duration, layout string) string {
+	return time.Unix(0, 0).UTC().Add(d).Format(layout)
+}
+
+func (codec *AvroCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *AvroCodec) Err() error {
+	return codec.err
+}
@@ -0,0 +1,426 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const testAvroSchema = `{
+	"type": "record",
+	"name": "Row",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": ["null", "int"]},
+		{"name": "ts", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+		{"name": "day", "type": {"type": "int", "logicalType": "date"}},
+		{"name": "price", "type": {"type": "bytes", "logicalType": "decimal", "precision": 9, "scale": 2}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "counts", "type": {"type": "map", "values": "long"}},
+		{"name": "color", "type": {"type": "enum", "name": "Color", "symbols": ["RED", "GREEN"]}},
+		{"name": "ratio", "type": "double"},
+		{"name": "ok", "type": "boolean"},
+		{"name": "next", "type": ["null", "com.example.Row"]}
+	]
+}`
+
+var testAvroSync = []byte("0123456789abcdef")
+
+func avroLong(v int64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutVarint(buf, v)]
+}
+
+func avroString(s string) []byte {
+	return append(avroLong(int64(len(s))), s...)
+}
+
+func avroDouble(f float64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, math.Float64bits(f))
+	return buf
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// testAvroRow encodes a Row, next is the encoded nested row or nil.
+func testAvroRow(name string, age int64, next []byte) []byte {
+	row := concat(
+		avroString(name),
+		avroLong(1), avroLong(age),
+		avroLong(1500000000123456),
+		avroLong(17000),
+		avroLong(2), []byte{0xCF, 0xC7},
+		avroLong(2), avroString("x"), avroString("y"), avroLong(0),
+		avroLong(1), avroString("k"), avroLong(5), avroLong(0),
+		avroLong(1),
+		avroDouble(0.5),
+		[]byte{1},
+	)
+
+	if next == nil {
+		return concat(row, avroLong(0))
+	}
+
+	return concat(row, avroLong(1), next)
+}
+
+// testSnappy encodes data as snappy literals.
+func testSnappy(data []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	out := buf[:binary.PutUvarint(buf, uint64(len(data)))]
+	for len(data) > 0 {
+		n := len(data)
+		if n > 60000 {
+			n = 60000
+		}
+
+		out = append(out, 61<<2, byte(n-1), byte((n-1)>>8))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+
+	return out
+}
+
+// testZstd encodes data as a zstandard frame of raw blocks.
+func testZstd(data []byte) []byte {
+	// the magic number then a frame header with a 2MiB window and no checksum
+	out := []byte{0x28, 0xB5, 0x2F, 0xFD, 0x00, 0x58}
+	for {
+		n := len(data)
+		if n > 100000 {
+			n = 100000
+		}
+
+		// the size, a raw block type and whether it's the last block
+		header := uint32(n) << 3
+		if n == len(data) {
+			header |= 1
+		}
+
+		out = append(out, byte(header), byte(header>>8), byte(header>>16))
+		out = append(out, data[:n]...)
+		data = data[n:]
+
+		if header&1 == 1 {
+			return out
+		}
+	}
+}
+
+// testAvroFile builds an object container file with one block per entry in blocks.
+func testAvroFile(schema, compression string, blocks ...[][]byte) []byte {
+	file := concat(
+		[]byte(avroMagic),
+		avroLong(2),
+		avroString("avro.schema"), avroString(schema),
+		avroString("avro.codec"), avroString(compression),
+		avroLong(0),
+		testAvroSync,
+	)
+
+	for _, block := range blocks {
+		data := concat(block...)
+
+		switch compression {
+		case "deflate":
+			var buf bytes.Buffer
+			w, _ := flate.NewWriter(&buf, flate.BestCompression)
+			w.Write(data)
+			w.Close()
+			data = buf.Bytes()
+
+		case "snappy":
+			crc := make([]byte, 4)
+			binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(data))
+			data = concat(testSnappy(data), crc)
+
+		case "zstandard":
+			data = testZstd(data)
+		}
+
+		file = concat(file, avroLong(int64(len(block))), avroLong(int64(len(data))), data, testAvroSync)
+	}
+
+	return file
+}
+
+func TestAvroCodecNextErr(t *testing.T) {
+	row := testAvroRow("a", 1, nil)
+	valid := testAvroFile(testAvroSchema, "null", [][]byte{row, row})
+
+	cases := map[string]struct {
+		Data      []byte
+		Length    int
+		ExpectErr bool
+	}{
+		"no blocks": {
+			Data:   testAvroFile(testAvroSchema, "null"),
+			Length: 0,
+		},
+		"empty block": {
+			Data:   testAvroFile(testAvroSchema, "null", [][]byte{}, [][]byte{row}),
+			Length: 1,
+		},
+		"not avro": {
+			Data:      []byte(`{"json": true}`),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"unsupported codec": {
+			Data:      testAvroFile(testAvroSchema, "bzip2", [][]byte{row}),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"bad schema": {
+			Data:      testAvroFile(`{"type": "record", "name": "r", "fields": [{"name": "a", "type": "Missing"}]}`, "null"),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"bad sync marker": {
+			Data:      concat(valid[:len(valid)-1], []byte("!")),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"truncated block": {
+			Data:      valid[:len(valid)-20],
+			Length:    0,
+			ExpectErr: true,
+		},
+		"bad datum": {
+			Data:      testAvroFile(testAvroSchema, "null", [][]byte{row, row[:5]}),
+			Length:    1,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewAvroCodec("testfile", bytes.NewReader(tc.Data))
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d records, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestAvroCodecValue(t *testing.T) {
+	rows := [][]byte{
+		testAvroRow("a", 30, nil),
+		testAvroRow("b", 40, testAvroRow("c", 50, nil)),
+	}
+
+	row := func(name string, age int32, next interface{}) common.MapStr {
+		return common.MapStr{
+			"name":   name,
+			"age":    age,
+			"ts":     time.Date(2017, 7, 14, 2, 40, 0, 123456000, time.UTC),
+			"day":    time.Date(2016, 7, 18, 0, 0, 0, 0, time.UTC),
+			"price":  "-123.45",
+			"tags":   []interface{}{"x", "y"},
+			"counts": common.MapStr{"k": int64(5)},
+			"color":  "GREEN",
+			"ratio":  0.5,
+			"ok":     true,
+			"next":   next,
+		}
+	}
+
+	expected := []common.MapStr{
+		row("a", 30, nil),
+		row("b", 40, row("c", 50, nil)),
+	}
+
+	for _, compression := range []string{"", "null", "deflate", "snappy", "zstandard"} {
+		// split the rows over two blocks
+		data := testAvroFile(testAvroSchema, compression, rows[:1], rows[1:])
+		c := NewAvroCodec("testfile", bytes.NewReader(data))
+
+		for i, fields := range expected {
+			if !c.Next() {
+				t.Fatalf("%q | Quit too early: %v", compression, c.Err())
+			}
+
+			expectedS := fmt.Sprintf("%v", common.MapStr{"avro": fields, "file": "testfile", "line": i + 1})
+			actualS := fmt.Sprintf("%v", c.Value())
+			if expectedS != actualS {
+				t.Errorf("%q | Expected %v, got %v", compression, expectedS, actualS)
+			}
+		}
+
+		if c.Next() || c.Err() != nil {
+			t.Errorf("%q | Expected the end of the file, got %v", compression, c.Err())
+		}
+	}
+}
+
+func TestAvroCodecPrimitiveSchema(t *testing.T) {
+	data := testAvroFile(`"string"`, "null", [][]byte{avroString("hello")})
+	c := NewAvroCodec("testfile", bytes.NewReader(data))
+
+	if !c.Next() {
+		t.Fatalf("Quit too early: %v", c.Err())
+	}
+
+	if v, _ := c.Value().GetValue("avro.value"); v != "hello" {
+		t.Errorf("Expected avro.value to be 'hello', got %v", v)
+	}
+}
+
+func TestDecodeSnappy(t *testing.T) {
+	cases := map[string]struct {
+		Data      []byte
+		Expected  string
+		ExpectErr bool
+	}{
+		"literal": {
+			Data:     []byte{3, 0x08, 'a', 'b', 'c'},
+			Expected: "abc",
+		},
+		"one byte offset copy": {
+			Data:     []byte{12, 0x08, 'a', 'b', 'c', 0x15, 0x03},
+			Expected: "abcabcabcabc",
+		},
+		"two byte offset copy": {
+			Data:     []byte{9, 0x08, 'x', 'y', 'z', 0x16, 0x03, 0x00},
+			Expected: "xyzxyzxyz",
+		},
+		"offset before start": {
+			Data:      []byte{7, 0x08, 'a', 'b', 'c', 0x01, 0x09},
+			ExpectErr: true,
+		},
+		"too long": {
+			Data:      []byte{2, 0x08, 'a', 'b', 'c'},
+			ExpectErr: true,
+		},
+		"too short": {
+			Data:      []byte{4, 0x08, 'a', 'b', 'c'},
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		actual, err := decodeSnappy(tc.Data)
+		if (err != nil) != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, err, tc.ExpectErr)
+		}
+
+		if err == nil && string(actual) != tc.Expected {
+			t.Errorf("%q | Expected %q, got %q", tn, tc.Expected, actual)
+		}
+	}
+}
+
+func TestDecompressAvroZstandard(t *testing.T) {
+	// the first ten lines of 99 bottles of beer compressed by the zstd tool,
+	// which uses FSE and Huffman coded blocks
+	song := "28b52ffd0468050200a402393920626f74746c6573206f662062656572206f6e207468652077616c6c0a" +
+		"39383736353433323130200928d09b0310feff29c107c8e464726311369101dac9a6d3"
+
+	var lines []string
+	for i := 99; i > 89; i-- {
+		lines = append(lines, fmt.Sprintf("%d bottles of beer on the wall\n", i))
+	}
+
+	// "hello, world\n" with a checksum
+	hello := "28b52ffd0458690000" + hex.EncodeToString([]byte("hello, world\n")) + "4c1ff9f1"
+
+	cases := map[string]struct {
+		Data      string
+		Expected  string
+		ExpectErr bool
+	}{
+		"compressed": {
+			Data:     song,
+			Expected: strings.Join(lines, ""),
+		},
+		"raw blocks": {
+			Data:     hex.EncodeToString(testZstd(bytes.Repeat([]byte("abc"), 100000))),
+			Expected: strings.Repeat("abc", 100000),
+		},
+		"checksum": {
+			Data:     hello,
+			Expected: "hello, world\n",
+		},
+		"checksum mismatch": {
+			Data:      hello[:len(hello)-2] + "00",
+			ExpectErr: true,
+		},
+		"truncated": {
+			Data:      song[:80],
+			ExpectErr: true,
+		},
+		"not zstandard": {
+			Data:      hex.EncodeToString([]byte("hello")),
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		data, _ := hex.DecodeString(tc.Data)
+		actual, err := decompressAvroBlock("zstandard", data)
+		if (err != nil) != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, err, tc.ExpectErr)
+		}
+
+		if err == nil && string(actual) != tc.Expected {
+			t.Errorf("%q | Expected %q, got %q", tn, tc.Expected, actual)
+		}
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	cases := map[string]struct {
+		Data     []byte
+		Scale    int
+		Expected string
+	}{
+		"empty":           {Data: []byte{}, Scale: 0, Expected: "0"},
+		"negative":        {Data: []byte{0xFF}, Scale: 0, Expected: "-1"},
+		"leading zeros":   {Data: []byte{0x01}, Scale: 3, Expected: "0.001"},
+		"negative scaled": {Data: []byte{0xFF, 0x38}, Scale: 2, Expected: "-2.00"},
+		"large":           {Data: []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, Scale: 4, Expected: "1844674407370955.1616"},
+	}
+
+	for tn, tc := range cases {
+		if actual := formatDecimal(decimalFromBytes(tc.Data), tc.Scale); actual != tc.Expected {
+			t.Errorf("%q | Expected %q, got %q", tn, tc.Expected, actual)
+		}
+	}
+}
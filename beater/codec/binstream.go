@@ -0,0 +1,130 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	// maxBinaryStreamLength protects against allocating huge strings, arrays
+	// and maps for corrupt lengths.
+	maxBinaryStreamLength = 64 * 1024 * 1024
+
+	// maxBinaryStreamDepth stops deeply nested documents
+	maxBinaryStreamDepth = 100
+)
+
+// binaryDecoder decodes one self-describing value from the input.
+type binaryDecoder interface {
+	decode(depth int) (interface{}, error)
+}
+
+func init() {
+	Register(MsgpackCodecId, withoutOptions(NewMsgpackCodec), nil)
+	Register(CborCodecId, withoutOptions(NewCborCodec), nil)
+}
+
+func NewMsgpackCodec(path string, input io.Reader) Codec {
+	reader := bufio.NewReader(input)
+	return &BinaryStreamCodec{
+		input:   reader,
+		decoder: &msgpackDecoder{input: reader},
+		key:     "msgpack",
+		path:    path,
+	}
+}
+
+func NewCborCodec(path string, input io.Reader) Codec {
+	reader := bufio.NewReader(input)
+	return &BinaryStreamCodec{
+		input:   reader,
+		decoder: &cborDecoder{input: reader},
+		key:     "cbor",
+		path:    path,
+	}
+}
+
+// BinaryStreamCodec reads concatenated MessagePack or CBOR documents the way
+// JsonStreamCodec reads JSON, sending one event per top-level map.
+type BinaryStreamCodec struct {
+	input      *bufio.Reader
+	decoder    binaryDecoder
+	key        string
+	value      common.MapStr
+	err        error
+	lineNumber int
+	path       string
+}
+
+func (codec *BinaryStreamCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	// the end of the file between documents is the end of the stream
+	if _, err := codec.input.Peek(1); err == io.EOF {
+		return false
+	}
+
+	value, err := codec.decoder.decode(0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		codec.err = fmt.Errorf("error decoding %s document %d: %v", codec.key, codec.lineNumber+1, err)
+		return false
+	}
+
+	fields, ok := value.(common.MapStr)
+	if !ok {
+		codec.err = fmt.Errorf("%s document %d is a %T, not a map", codec.key, codec.lineNumber+1, value)
+		return false
+	}
+
+	codec.lineNumber++
+	codec.value = common.MapStr{
+		codec.key: fields,
+		"file":    codec.path,
+		"line":    codec.lineNumber,
+	}
+
+	return true
+}
+
+// readBinaryString reads length bytes, checking the length first so corrupt
+// input doesn't cause huge allocations.
+func readBinaryString(input io.Reader, length uint64) ([]byte, error) {
+	if length > maxBinaryStreamLength {
+		return nil, fmt.Errorf("length %d is more than the maximum %d", length, maxBinaryStreamLength)
+	}
+
+	data := make([]byte, length)
+	_, err := io.ReadFull(input, data)
+	return data, err
+}
+
+func (codec *BinaryStreamCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *BinaryStreamCodec) Err() error {
+	return codec.err
+}
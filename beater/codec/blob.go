@@ -18,50 +18,27 @@ import (
 	"encoding/base64"
 
 	"io"
-	"io/ioutil"
 
 	"github.com/elastic/beats/libbeat/common"
 )
 
-func NewBlobCodec(path string, input io.Reader) Codec {
-
-	bytes, err := ioutil.ReadAll(input)
-	text := ""
-	if err == nil {
-		text = base64.StdEncoding.EncodeToString(bytes)
-	}
+func init() {
+	Register(BlobCodecId, NewBlobCodec, defaultContentConfig)
+}
 
-	event := common.MapStr{
-		"event": text,
-		"file":  path,
-		"line":  1,
+func NewBlobCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newContentConfig("blob", options)
+	if err != nil {
+		return nil, err
 	}
 
 	return &BlobCodec{
-		event:   event,
-		err:     err,
-		hasMore: err == nil,
-	}
+		contentCodec: newContentCodec(config, path, input, base64.StdEncoding.EncodeToString, false),
+	}, nil
 }
 
-// BufioCodec is a basic codec that reads a file line by line and reports the contents
-// line number and which file the line came from
+// BlobCodec sends the contents of a file encoded in Base64, in one event or
+// split into chunks.
 type BlobCodec struct {
-	event   common.MapStr
-	err     error
-	hasMore bool
-}
-
-func (codec *BlobCodec) Next() bool {
-	orig := codec.hasMore
-	codec.hasMore = false
-	return orig
-}
-
-func (codec *BlobCodec) Value() common.MapStr {
-	return codec.event
-}
-
-func (codec *BlobCodec) Err() error {
-	return codec.err
+	contentCodec
 }
@@ -45,7 +45,10 @@ func TestBlobCodecNextErr(t *testing.T) {
 
 	for tn, tc := range cases {
 		reader := strings.NewReader(tc.Data)
-		c := NewBlobCodec("testfile", reader)
+		c, err := NewBlobCodec(nil, "testfile", reader)
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
 
 		counter := 0
 		for c.Next() {
@@ -68,7 +71,10 @@ func TestBlobValFunctionality(t *testing.T) {
 	expected := "Zm9vCmJhciAKYmEgeno="
 
 	reader := strings.NewReader(data)
-	codec := NewBlobCodec("testfile", reader)
+	codec, err := NewBlobCodec(nil, "testfile", reader)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if !codec.Next() {
 		t.Error("Quit too early.")
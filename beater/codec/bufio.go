@@ -21,6 +21,10 @@ import (
 	"github.com/elastic/beats/libbeat/common"
 )
 
+func init() {
+	Register(TextCodecId, withoutOptions(NewBufioCodec), nil)
+}
+
 func NewBufioCodec(path string, input io.Reader) Codec {
 	return &BufioCodec{
 		scanner:    bufio.NewScanner(input),
@@ -0,0 +1,301 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborBytes    = 2
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+	cborTag      = 6
+	cborSimple   = 7
+
+	// cborIndefinite is the additional info for indefinite lengths and break
+	cborIndefinite = 31
+)
+
+// errCborBreak is returned when the break code ends an indefinite length item.
+var errCborBreak = errors.New("unexpected break")
+
+// cborDecoder decodes CBOR values https://tools.ietf.org/html/rfc7049
+// Byte strings become base64 strings, date tags become dates and bignums
+// become decimal strings. Other tags are dropped, leaving their value.
+type cborDecoder struct {
+	input *bufio.Reader
+}
+
+// readHead reads the major type and argument of the next item.
+func (d *cborDecoder) readHead() (major byte, info byte, arg uint64, err error) {
+	b, err := d.input.ReadByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	major, info = b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		var buf [8]byte
+		size := 1 << (info - 24)
+		if _, err := io.ReadFull(d.input, buf[8-size:]); err != nil {
+			return 0, 0, 0, err
+		}
+
+		return major, info, binary.BigEndian.Uint64(buf[:]), nil
+	case info == cborIndefinite:
+		return major, info, 0, nil
+	}
+
+	return 0, 0, 0, fmt.Errorf("invalid additional info %d", info)
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxBinaryStreamDepth {
+		return nil, fmt.Errorf("values nested more than %d deep", maxBinaryStreamDepth)
+	}
+
+	major, info, arg, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+
+	indefinite := info == cborIndefinite
+	if indefinite && (major == cborUnsigned || major == cborNegative || major == cborTag) {
+		return nil, fmt.Errorf("invalid indefinite length for major type %d", major)
+	}
+
+	switch major {
+	case cborUnsigned:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+
+		return int64(arg), nil
+
+	case cborNegative:
+		if arg > math.MaxInt64 {
+			n := new(big.Int).SetUint64(arg)
+			return n.Neg(n.Add(n, big.NewInt(1))).String(), nil
+		}
+
+		return -1 - int64(arg), nil
+
+	case cborBytes, cborText:
+		data, err := d.decodeString(major, indefinite, arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if major == cborBytes {
+			return base64.StdEncoding.EncodeToString(data), nil
+		}
+
+		return string(data), nil
+
+	case cborArray:
+		if arg > maxBinaryStreamLength {
+			return nil, fmt.Errorf("array length %d is more than the maximum %d", arg, maxBinaryStreamLength)
+		}
+
+		values := []interface{}{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			value, err := d.decode(depth + 1)
+			if indefinite && err == errCborBreak {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			values = append(values, value)
+		}
+
+		return values, nil
+
+	case cborMap:
+		if arg > maxBinaryStreamLength {
+			return nil, fmt.Errorf("map length %d is more than the maximum %d", arg, maxBinaryStreamLength)
+		}
+
+		fields := common.MapStr{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if indefinite && err == errCborBreak {
+				break
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			// keys can be any type but fields need strings
+			fields[fmt.Sprintf("%v", key)] = value
+		}
+
+		return fields, nil
+
+	case cborTag:
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return decodeCborTag(arg, value)
+	}
+
+	// major type 7, simple values and floats
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float64(cborHalfFloat(uint16(arg))), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	case cborIndefinite:
+		return nil, errCborBreak
+	}
+
+	return nil, fmt.Errorf("unsupported simple value %d", arg)
+}
+
+// decodeString reads a byte or text string, joining the chunks of indefinite
+// length strings.
+func (d *cborDecoder) decodeString(major byte, indefinite bool, length uint64) ([]byte, error) {
+	if !indefinite {
+		return readBinaryString(d.input, length)
+	}
+
+	var buf bytes.Buffer
+	for {
+		chunkMajor, info, arg, err := d.readHead()
+		if err != nil {
+			return nil, err
+		}
+
+		if chunkMajor == cborSimple && info == cborIndefinite {
+			return buf.Bytes(), nil
+		}
+
+		if chunkMajor != major || info == cborIndefinite {
+			return nil, errors.New("invalid chunk in indefinite length string")
+		}
+
+		if uint64(buf.Len())+arg > maxBinaryStreamLength {
+			return nil, fmt.Errorf("string is more than the maximum %d bytes", maxBinaryStreamLength)
+		}
+
+		chunk, err := readBinaryString(d.input, arg)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(chunk)
+	}
+}
+
+func decodeCborTag(tag uint64, value interface{}) (interface{}, error) {
+	switch tag {
+	case 0:
+		text, ok := value.(string)
+		if !ok {
+			return nil, errors.New("date/time tag on a non-string")
+		}
+
+		return time.Parse(time.RFC3339Nano, text)
+
+	case 1:
+		switch v := value.(type) {
+		case int64:
+			return time.Unix(v, 0).UTC(), nil
+		case float64:
+			seconds, fraction := math.Modf(v)
+			return time.Unix(int64(seconds), int64(fraction*1e9)).UTC(), nil
+		}
+
+		return nil, errors.New("epoch date/time tag on a non-number")
+
+	case 2, 3:
+		encoded, ok := value.(string)
+		if !ok {
+			return nil, errors.New("bignum tag on a non-byte string")
+		}
+
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		n := new(big.Int).SetBytes(data)
+		if tag == 3 {
+			n.Neg(n.Add(n, big.NewInt(1)))
+		}
+
+		return n.String(), nil
+	}
+
+	return value, nil
+}
+
+// cborHalfFloat converts an IEEE 754 half precision float.
+func cborHalfFloat(bits uint16) float32 {
+	sign := uint32(bits>>15) << 31
+	exponent := uint32(bits>>10) & 0x1f
+	mantissa := uint32(bits) & 0x3ff
+
+	switch exponent {
+	case 0:
+		// subnormal numbers
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			return -value
+		}
+
+		return value
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	}
+
+	return math.Float32frombits(sign | (exponent+112)<<23 | mantissa<<13)
+}
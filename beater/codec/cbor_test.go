@@ -0,0 +1,154 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestCborCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      []byte
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   nil,
+			Length: 0,
+		},
+		"concatenated": {
+			Data:   []byte{0xa1, 0x61, 'a', 0x01, 0xa0, 0xbf, 0x61, 'b', 0xf6, 0xff},
+			Length: 3,
+		},
+		"self described": {
+			Data:   []byte{0xd9, 0xd9, 0xf7, 0xa0},
+			Length: 1,
+		},
+		"second truncated": {
+			Data:      []byte{0xa0, 0xa1, 0x61, 'a'},
+			Length:    1,
+			ExpectErr: true,
+		},
+		"non map": {
+			Data:      []byte{0xa0, 0x82, 0x01, 0x02},
+			Length:    1,
+			ExpectErr: true,
+		},
+		"stray break": {
+			Data:      []byte{0xa1, 0x61, 'a', 0xff},
+			Length:    0,
+			ExpectErr: true,
+		},
+		"unterminated indefinite map": {
+			Data:      []byte{0xbf, 0x61, 'a', 0x01},
+			Length:    0,
+			ExpectErr: true,
+		},
+		"huge array": {
+			Data:      []byte{0xa1, 0x61, 'a', 0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewCborCodec("testfile", bytes.NewReader(tc.Data))
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d maps, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestCborCodecValue(t *testing.T) {
+	data := concat(
+		[]byte{0xab},
+		[]byte{0x63, 'u', 'n', 's'}, []byte{0x19, 0x01, 0x00},
+		[]byte{0x63, 'n', 'e', 'g'}, []byte{0x38, 0x63},
+		[]byte{0x63, 'f', '1', '6'}, []byte{0xf9, 0x3e, 0x00},
+		[]byte{0x63, 'f', '6', '4'}, []byte{0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+		[]byte{0x63, 's', 't', 'r'}, []byte{0x7f, 0x61, 'h', 0x61, 'i', 0xff},
+		[]byte{0x63, 'b', 'i', 'n'}, []byte{0x42, 'h', 'i'},
+		[]byte{0x63, 'a', 'r', 'r'}, []byte{0x9f, 0xf5, 0xf4, 0xf7, 0xff},
+		[]byte{0x63, 'm', 'a', 'p'}, []byte{0xa1, 0x01, 0xf6},
+		[]byte{0x62, 't', 's'}, []byte{0xc1, 0x1a, 0x59, 0x68, 0x2f, 0x00},
+		[]byte{0x63, 'r', 'f', 'c'}, concat([]byte{0xc0, 0x74}, []byte("2017-07-14T02:40:00Z")),
+		[]byte{0x63, 'b', 'i', 'g'}, []byte{0xc3, 0x49, 0x01, 0, 0, 0, 0, 0, 0, 0, 0},
+	)
+
+	expected := common.MapStr{
+		"cbor": common.MapStr{
+			"uns": int64(256),
+			"neg": int64(-100),
+			"f16": float64(1.5),
+			"f64": float64(1.5),
+			"str": "hi",
+			"bin": "aGk=",
+			"arr": []interface{}{true, false, nil},
+			"map": common.MapStr{"1": nil},
+			"ts":  time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC),
+			"rfc": time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC),
+			"big": "-18446744073709551617",
+		},
+		"file": "testfile",
+		"line": 1,
+	}
+
+	c := NewCborCodec("testfile", bytes.NewReader(data))
+	if !c.Next() {
+		t.Fatalf("Quit too early: %v", c.Err())
+	}
+
+	expectedS := fmt.Sprintf("%v", expected)
+	actualS := fmt.Sprintf("%v", c.Value())
+	if expectedS != actualS {
+		t.Errorf("Expected %v, got %v", expectedS, actualS)
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
+
+func TestCborHalfFloat(t *testing.T) {
+	cases := map[uint16]float32{
+		0x0000: 0,
+		0x3c00: 1,
+		0xc000: -2,
+		0x7bff: 65504,
+		0x0001: 5.960464477539063e-08,
+	}
+
+	for bits, expected := range cases {
+		if actual := cborHalfFloat(bits); actual != expected {
+			t.Errorf("0x%04x | Expected %v, got %v", bits, expected, actual)
+		}
+	}
+}
@@ -16,50 +16,31 @@ package codec
 
 import (
 	"io"
-	"io/ioutil"
 
 	"github.com/elastic/beats/libbeat/common"
 )
 
-func NewClobCodec(path string, input io.Reader) Codec {
-
-	bytes, err := ioutil.ReadAll(input)
-	text := ""
-	if err == nil {
-		text = string(bytes)
-	}
+func init() {
+	Register(ClobCodecId, NewClobCodec, defaultContentConfig)
+}
 
-	event := common.MapStr{
-		"event": text,
-		"file":  path,
-		"line":  1,
+func NewClobCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newContentConfig("clob", options)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ClobCodec{
-		event:   event,
-		err:     err,
-		hasMore: err == nil,
-	}
+		contentCodec: newContentCodec(config, path, input, clobText, true),
+	}, nil
 }
 
-// BufioCodec is a basic codec that reads a file line by line and reports the contents
-// line number and which file the line came from
+// ClobCodec sends the contents of a text file, in one event or split into
+// chunks that don't split UTF-8 characters.
 type ClobCodec struct {
-	event   common.MapStr
-	err     error
-	hasMore bool
-}
-
-func (codec *ClobCodec) Next() bool {
-	orig := codec.hasMore
-	codec.hasMore = false
-	return orig
-}
-
-func (codec *ClobCodec) Value() common.MapStr {
-	return codec.event
+	contentCodec
 }
 
-func (codec *ClobCodec) Err() error {
-	return codec.err
+func clobText(data []byte) string {
+	return string(data)
 }
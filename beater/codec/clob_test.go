@@ -45,7 +45,10 @@ func TestClobCodecNextErr(t *testing.T) {
 
 	for tn, tc := range cases {
 		reader := strings.NewReader(tc.Data)
-		c := NewClobCodec("testfile", reader)
+		c, err := NewClobCodec(nil, "testfile", reader)
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
 
 		counter := 0
 		for c.Next() {
@@ -67,7 +70,10 @@ func TestClobValFunctionality(t *testing.T) {
 	data := "\tfoo\nbar \nba zz"
 
 	reader := strings.NewReader(data)
-	codec := NewClobCodec("testfile", reader)
+	codec, err := NewClobCodec(nil, "testfile", reader)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if !codec.Next() {
 		t.Error("Quit too early.")
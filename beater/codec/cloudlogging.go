@@ -0,0 +1,307 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// cloudLoggingSinkName matches the objects written by Cloud Logging sinks
+// e.g. syslog/2018/05/01/12:00:00_12:59:59_S0.json
+var cloudLoggingSinkName = regexp.MustCompile(`^(.+)/(\d{4}/\d{2}/\d{2})/(\d{2}:\d{2}:\d{2})_(\d{2}:\d{2}:\d{2})_S(\d+)\.json$`)
+
+// cloudLoggingLevels maps LogSeverity names to log levels.
+var cloudLoggingLevels = map[string]string{
+	"DEFAULT":   "default",
+	"DEBUG":     "debug",
+	"INFO":      "info",
+	"NOTICE":    "notice",
+	"WARNING":   "warning",
+	"ERROR":     "error",
+	"CRITICAL":  "critical",
+	"ALERT":     "alert",
+	"EMERGENCY": "emergency",
+}
+
+func init() {
+	Register(CloudLoggingCodecId, withoutOptions(NewCloudLoggingCodec), nil)
+}
+
+func NewCloudLoggingCodec(path string, input io.Reader) Codec {
+	// the defaults can't fail
+	stream, _ := NewJsonStreamCodec(nil, path, input)
+
+	return &CloudLoggingCodec{
+		stream: stream.(*JsonStreamCodec),
+		sink:   parseCloudLoggingSinkName(path),
+		path:   path,
+	}
+}
+
+// CloudLoggingCodec reads the LogEntry documents exported by Cloud Logging
+// (Stackdriver) sinks and maps them to the fields under cloud_logging, with
+// the entry's timestamp as the event's.
+type CloudLoggingCodec struct {
+	stream *JsonStreamCodec
+	sink   common.MapStr
+	value  common.MapStr
+	path   string
+}
+
+func (codec *CloudLoggingCodec) Next() bool {
+	if !codec.stream.Next() {
+		return false
+	}
+
+	document := codec.stream.Value()
+	entry, _ := document["json"].(map[string]interface{})
+
+	codec.value = normalizeLogEntry(entry)
+	codec.value["file"] = codec.path
+	codec.value["line"] = document["line"]
+
+	if codec.sink != nil {
+		codec.value.Put("cloud_logging.sink", codec.sink.Clone())
+	}
+
+	return true
+}
+
+// normalizeLogEntry maps the fields of a LogEntry.
+func normalizeLogEntry(entry map[string]interface{}) common.MapStr {
+	fields := common.MapStr{}
+	event := common.MapStr{"cloud_logging": fields}
+
+	if receiveTimestamp, ok := parseLogEntryTime(entry["receiveTimestamp"]); ok {
+		fields["receive_timestamp"] = receiveTimestamp
+		event["@timestamp"] = receiveTimestamp
+	}
+
+	if timestamp, ok := parseLogEntryTime(entry["timestamp"]); ok {
+		event["@timestamp"] = timestamp
+	}
+
+	if severity, ok := entry["severity"].(string); ok {
+		fields["severity"] = severity
+
+		level, ok := cloudLoggingLevels[severity]
+		if !ok {
+			level = strings.ToLower(severity)
+		}
+
+		fields["level"] = level
+	}
+
+	if logName, ok := entry["logName"].(string); ok {
+		fields["log_name"] = logName
+
+		// log IDs are URL encoded e.g. cloudaudit.googleapis.com%2Factivity
+		if i := strings.Index(logName, "/logs/"); i >= 0 {
+			logId := logName[i+len("/logs/"):]
+			if unescaped, err := url.PathUnescape(logId); err == nil {
+				logId = unescaped
+			}
+
+			fields["log_id"] = logId
+		}
+	}
+
+	if resource, ok := entry["resource"].(map[string]interface{}); ok {
+		fields["resource"] = common.MapStr{
+			"type":   resource["type"],
+			"labels": resource["labels"],
+		}
+	}
+
+	if trace, ok := entry["trace"].(string); ok {
+		// projects/[PROJECT_ID]/traces/[TRACE_ID]
+		fields["trace"] = trace
+		fields["trace_id"] = trace[strings.LastIndex(trace, "/")+1:]
+	}
+
+	putLogEntryField(fields, "insert_id", entry["insertId"])
+	putLogEntryField(fields, "labels", entry["labels"])
+	putLogEntryField(fields, "span_id", entry["spanId"])
+	putLogEntryField(fields, "trace_sampled", entry["traceSampled"])
+
+	if operation, ok := entry["operation"].(map[string]interface{}); ok {
+		fields["operation"] = renameLogEntryFields(operation, map[string]string{
+			"id": "id", "producer": "producer", "first": "first", "last": "last",
+		})
+	}
+
+	if location, ok := entry["sourceLocation"].(map[string]interface{}); ok {
+		sourceLocation := renameLogEntryFields(location, map[string]string{
+			"file": "file", "function": "function",
+		})
+		putLogEntryField(sourceLocation, "line", parseLogEntryInt(location["line"]))
+		fields["source_location"] = sourceLocation
+	}
+
+	if request, ok := entry["httpRequest"].(map[string]interface{}); ok {
+		fields["http_request"] = normalizeHttpRequest(request)
+	}
+
+	switch {
+	case entry["textPayload"] != nil:
+		fields["payload_type"] = "text"
+		fields["message"] = entry["textPayload"]
+
+	case entry["jsonPayload"] != nil:
+		fields["payload_type"] = "json"
+		fields["json_payload"] = entry["jsonPayload"]
+
+		if payload, ok := entry["jsonPayload"].(map[string]interface{}); ok {
+			putLogEntryField(fields, "message", payload["message"])
+		}
+
+	case entry["protoPayload"] != nil:
+		fields["payload_type"] = "proto"
+		fields["proto_payload"] = entry["protoPayload"]
+	}
+
+	return event
+}
+
+// normalizeHttpRequest maps an HttpRequest, converting the sizes which are
+// strings in JSON and the latency which is a duration e.g. "0.25s".
+func normalizeHttpRequest(request map[string]interface{}) common.MapStr {
+	fields := renameLogEntryFields(request, map[string]string{
+		"requestMethod":                  "method",
+		"requestUrl":                     "url",
+		"status":                         "status",
+		"userAgent":                      "user_agent",
+		"remoteIp":                       "remote_ip",
+		"serverIp":                       "server_ip",
+		"referer":                        "referer",
+		"protocol":                       "protocol",
+		"cacheLookup":                    "cache_lookup",
+		"cacheHit":                       "cache_hit",
+		"cacheValidatedWithOriginServer": "cache_validated_with_origin_server",
+	})
+
+	if status, ok := fields["status"].(float64); ok {
+		fields["status"] = int64(status)
+	}
+
+	putLogEntryField(fields, "request_size", parseLogEntryInt(request["requestSize"]))
+	putLogEntryField(fields, "response_size", parseLogEntryInt(request["responseSize"]))
+	putLogEntryField(fields, "cache_fill_bytes", parseLogEntryInt(request["cacheFillBytes"]))
+
+	if latency, ok := request["latency"].(string); ok {
+		if duration, err := time.ParseDuration(latency); err == nil {
+			fields["latency"] = duration.Seconds()
+		}
+	}
+
+	return fields
+}
+
+// renameLogEntryFields copies the fields in names to their new names.
+func renameLogEntryFields(fields map[string]interface{}, names map[string]string) common.MapStr {
+	out := common.MapStr{}
+	for from, to := range names {
+		putLogEntryField(out, to, fields[from])
+	}
+
+	return out
+}
+
+func putLogEntryField(fields common.MapStr, key string, value interface{}) {
+	if value != nil {
+		fields[key] = value
+	}
+}
+
+func parseLogEntryTime(value interface{}) (time.Time, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// parseLogEntryInt reads an int64 which the JSON mapping writes as a string.
+// It returns nil if the value isn't an integer.
+func parseLogEntryInt(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case float64:
+		return int64(v)
+	}
+
+	return nil
+}
+
+// parseCloudLoggingSinkName reads the log name, the hour the entries are
+// from and the shard from the name of an object written by a sink. It
+// returns nil for other names.
+func parseCloudLoggingSinkName(path string) common.MapStr {
+	match := cloudLoggingSinkName.FindStringSubmatch(path)
+	if match == nil {
+		return nil
+	}
+
+	start, err := time.Parse("2006/01/02 15:04:05", match[2]+" "+match[3])
+	if err != nil {
+		return nil
+	}
+
+	end, err := time.Parse("2006/01/02 15:04:05", match[2]+" "+match[4])
+	if err != nil {
+		return nil
+	}
+
+	if end.Before(start) {
+		end = end.AddDate(0, 0, 1)
+	}
+
+	shard, err := strconv.Atoi(match[5])
+	if err != nil {
+		return nil
+	}
+
+	return common.MapStr{
+		"log":   match[1],
+		"start": start,
+		"end":   end,
+		"shard": shard,
+	}
+}
+
+func (codec *CloudLoggingCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *CloudLoggingCodec) Err() error {
+	return codec.stream.Err()
+}
+
+// Recover skips a malformed entry like json-stream.
+func (codec *CloudLoggingCodec) Recover() (common.MapStr, error) {
+	return codec.stream.Recover()
+}
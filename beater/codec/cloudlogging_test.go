@@ -0,0 +1,189 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestCloudLoggingCodecValue(t *testing.T) {
+	timestamp := time.Date(2018, 5, 1, 12, 3, 4, 123456789, time.UTC)
+	receiveTimestamp := time.Date(2018, 5, 1, 12, 3, 5, 0, time.UTC)
+
+	cases := map[string]struct {
+		Entry    string
+		Expected common.MapStr
+	}{
+		"text payload": {
+			Entry: `{"insertId":"abc","logName":"projects/p/logs/syslog","severity":"WARNING",
+				"timestamp":"2018-05-01T12:03:04.123456789Z","receiveTimestamp":"2018-05-01T12:03:05Z",
+				"resource":{"type":"gce_instance","labels":{"zone":"us-east1-b"}},
+				"labels":{"env":"prod"},"textPayload":"disk full",
+				"trace":"projects/p/traces/0af7651916cd43dd","spanId":"b7ad6b71","traceSampled":true}`,
+			Expected: common.MapStr{
+				"@timestamp": timestamp,
+				"cloud_logging": common.MapStr{
+					"insert_id":         "abc",
+					"log_name":          "projects/p/logs/syslog",
+					"log_id":            "syslog",
+					"severity":          "WARNING",
+					"level":             "warning",
+					"receive_timestamp": receiveTimestamp,
+					"resource":          common.MapStr{"type": "gce_instance", "labels": map[string]interface{}{"zone": "us-east1-b"}},
+					"labels":            map[string]interface{}{"env": "prod"},
+					"payload_type":      "text",
+					"message":           "disk full",
+					"trace":             "projects/p/traces/0af7651916cd43dd",
+					"trace_id":          "0af7651916cd43dd",
+					"span_id":           "b7ad6b71",
+					"trace_sampled":     true,
+				},
+			},
+		},
+		"json payload": {
+			Entry: `{"logName":"projects/p/logs/cloudaudit.googleapis.com%2Factivity","receiveTimestamp":"2018-05-01T12:03:05Z",
+				"jsonPayload":{"message":"hi","n":1},"sourceLocation":{"file":"main.go","line":"42","function":"main"},
+				"operation":{"id":"op","producer":"p","first":true}}`,
+			Expected: common.MapStr{
+				"@timestamp": receiveTimestamp,
+				"cloud_logging": common.MapStr{
+					"log_name":          "projects/p/logs/cloudaudit.googleapis.com%2Factivity",
+					"log_id":            "cloudaudit.googleapis.com/activity",
+					"receive_timestamp": receiveTimestamp,
+					"payload_type":      "json",
+					"json_payload":      map[string]interface{}{"message": "hi", "n": 1.0},
+					"message":           "hi",
+					"source_location":   common.MapStr{"file": "main.go", "line": int64(42), "function": "main"},
+					"operation":         common.MapStr{"id": "op", "producer": "p", "first": true},
+				},
+			},
+		},
+		"proto payload": {
+			Entry: `{"severity":"NOTICE","protoPayload":{"@type":"type.googleapis.com/google.cloud.audit.AuditLog","methodName":"m"}}`,
+			Expected: common.MapStr{
+				"cloud_logging": common.MapStr{
+					"severity":      "NOTICE",
+					"level":         "notice",
+					"payload_type":  "proto",
+					"proto_payload": map[string]interface{}{"@type": "type.googleapis.com/google.cloud.audit.AuditLog", "methodName": "m"},
+				},
+			},
+		},
+		"http request": {
+			Entry: `{"httpRequest":{"requestMethod":"GET","requestUrl":"https://example.com/","status":200,
+				"requestSize":"120","responseSize":"3456","userAgent":"curl","remoteIp":"10.0.0.1",
+				"latency":"0.250s","protocol":"HTTP/1.1","cacheHit":false}}`,
+			Expected: common.MapStr{
+				"cloud_logging": common.MapStr{
+					"http_request": common.MapStr{
+						"method":        "GET",
+						"url":           "https://example.com/",
+						"status":        int64(200),
+						"request_size":  int64(120),
+						"response_size": int64(3456),
+						"user_agent":    "curl",
+						"remote_ip":     "10.0.0.1",
+						"latency":       0.25,
+						"protocol":      "HTTP/1.1",
+						"cache_hit":     false,
+					},
+				},
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewCloudLoggingCodec("export.json", strings.NewReader(tc.Entry))
+		if !c.Next() {
+			t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+		}
+
+		tc.Expected["file"] = "export.json"
+		tc.Expected["line"] = 1
+
+		expected := fmt.Sprintf("%v", tc.Expected)
+		actual := fmt.Sprintf("%v", c.Value())
+		if expected != actual {
+			t.Errorf("%q | Expected %v, got %v", tn, expected, actual)
+		}
+	}
+}
+
+func TestCloudLoggingSinkName(t *testing.T) {
+	cases := map[string]common.MapStr{
+		"syslog/2018/05/01/12:00:00_12:59:59_S0.json": {
+			"log":   "syslog",
+			"start": time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC),
+			"end":   time.Date(2018, 5, 1, 12, 59, 59, 0, time.UTC),
+			"shard": 0,
+		},
+		"cloudaudit.googleapis.com/activity/2018/12/31/23:00:00_00:00:00_S12.json": {
+			"log":   "cloudaudit.googleapis.com/activity",
+			"start": time.Date(2018, 12, 31, 23, 0, 0, 0, time.UTC),
+			"end":   time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+			"shard": 12,
+		},
+		"syslog/2018/05/01/12:00:00_12:59:59.json":    nil,
+		"syslog/2018/13/01/12:00:00_12:59:59_S0.json": nil,
+		"export.json":                                  nil,
+		"/2018/05/01/12:00:00_12:59:59_S0.json":        nil,
+		"syslog/2018/05/01/12:00:00_12:59:59_S0.jsonl": nil,
+	}
+
+	for path, expected := range cases {
+		actual := parseCloudLoggingSinkName(path)
+		if fmt.Sprintf("%v", actual) != fmt.Sprintf("%v", expected) {
+			t.Errorf("%q | Expected %v, got %v", path, expected, actual)
+		}
+	}
+
+	c := NewCloudLoggingCodec("syslog/2018/05/01/12:00:00_12:59:59_S0.json", strings.NewReader(`{"textPayload":"a"}`))
+	if !c.Next() {
+		t.Fatalf("Quit too early: %v", c.Err())
+	}
+
+	if log, _ := c.Value().GetValue("cloud_logging.sink.log"); log != "syslog" {
+		t.Errorf("Expected the sink fields, got %v", c.Value())
+	}
+}
+
+func TestCloudLoggingCodecRecover(t *testing.T) {
+	c := NewCloudLoggingCodec("export.json", strings.NewReader("{\"textPayload\":\"a\"}\nnope\n{\"textPayload\":\"b\"}\n"))
+
+	var messages []interface{}
+	for {
+		for c.Next() {
+			message, _ := c.Value().GetValue("cloud_logging.message")
+			messages = append(messages, message)
+		}
+
+		if c.Err() == nil {
+			break
+		}
+
+		if _, err := c.(RecoverableCodec).Recover(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fmt.Sprintf("%v", messages) != "[a b]" {
+		t.Errorf("Expected both good entries, got %v", messages)
+	}
+}
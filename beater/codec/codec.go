@@ -18,16 +18,37 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"sort"
 
 	"github.com/elastic/beats/libbeat/common"
 )
 
 const (
-	JsonArrayCodecId  = "json-array"
-	JsonStreamcodecId = "json-stream"
-	TextCodecId       = "text"
-	ClobCodecId       = "clob"
-	BlobCodecId       = "blob"
+	JsonArrayCodecId         = "json-array"
+	JsonStreamcodecId        = "json-stream"
+	TextCodecId              = "text"
+	ClobCodecId              = "clob"
+	BlobCodecId              = "blob"
+	CsvCodecId               = "csv"
+	GrokCodecId              = "grok"
+	SyslogCodecId            = "syslog"
+	AccessLogCodecId         = "access-log"
+	LogfmtCodecId            = "logfmt"
+	XmlCodecId               = "xml"
+	AvroCodecId              = "avro"
+	ParquetCodecId           = "parquet"
+	ProtobufCodecId          = "protobuf"
+	MsgpackCodecId           = "msgpack"
+	CborCodecId              = "cbor"
+	AutoCodecId              = "auto"
+	ElasticdumpCodecId       = "elasticdump"
+	ElasticsearchBulkCodecId = "elasticsearch-bulk"
+	CloudLoggingCodecId      = "cloud-logging"
+	GcsAccessLogCodecId      = "gcs-access-log"
+	ContainerCodecId         = "container"
+	ZeekCodecId              = "zeek"
+	OtlpJsonCodecId          = "otlp-json"
 )
 
 type Codec interface {
@@ -44,46 +65,137 @@ type Codec interface {
 	Err() error
 }
 
-func NewCodec(codec, filename string, reader io.Reader) (Codec, error) {
-	switch {
-	case codec == JsonArrayCodecId:
-		return NewJsonArrayCodec(filename, reader), nil
+// RecoverableCodec is implemented by codecs that can skip a malformed record
+// after Next stops with an error so the rest of the file can still be read.
+type RecoverableCodec interface {
+	Codec
 
-	case codec == JsonStreamcodecId:
-		return NewJsonStreamCodec(filename, reader), nil
+	// Recover skips the record that caused the error and clears it so Next
+	// can continue. It returns an event describing the skipped record, or an
+	// error if the codec can't continue e.g. after the input failed.
+	Recover() (common.MapStr, error)
+}
 
-	case codec == TextCodecId:
-		return NewBufioCodec(filename, reader), nil
+// errNotRecoverable is returned by Recover for errors a codec can't skip.
+var errNotRecoverable = errors.New("the codec can't continue after this error")
 
-	case codec == ClobCodecId:
-		return NewClobCodec(filename, reader), nil
+// maxErrorRawLength limits the raw data kept in error events.
+const maxErrorRawLength = 64 * 1024
 
-	case codec == BlobCodecId:
-		return NewBlobCodec(filename, reader), nil
+// newErrorEvent describes a record that was skipped because of err.
+func newErrorEvent(path string, line int, raw []byte, err error) common.MapStr {
+	if len(raw) > maxErrorRawLength {
+		raw = raw[:maxErrorRawLength]
+	}
 
-	default:
-		msg := fmt.Sprintf("No such codec: %q", codec)
-		return nil, errors.New(msg)
+	return common.MapStr{
+		"error": common.MapStr{
+			"message": err.Error(),
+			"raw":     string(raw),
+		},
+		"file": path,
+		"line": line,
 	}
 }
 
-func IsValidCodec(codec string) bool {
-	for _, k := range ValidCodecs() {
-		if k == codec {
-			return true
+// Factory creates a codec reading the object at path. Codecs without options
+// are given nil options.
+type Factory func(options *common.Config, path string, input io.Reader) (Codec, error)
+
+type registration struct {
+	factory Factory
+
+	// config is the codec's default config struct, nil if it has no options
+	config interface{}
+}
+
+var registry = make(map[string]registration)
+
+// Register makes a codec available under id. config is the struct holding
+// the codec's default options, the options are unpacked into a copy of it to
+// validate them. It's nil for codecs without options.
+func Register(id string, factory Factory, config interface{}) {
+	if _, exists := registry[id]; exists {
+		panic(fmt.Sprintf("codec %q is registered twice", id))
+	}
+
+	registry[id] = registration{factory: factory, config: config}
+}
+
+// withoutOptions adapts the constructor of a codec without options.
+func withoutOptions(constructor func(path string, input io.Reader) Codec) Factory {
+	return func(options *common.Config, path string, input io.Reader) (Codec, error) {
+		return constructor(path, input), nil
+	}
+}
+
+// NewCodec creates the codec with the given ID. The options are passed to codecs that
+// can be configured, a nil options will give the codec's defaults.
+func NewCodec(codec string, options *common.Config, filename string, reader io.Reader) (Codec, error) {
+	registered, ok := registry[codec]
+	if !ok {
+		return nil, fmt.Errorf("No such codec: %q. Use one of: %v", codec, ValidCodecs())
+	}
+
+	return registered.factory(options, filename, reader)
+}
+
+// ValidateCodecOptions checks the options for the given codec without opening a file
+// so misconfigurations can be reported at startup.
+func ValidateCodecOptions(codec string, options *common.Config) error {
+	registered, ok := registry[codec]
+	if !ok {
+		return fmt.Errorf("No such codec: %q. Use one of: %v", codec, ValidCodecs())
+	}
+
+	if registered.config == nil {
+		return nil
+	}
+
+	_, err := unpackCodecConfig(codec, registered.config, options)
+	return err
+}
+
+// validator is implemented by codec configs.
+type validator interface {
+	Validate() error
+}
+
+// unpackCodecConfig unpacks options into a copy of the defaults, returning a
+// pointer to the copy.
+func unpackCodecConfig(name string, defaults interface{}, options *common.Config) (interface{}, error) {
+	config := reflect.New(reflect.TypeOf(defaults))
+	config.Elem().Set(reflect.ValueOf(defaults))
+
+	if options != nil {
+		if err := options.Unpack(config.Interface()); err != nil {
+			return nil, fmt.Errorf("error in %s codec options: %v", name, err)
+		}
+	}
+
+	// Unpack only validates when options are present
+	if v, ok := config.Interface().(validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
 		}
 	}
 
-	return false
+	return config.Interface(), nil
 }
 
+func IsValidCodec(codec string) bool {
+	_, ok := registry[codec]
+	return ok
+}
+
+// ValidCodecs lists the registered codecs in alphabetical order.
 func ValidCodecs() []string {
 	// generate on the fly so caller can't destructively mutate
-	return []string{
-		JsonArrayCodecId,
-		JsonStreamcodecId,
-		TextCodecId,
-		ClobCodecId,
-		BlobCodecId,
+	var ids []string
+	for id := range registry {
+		ids = append(ids, id)
 	}
+
+	sort.Strings(ids)
+	return ids
 }
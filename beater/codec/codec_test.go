@@ -0,0 +1,217 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// testOptions converts a map into codec options, nil maps give nil options.
+func testOptions(t *testing.T, options map[string]interface{}) *common.Config {
+	if options == nil {
+		return nil
+	}
+
+	cfg, err := common.NewConfigFrom(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestNewCodecUnknown(t *testing.T) {
+	if _, err := NewCodec("foo", nil, "testfile", strings.NewReader("")); err == nil {
+		t.Error("Expected an error for an unknown codec")
+	}
+}
+
+func TestValidCodecs(t *testing.T) {
+	codecs := ValidCodecs()
+	if !sort.StringsAreSorted(codecs) {
+		t.Errorf("Expected sorted codecs, got %v", codecs)
+	}
+
+	for _, id := range codecs {
+		if !IsValidCodec(id) {
+			t.Errorf("Expected %q to be valid", id)
+		}
+	}
+
+	if IsValidCodec("foo") {
+		t.Error("Expected foo not to be a codec")
+	}
+
+	_, err := NewCodec("foo", nil, "testfile", strings.NewReader(""))
+	if err == nil || !strings.Contains(err.Error(), CsvCodecId) {
+		t.Errorf("Expected the error to list the codecs, got %v", err)
+	}
+}
+
+func TestValidateCodecOptions(t *testing.T) {
+	cases := map[string]struct {
+		Codec     string
+		Options   map[string]interface{}
+		ExpectErr bool
+	}{
+		"defaults":           {Codec: CsvCodecId},
+		"no options":         {Codec: TextCodecId},
+		"ignored options":    {Codec: TextCodecId, Options: map[string]interface{}{"separator": ";;"}},
+		"invalid options":    {Codec: CsvCodecId, Options: map[string]interface{}{"separator": ";;"}, ExpectErr: true},
+		"missing required":   {Codec: GrokCodecId, ExpectErr: true},
+		"valid grok options": {Codec: GrokCodecId, Options: map[string]interface{}{"patterns": []string{"%{INT:n}"}}},
+		"json options":       {Codec: JsonStreamcodecId, Options: map[string]interface{}{"record_path": "items"}},
+		"invalid json":       {Codec: JsonArrayCodecId, Options: map[string]interface{}{"record_path": "a..b"}, ExpectErr: true},
+		"unknown codec":      {Codec: "foo", ExpectErr: true},
+	}
+
+	for tn, tc := range cases {
+		err := ValidateCodecOptions(tc.Codec, testOptions(t, tc.Options))
+
+		hasErr := err != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, err, tc.ExpectErr)
+		}
+	}
+}
+
+func TestRecoverableCodecs(t *testing.T) {
+	// a syslog frame one byte over the limit, spread over many lines
+	hugeFrameLines := (maxSyslogFrameLength - 21) / 2
+	hugeFrame := "<13>1 - host app - - " + strings.Repeat("x\n", hugeFrameLines) + "xx"
+
+	cases := map[string]struct {
+		Codec   string
+		Options map[string]interface{}
+		Data    string
+		// Expected holds the line of each event, negative for error events
+		Expected []int
+		// Raw holds the raw data of each error event
+		Raw []string
+	}{
+		"json-stream malformed": {
+			Codec:    JsonStreamcodecId,
+			Data:     "{\"a\":1}\n{\"a\":bad}\n{\"a\":3}\n",
+			Expected: []int{1, -2, 3},
+			Raw:      []string{`{"a":bad}`},
+		},
+		"json-stream mid line": {
+			Codec:    JsonStreamcodecId,
+			Data:     "{\"a\":1} nope {\"a\":2}\n{\"a\":3}",
+			Expected: []int{1, -2, 3},
+			Raw:      []string{`nope {"a":2}`},
+		},
+		"json-stream not an object": {
+			Codec:    JsonStreamcodecId,
+			Data:     `{"a":1} [1,2] {"a":3}`,
+			Expected: []int{1, -2, 3},
+			Raw:      []string{`[1,2]`},
+		},
+		"json-stream truncated": {
+			Codec:    JsonStreamcodecId,
+			Data:     "{\"a\":1}\n{\"a\":",
+			Expected: []int{1, -2},
+			Raw:      []string{`{"a":`},
+		},
+		"logfmt": {
+			Codec:    LogfmtCodecId,
+			Data:     "a=1\nb=\"open\nc=3\n",
+			Expected: []int{1, -2, 3},
+			Raw:      []string{`b="open`},
+		},
+		"access-log": {
+			Codec:    AccessLogCodecId,
+			Options:  map[string]interface{}{"format": "common"},
+			Data:     "1.2.3.4 - - [10/Oct/2000:13:55:36 -0700] \"GET / HTTP/1.0\" 200 2326\nnope\n",
+			Expected: []int{1, -2},
+			Raw:      []string{"nope"},
+		},
+		"syslog": {
+			Codec:    SyslogCodecId,
+			Data:     "<34>1 2003-10-11T22:14:15.003Z host app - ID47 - ok\n<999>bad\n<34>1 2003-10-11T22:14:15.003Z host app - ID47 - ok\n",
+			Expected: []int{1, -2, 3},
+			Raw:      []string{"<999>bad"},
+		},
+		"syslog frame too large": {
+			Codec:    SyslogCodecId,
+			Data:     fmt.Sprintf("%d %s\n", len(hugeFrame), hugeFrame) + "<34>1 2003-10-11T22:14:15.003Z host app - ID47 - ok\n",
+			Expected: []int{-1, hugeFrameLines + 2},
+			Raw:      []string{hugeFrame[:maxErrorRawLength]},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewCodec(tc.Codec, testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		var lines []int
+		var raws []string
+		for {
+			for c.Next() {
+				lines = append(lines, c.Value()["line"].(int))
+			}
+
+			if c.Err() == nil {
+				break
+			}
+
+			event, err := c.(RecoverableCodec).Recover()
+			if err != nil {
+				t.Fatalf("%q | Unexpected error recovering from %v: %v", tn, c.Err(), err)
+			}
+
+			lines = append(lines, -event["line"].(int))
+			raws = append(raws, event["error"].(common.MapStr)["raw"].(string))
+
+			if message := event["error"].(common.MapStr)["message"]; message == "" {
+				t.Errorf("%q | Expected an error message in %v", tn, event)
+			}
+		}
+
+		if fmt.Sprintf("%v", lines) != fmt.Sprintf("%v", tc.Expected) {
+			t.Errorf("%q | Expected lines %v, got %v", tn, tc.Expected, lines)
+		}
+
+		if fmt.Sprintf("%q", raws) != fmt.Sprintf("%q", tc.Raw) {
+			t.Errorf("%q | Expected raw data %q, got %q", tn, tc.Raw, raws)
+		}
+	}
+}
+
+func TestRecoverUnrecoverable(t *testing.T) {
+	options := testOptions(t, map[string]interface{}{"record_path": "items"})
+	c, err := NewJsonStreamCodec(options, "testfile", strings.NewReader(`{"items":[{"a":1},{"a":bad}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for c.Next() {
+	}
+
+	if c.Err() == nil {
+		t.Fatal("Expected an error")
+	}
+
+	if _, err := c.(RecoverableCodec).Recover(); err == nil {
+		t.Error("Expected malformed JSON inside records to be unrecoverable")
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	ContainerFormatAuto   = "auto"
+	ContainerFormatCri    = "cri"
+	ContainerFormatDocker = "docker"
+
+	ContainerStreamAll    = "all"
+	ContainerStreamStdout = "stdout"
+	ContainerStreamStderr = "stderr"
+)
+
+// maxContainerLineLength is the longest line the container codec reads, the
+// runtimes split messages into lines of 16KiB.
+const maxContainerLineLength = 1024 * 1024
+
+// maxContainerMessageLength limits how much of a message is joined from its
+// partial lines, longer messages are sent in pieces.
+const maxContainerMessageLength = 4 * 1024 * 1024
+
+var (
+	// e.g. var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart count>.log
+	containerPodLogPath = regexp.MustCompile(`(?:^|/)var/log/pods/([^/_]+)_([^/_]+)_([^/_]+)/([^/]+)/(\d+)\.log`)
+
+	// e.g. var/log/pods/<uid>/<container>_<restart count>.log before Kubernetes 1.14
+	containerLegacyPodLogPath = regexp.MustCompile(`(?:^|/)var/log/pods/([^/_]+)/([^/]+)_(\d+)\.log`)
+
+	// e.g. var/log/containers/<pod>_<namespace>_<container>-<container id>.log
+	containerLogPath = regexp.MustCompile(`(?:^|/)var/log/containers/([^/_]+)_([^/_]+)_([^/]+)-([0-9a-f]{64})\.log`)
+)
+
+// ContainerConfig holds the options for the container codec.
+type ContainerConfig struct {
+	// Format is the log format, auto detects it on each line.
+	Format string `config:"format"`
+
+	// Stream keeps only the lines written to stdout or stderr.
+	Stream string `config:"stream"`
+}
+
+var defaultContainerConfig = ContainerConfig{
+	Format: ContainerFormatAuto,
+	Stream: ContainerStreamAll,
+}
+
+func (c *ContainerConfig) Validate() error {
+	switch c.Format {
+	case ContainerFormatAuto, ContainerFormatCri, ContainerFormatDocker:
+	default:
+		return fmt.Errorf("container format must be one of %q, %q or %q, got %q",
+			ContainerFormatAuto, ContainerFormatCri, ContainerFormatDocker, c.Format)
+	}
+
+	switch c.Stream {
+	case ContainerStreamAll, ContainerStreamStdout, ContainerStreamStderr:
+	default:
+		return fmt.Errorf("container stream must be one of %q, %q or %q, got %q",
+			ContainerStreamAll, ContainerStreamStdout, ContainerStreamStderr, c.Stream)
+	}
+
+	return nil
+}
+
+func newContainerConfig(options *common.Config) (*ContainerConfig, error) {
+	config, err := unpackCodecConfig("container", defaultContainerConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*ContainerConfig), nil
+}
+
+// containerLine is a line of a container log, or a whole message once the
+// partial lines are joined.
+type containerLine struct {
+	timestamp time.Time
+	stream    string
+	format    string
+	partial   bool
+	message   string
+	line      int
+
+	// joined is the message with the partial lines after it
+	joined strings.Builder
+}
+
+func init() {
+	Register(ContainerCodecId, NewContainerCodec, defaultContainerConfig)
+}
+
+func NewContainerCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newContainerConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxContainerLineLength)
+
+	return &ContainerCodec{
+		scanner: scanner,
+		config:  config,
+		pending: make(map[string]*containerLine),
+		meta:    parseContainerLogPath(path),
+		path:    path,
+	}, nil
+}
+
+// ContainerCodec reads the logs container runtimes write on Kubernetes
+// nodes, in the CRI format e.g. `2018-05-01T12:03:04.123Z stdout F hello` or
+// Docker's json-file format. Messages split over partial lines are joined
+// and sent in event with the stream and timestamp. The pod, namespace and
+// container are read from the standard paths of the logs on the node.
+type ContainerCodec struct {
+	scanner    *bufio.Scanner
+	config     *ContainerConfig
+	pending    map[string]*containerLine
+	meta       common.MapStr
+	value      common.MapStr
+	err        error
+	bad        []byte
+	lineNumber int
+	path       string
+}
+
+func (codec *ContainerCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for codec.scanner.Scan() {
+		codec.lineNumber++
+		text := codec.scanner.Text()
+
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		line, err := codec.parse(text)
+		if err != nil {
+			codec.err = fmt.Errorf("line %d: %v", codec.lineNumber, err)
+			codec.bad = []byte(text)
+			return false
+		}
+
+		if codec.config.Stream != ContainerStreamAll && line.stream != codec.config.Stream {
+			continue
+		}
+
+		// partial lines are joined per stream, the streams can be interleaved
+		message, ok := codec.pending[line.stream]
+		if !ok {
+			message = line
+		}
+
+		message.joined.WriteString(line.message)
+		message.partial = line.partial
+
+		// messages that get too long are sent so far, still marked partial
+		if message.partial && message.joined.Len() < maxContainerMessageLength {
+			codec.pending[line.stream] = message
+			continue
+		}
+
+		delete(codec.pending, line.stream)
+		codec.setValue(message)
+		return true
+	}
+
+	if codec.scanner.Err() != nil {
+		return false
+	}
+
+	// messages whose last part is missing are sent at the end of the file
+	var first *containerLine
+	for _, message := range codec.pending {
+		if first == nil || message.line < first.line {
+			first = message
+		}
+	}
+
+	if first == nil {
+		return false
+	}
+
+	delete(codec.pending, first.stream)
+	codec.setValue(first)
+	return true
+}
+
+func (codec *ContainerCodec) parse(text string) (*containerLine, error) {
+	format := codec.config.Format
+	if format == ContainerFormatAuto {
+		format = ContainerFormatCri
+		if strings.HasPrefix(text, "{") {
+			format = ContainerFormatDocker
+		}
+	}
+
+	var line *containerLine
+	var err error
+	if format == ContainerFormatDocker {
+		line, err = parseDockerLine(text)
+	} else {
+		line, err = parseCriLine(text)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	line.format = format
+	line.line = codec.lineNumber
+	return line, nil
+}
+
+// parseCriLine reads a line of the CRI format: the timestamp, stream, tags
+// and message separated by spaces. The F tag marks the last line of a message
+// and P the others, logs from before the tags were added have none.
+func parseCriLine(text string) (*containerLine, error) {
+	parts := strings.SplitN(text, " ", 4)
+	if len(parts) < 3 {
+		return nil, errors.New("not a CRI log line")
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%q isn't a RFC3339 timestamp", parts[0])
+	}
+
+	if parts[1] != ContainerStreamStdout && parts[1] != ContainerStreamStderr {
+		return nil, fmt.Errorf("%q isn't stdout or stderr", parts[1])
+	}
+
+	line := &containerLine{timestamp: timestamp, stream: parts[1]}
+
+	// the tags are separated by colons, the first one is P or F
+	switch strings.SplitN(parts[2], ":", 2)[0] {
+	case "P":
+		line.partial = true
+		fallthrough
+	case "F":
+		if len(parts) == 4 {
+			line.message = parts[3]
+		}
+	default:
+		line.message = strings.Join(parts[2:], " ")
+	}
+
+	return line, nil
+}
+
+// parseDockerLine reads a line of Docker's json-file format. Messages end
+// with a newline, lines without one are parts of a longer message.
+func parseDockerLine(text string) (*containerLine, error) {
+	var entry struct {
+		Log    *string `json:"log"`
+		Stream string  `json:"stream"`
+		Time   string  `json:"time"`
+	}
+
+	if err := json.Unmarshal([]byte(text), &entry); err != nil {
+		return nil, err
+	}
+
+	if entry.Log == nil {
+		return nil, errors.New("not a Docker json-file log line")
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, entry.Time)
+	if err != nil {
+		return nil, fmt.Errorf("%q isn't a RFC3339 timestamp", entry.Time)
+	}
+
+	return &containerLine{
+		timestamp: timestamp,
+		stream:    entry.Stream,
+		partial:   !strings.HasSuffix(*entry.Log, "\n"),
+		message:   strings.TrimSuffix(*entry.Log, "\n"),
+	}, nil
+}
+
+// parseContainerLogPath reads the pod, namespace and container from the path
+// of a log on a Kubernetes node. The fields are returned by their dotted
+// names, it returns nil for other paths.
+func parseContainerLogPath(path string) common.MapStr {
+	if match := containerPodLogPath.FindStringSubmatch(path); match != nil {
+		restartCount, _ := strconv.Atoi(match[5])
+
+		return common.MapStr{
+			"kubernetes.namespace":        match[1],
+			"kubernetes.pod.name":         match[2],
+			"kubernetes.pod.uid":          match[3],
+			"kubernetes.container.name":   match[4],
+			"container_log.restart_count": restartCount,
+		}
+	}
+
+	if match := containerLegacyPodLogPath.FindStringSubmatch(path); match != nil {
+		restartCount, _ := strconv.Atoi(match[3])
+
+		return common.MapStr{
+			"kubernetes.pod.uid":          match[1],
+			"kubernetes.container.name":   match[2],
+			"container_log.restart_count": restartCount,
+		}
+	}
+
+	if match := containerLogPath.FindStringSubmatch(path); match != nil {
+		return common.MapStr{
+			"kubernetes.pod.name":       match[1],
+			"kubernetes.namespace":      match[2],
+			"kubernetes.container.name": match[3],
+			"docker.container.id":       match[4],
+		}
+	}
+
+	return nil
+}
+
+func (codec *ContainerCodec) setValue(message *containerLine) {
+	fields := common.MapStr{
+		"stream": message.stream,
+		"format": message.format,
+	}
+
+	if message.partial {
+		fields["partial"] = true
+	}
+
+	codec.value = common.MapStr{
+		"@timestamp":    message.timestamp,
+		"event":         message.joined.String(),
+		"container_log": fields,
+		"file":          codec.path,
+		"line":          message.line,
+	}
+
+	for key, value := range codec.meta {
+		codec.value.Put(key, value)
+	}
+}
+
+func (codec *ContainerCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *ContainerCodec) Err() error {
+	if codec.err != nil {
+		return codec.err
+	}
+
+	return codec.scanner.Err()
+}
+
+// Recover skips the line that couldn't be parsed, parts of messages read
+// before it are kept.
+func (codec *ContainerCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.lineNumber, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
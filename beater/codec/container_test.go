@@ -0,0 +1,298 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestContainerCodecOptions(t *testing.T) {
+	cases := map[string]struct {
+		Options   map[string]interface{}
+		ExpectErr bool
+	}{
+		"defaults":       {Options: nil},
+		"cri":            {Options: map[string]interface{}{"format": "cri"}},
+		"docker stderr":  {Options: map[string]interface{}{"format": "docker", "stream": "stderr"}},
+		"unknown format": {Options: map[string]interface{}{"format": "journald"}, ExpectErr: true},
+		"unknown stream": {Options: map[string]interface{}{"stream": "stdin"}, ExpectErr: true},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewContainerCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(""))
+
+		if (err != nil) != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, err, tc.ExpectErr)
+		}
+	}
+}
+
+func TestContainerCodecMessages(t *testing.T) {
+	cases := map[string]struct {
+		Options map[string]interface{}
+		Data    string
+		// Expected holds the stream, line and message of each event
+		Expected []string
+	}{
+		"cri": {
+			Data: "2018-05-01T12:03:04.000000001Z stdout F hello world\n" +
+				"2018-05-01T12:03:05Z stderr F oops\n",
+			Expected: []string{"stdout 1 hello world", "stderr 2 oops"},
+		},
+		"cri partial": {
+			Data: "2018-05-01T12:03:04Z stdout P hel\n" +
+				"2018-05-01T12:03:04Z stderr F oops\n" +
+				"2018-05-01T12:03:04Z stdout P lo \n" +
+				"2018-05-01T12:03:04Z stdout F world\n",
+			Expected: []string{"stderr 2 oops", "stdout 1 hello world"},
+		},
+		"cri empty and untagged": {
+			Data: "2018-05-01T12:03:04Z stdout F\n" +
+				"2018-05-01T12:03:04Z stdout F \n" +
+				"2018-05-01T12:03:04Z stdout no tags here\n",
+			Expected: []string{"stdout 1 ", "stdout 2 ", "stdout 3 no tags here"},
+		},
+		"docker": {
+			Data: `{"log":"hello world\n","stream":"stdout","time":"2018-05-01T12:03:04.123Z"}
+{"log":"part one ","stream":"stderr","time":"2018-05-01T12:03:05Z"}
+{"log":"part two\n","stream":"stderr","time":"2018-05-01T12:03:06Z"}
+`,
+			Expected: []string{"stdout 1 hello world", "stderr 2 part one part two"},
+		},
+		"mixed formats": {
+			Data: `{"log":"from docker\n","stream":"stdout","time":"2018-05-01T12:03:04Z"}
+2018-05-01T12:03:04Z stdout F from cri
+`,
+			Expected: []string{"stdout 1 from docker", "stdout 2 from cri"},
+		},
+		"unfinished at the end": {
+			Data: "2018-05-01T12:03:04Z stderr P b\n" +
+				"2018-05-01T12:03:04Z stdout P a\n" +
+				"2018-05-01T12:03:04Z stdout F c\n" +
+				"2018-05-01T12:03:04Z stdout P d\n",
+			Expected: []string{"stdout 2 ac", "stderr 1 b", "stdout 4 d"},
+		},
+		"stream": {
+			Options: map[string]interface{}{"stream": "stderr"},
+			Data: "2018-05-01T12:03:04Z stdout F a\n" +
+				"2018-05-01T12:03:04Z stderr F b\n",
+			Expected: []string{"stderr 2 b"},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewContainerCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		var actual []string
+		for c.Next() {
+			value := c.Value()
+			stream, _ := value.GetValue("container_log.stream")
+			actual = append(actual, fmt.Sprintf("%v %v %v", stream, value["line"], value["event"]))
+		}
+
+		if c.Err() != nil {
+			t.Errorf("%q | Unexpected error %v", tn, c.Err())
+		}
+
+		if fmt.Sprintf("%q", actual) != fmt.Sprintf("%q", tc.Expected) {
+			t.Errorf("%q | Expected %q, got %q", tn, tc.Expected, actual)
+		}
+	}
+}
+
+func TestContainerCodecValue(t *testing.T) {
+	path := "node-1/var/log/pods/default_web-5d8f7_0a1b2c3d-1111-2222-3333-444455556666/nginx/2.log"
+	data := "2018-05-01T12:03:04.5Z stdout P a\n2018-05-01T12:03:05Z stdout F b\n2018-05-01T12:03:06Z stdout P c\n"
+
+	expected := []common.MapStr{
+		{
+			"@timestamp":    time.Date(2018, 5, 1, 12, 3, 4, 5e8, time.UTC),
+			"event":         "ab",
+			"container_log": common.MapStr{"stream": "stdout", "format": "cri", "restart_count": 2},
+			"kubernetes": common.MapStr{
+				"namespace": "default",
+				"pod":       common.MapStr{"name": "web-5d8f7", "uid": "0a1b2c3d-1111-2222-3333-444455556666"},
+				"container": common.MapStr{"name": "nginx"},
+			},
+			"file": path,
+			"line": 1,
+		},
+		{
+			"@timestamp":    time.Date(2018, 5, 1, 12, 3, 6, 0, time.UTC),
+			"event":         "c",
+			"container_log": common.MapStr{"stream": "stdout", "format": "cri", "partial": true, "restart_count": 2},
+			"kubernetes": common.MapStr{
+				"namespace": "default",
+				"pod":       common.MapStr{"name": "web-5d8f7", "uid": "0a1b2c3d-1111-2222-3333-444455556666"},
+				"container": common.MapStr{"name": "nginx"},
+			},
+			"file": path,
+			"line": 3,
+		},
+	}
+
+	c, _ := NewContainerCodec(nil, path, strings.NewReader(data))
+	for i, expectedFields := range expected {
+		if !c.Next() {
+			t.Fatalf("Quit too early: %v", c.Err())
+		}
+
+		expectedS := fmt.Sprintf("%v", expectedFields)
+		actualS := fmt.Sprintf("%v", c.Value())
+		if expectedS != actualS {
+			t.Errorf("%d | Expected %v, got %v", i, expectedS, actualS)
+		}
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
+
+func TestContainerCodecLongMessage(t *testing.T) {
+	part := strings.Repeat("x", 16*1024)
+	parts := maxContainerMessageLength / len(part)
+
+	var data bytes.Buffer
+	for i := 0; i < parts+2; i++ {
+		data.WriteString("2018-05-01T12:03:04Z stdout P " + part + "\n")
+	}
+
+	data.WriteString("2018-05-01T12:03:04Z stdout F end\n")
+
+	// the message is sent in pieces of at most the max length
+	expected := []struct {
+		Line    int
+		Length  int
+		Partial bool
+	}{
+		{Line: 1, Length: maxContainerMessageLength, Partial: true},
+		{Line: parts + 1, Length: 2*len(part) + 3},
+	}
+
+	c, _ := NewContainerCodec(nil, "testfile", &data)
+	for i, tc := range expected {
+		if !c.Next() {
+			t.Fatalf("%d | Quit too early: %v", i, c.Err())
+		}
+
+		partial, _ := c.Value().GetValue("container_log.partial")
+		if c.Value()["line"] != tc.Line || len(c.Value()["event"].(string)) != tc.Length || (partial == true) != tc.Partial {
+			t.Errorf("%d | Expected line %d with %d bytes, partial? %v, got line %v with %d bytes, partial? %v", i,
+				tc.Line, tc.Length, tc.Partial, c.Value()["line"], len(c.Value()["event"].(string)), partial)
+		}
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
+
+func TestContainerLogPath(t *testing.T) {
+	cases := map[string]common.MapStr{
+		"var/log/pods/kube-system_dns-abc12_0a1b-2c3d/coredns/0.log": {
+			"kubernetes.namespace":        "kube-system",
+			"kubernetes.pod.name":         "dns-abc12",
+			"kubernetes.pod.uid":          "0a1b-2c3d",
+			"kubernetes.container.name":   "coredns",
+			"container_log.restart_count": 0,
+		},
+		"archive/var/log/pods/0a1b-2c3d/coredns_3.log.gz": {
+			"kubernetes.pod.uid":          "0a1b-2c3d",
+			"kubernetes.container.name":   "coredns",
+			"container_log.restart_count": 3,
+		},
+		"var/log/containers/web-5d8f7_default_nginx-" + strings.Repeat("ab", 32) + ".log": {
+			"kubernetes.pod.name":       "web-5d8f7",
+			"kubernetes.namespace":      "default",
+			"kubernetes.container.name": "nginx",
+			"docker.container.id":       strings.Repeat("ab", 32),
+		},
+		"var/log/containers/web-5d8f7_default_nginx.log": nil,
+		"logs/var/log/syslog":                            nil,
+		"pods/default_web_uid/nginx/0.log":               nil,
+	}
+
+	for path, expected := range cases {
+		actual := parseContainerLogPath(path)
+		if fmt.Sprintf("%v", actual) != fmt.Sprintf("%v", expected) {
+			t.Errorf("%q | Expected %v, got %v", path, expected, actual)
+		}
+	}
+}
+
+func TestContainerCodecRecover(t *testing.T) {
+	cases := map[string]struct {
+		Data string
+		// Expected holds the message of each event, or the line of error events
+		Expected []interface{}
+	}{
+		"bad timestamp": {
+			Data:     "yesterday stdout F a\n2018-05-01T12:03:04Z stdout F b\n",
+			Expected: []interface{}{1, "b"},
+		},
+		"bad stream": {
+			Data:     "2018-05-01T12:03:04Z stdin F a\n2018-05-01T12:03:04Z stdout F b\n",
+			Expected: []interface{}{1, "b"},
+		},
+		"too short": {
+			Data:     "2018-05-01T12:03:04Z stdout\n2018-05-01T12:03:04Z stdout F b\n",
+			Expected: []interface{}{1, "b"},
+		},
+		"bad json between partial lines": {
+			Data:     "2018-05-01T12:03:04Z stdout P a\n{\"log\":\n2018-05-01T12:03:04Z stdout F b\n",
+			Expected: []interface{}{2, "ab"},
+		},
+		"json without log": {
+			Data:     "{\"stream\":\"stdout\",\"time\":\"2018-05-01T12:03:04Z\"}\n",
+			Expected: []interface{}{1},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, _ := NewContainerCodec(nil, "testfile", strings.NewReader(tc.Data))
+
+		var actual []interface{}
+		for {
+			for c.Next() {
+				actual = append(actual, c.Value()["event"])
+			}
+
+			if c.Err() == nil {
+				break
+			}
+
+			event, err := c.(RecoverableCodec).Recover()
+			if err != nil {
+				t.Fatalf("%q | Unexpected error recovering from %v: %v", tn, c.Err(), err)
+			}
+
+			actual = append(actual, event["line"])
+		}
+
+		if fmt.Sprintf("%v", actual) != fmt.Sprintf("%v", tc.Expected) {
+			t.Errorf("%q | Expected %v, got %v", tn, tc.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// mimeSniffLength is how much of an object is used to detect its MIME type,
+// DetectContentType doesn't look any further.
+const mimeSniffLength = 512
+
+// defaultContentMaxSize limits the objects read into memory when they aren't
+// split into chunks, as Base64 it's still under the 100MB Elasticsearch
+// accepts in a request by default.
+const defaultContentMaxSize = 64 * 1024 * 1024
+
+// ContentConfig holds the options for the blob and clob codecs.
+type ContentConfig struct {
+	// ChunkSize splits objects into events of at most this many bytes, the
+	// object is copied to a temporary file rather than read into memory. 0
+	// sends the whole object in one event.
+	ChunkSize int `config:"chunk_size"`
+
+	// MaxSize skips objects larger than this many bytes. 0 means there's no
+	// limit. If unset it's defaultContentMaxSize when objects aren't split
+	// into chunks, and unlimited when they are.
+	MaxSize *int64 `config:"max_size"`
+
+	// TempDir is where objects are copied while they're split into chunks.
+	// Defaults to the system temporary directory.
+	TempDir string `config:"temp_dir"`
+}
+
+var defaultContentConfig = ContentConfig{
+	ChunkSize: 0,
+	MaxSize:   nil,
+	TempDir:   "",
+}
+
+func (c *ContentConfig) Validate() error {
+	if c.ChunkSize < 0 {
+		return fmt.Errorf("blob and clob chunk_size must not be negative, got %d", c.ChunkSize)
+	}
+
+	if c.MaxSize != nil && *c.MaxSize < 0 {
+		return fmt.Errorf("blob and clob max_size must not be negative, got %d", *c.MaxSize)
+	}
+
+	return validateTempDir("blob and clob", c.TempDir)
+}
+
+func newContentConfig(name string, options *common.Config) (*ContentConfig, error) {
+	config, err := unpackCodecConfig(name, defaultContentConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	content := config.(*ContentConfig)
+	if content.MaxSize == nil {
+		var maxSize int64
+		if content.ChunkSize == 0 {
+			maxSize = defaultContentMaxSize
+		}
+
+		content.MaxSize = &maxSize
+	}
+
+	return content, nil
+}
+
+// contentCodec sends the contents of an object, whole or in chunks, with the
+// size, SHA-256 and MIME type of the whole object under content. It's shared
+// by the blob and clob codecs, which only differ in how the bytes are put in
+// the event.
+type contentCodec struct {
+	config *ContentConfig
+	input  io.Reader
+	path   string
+
+	// encode turns the bytes of a chunk into the event
+	encode func([]byte) string
+
+	// text chunks don't split UTF-8 characters
+	text bool
+
+	started  bool
+	object   common.MapStr
+	chunks   []int
+	file     *os.File
+	buffer   []byte
+	sequence int
+	offset   int64
+	value    common.MapStr
+	err      error
+}
+
+func newContentCodec(config *ContentConfig, path string, input io.Reader, encode func([]byte) string, text bool) contentCodec {
+	return contentCodec{
+		config: config,
+		input:  input,
+		path:   path,
+		encode: encode,
+		text:   text,
+	}
+}
+
+func (codec *contentCodec) Next() bool {
+	if !codec.started {
+		codec.started = true
+		return codec.start()
+	}
+
+	if codec.err != nil || codec.sequence >= len(codec.chunks) {
+		codec.close()
+		return false
+	}
+
+	return codec.readChunk()
+}
+
+// start reads the object, it's sent straight away unless it's split into
+// chunks.
+func (codec *contentCodec) start() bool {
+	input := codec.input
+	if maxSize := *codec.config.MaxSize; maxSize > 0 {
+		// one more byte than allowed tells if the object is too large
+		input = io.LimitReader(input, maxSize+1)
+	}
+
+	if codec.config.ChunkSize > 0 {
+		if err := codec.spool(input); err != nil {
+			codec.fail(err)
+			return false
+		}
+
+		if codec.object == nil {
+			return true
+		}
+
+		return codec.readChunk()
+	}
+
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		codec.fail(err)
+		return false
+	}
+
+	if codec.tooLarge(int64(len(data))) {
+		return true
+	}
+
+	sum := sha256.Sum256(data)
+	codec.object = newContentObject(sum[:], int64(len(data)), data)
+	codec.value = common.MapStr{
+		"event":   codec.encode(data),
+		"content": codec.object.Clone(),
+		"file":    codec.path,
+		"line":    1,
+	}
+
+	return true
+}
+
+// spool copies the object to a temporary file and works out where each
+// chunk ends, so the number of chunks and the hash of the object are known
+// before the first chunk is sent.
+func (codec *contentCodec) spool(input io.Reader) error {
+	file, err := ioutil.TempFile(codec.config.TempDir, "gcsbeat-content-")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+
+	codec.file = file
+
+	hash := sha256.New()
+	output := io.MultiWriter(file, hash)
+
+	codec.buffer = make([]byte, codec.config.ChunkSize)
+	var sniff []byte
+	var size int64
+	carry := 0
+
+	for {
+		n, err := io.ReadFull(input, codec.buffer[carry:])
+		if _, writeErr := output.Write(codec.buffer[carry : carry+n]); writeErr != nil {
+			return fmt.Errorf("error copying to temporary file: %v", writeErr)
+		}
+
+		if len(sniff) < mimeSniffLength {
+			sniff = append(sniff, codec.buffer[carry:carry+n]...)
+		}
+
+		size += int64(n)
+		if codec.tooLarge(size) {
+			return nil
+		}
+
+		length := carry + n
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+
+		end := length
+		if codec.text && !last {
+			end = utf8ChunkEnd(codec.buffer[:length])
+		}
+
+		// empty objects are sent as one empty chunk
+		if end > 0 || len(codec.chunks) == 0 && last {
+			codec.chunks = append(codec.chunks, end)
+		}
+
+		if last {
+			break
+		}
+
+		carry = copy(codec.buffer, codec.buffer[end:length])
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("error reading temporary file: %v", err)
+	}
+
+	codec.object = newContentObject(hash.Sum(nil), size, sniff)
+	return nil
+}
+
+// readChunk reads the next chunk from the temporary file.
+func (codec *contentCodec) readChunk() bool {
+	chunk := codec.buffer[:codec.chunks[codec.sequence]]
+	if _, err := io.ReadFull(codec.file, chunk); err != nil {
+		codec.fail(fmt.Errorf("error reading temporary file: %v", err))
+		return false
+	}
+
+	codec.sequence++
+
+	content := codec.object.Clone()
+	content["chunk"] = common.MapStr{
+		"sequence": codec.sequence,
+		"total":    len(codec.chunks),
+		"offset":   codec.offset,
+		"size":     len(chunk),
+	}
+
+	codec.value = common.MapStr{
+		"event":   codec.encode(chunk),
+		"content": content,
+		"file":    codec.path,
+		"line":    codec.sequence,
+	}
+
+	codec.offset += int64(len(chunk))
+	return true
+}
+
+// tooLarge checks the size read so far against max_size, objects that are
+// too large are sent as a single event saying why they were skipped.
+func (codec *contentCodec) tooLarge(size int64) bool {
+	maxSize := *codec.config.MaxSize
+	if maxSize == 0 || size <= maxSize {
+		return false
+	}
+
+	codec.chunks = nil
+	codec.value = common.MapStr{
+		"content": common.MapStr{
+			"skipped": fmt.Sprintf("the object is larger than the max_size of %d bytes", maxSize),
+		},
+		"file": codec.path,
+		"line": 1,
+	}
+
+	codec.close()
+	return true
+}
+
+// newContentObject describes the whole object, the MIME type is detected
+// from its first bytes.
+func newContentObject(sum []byte, size int64, sniff []byte) common.MapStr {
+	return common.MapStr{
+		"size":      size,
+		"sha256":    hex.EncodeToString(sum),
+		"mime_type": http.DetectContentType(sniff),
+	}
+}
+
+// utf8ChunkEnd returns where a chunk of text ends so it doesn't split a
+// character, data may end part way through one. Invalid UTF-8 is split
+// anywhere.
+func utf8ChunkEnd(data []byte) int {
+	for i := len(data) - 1; i > 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+
+			break
+		}
+	}
+
+	return len(data)
+}
+
+// fail stops the codec with err and removes the temporary file.
+func (codec *contentCodec) fail(err error) {
+	codec.err = err
+	codec.close()
+}
+
+func (codec *contentCodec) close() {
+	if codec.file != nil {
+		codec.file.Close()
+		os.Remove(codec.file.Name())
+		codec.file = nil
+	}
+}
+
+func (codec *contentCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *contentCodec) Err() error {
+	return codec.err
+}
@@ -0,0 +1,251 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// sha256 of "hello, world\n"
+const testContentSha256 = "853ff93762a06ddbf722c4ebe9ddd66d8f63ddaea97f521c3ecc20da7c976020"
+
+func TestContentCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"negative chunk size": {"chunk_size": -1},
+		"negative max size":   {"max_size": -1},
+		"missing temp dir":    {"temp_dir": "/does/not/exist"},
+	}
+
+	for tn, tc := range cases {
+		if _, err := NewBlobCodec(testOptions(t, tc), "testfile", strings.NewReader("")); err == nil {
+			t.Errorf("%q | Expected an error from blob", tn)
+		}
+
+		if _, err := NewClobCodec(testOptions(t, tc), "testfile", strings.NewReader("")); err == nil {
+			t.Errorf("%q | Expected an error from clob", tn)
+		}
+	}
+}
+
+func TestContentCodecObject(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"whole":   nil,
+		"chunked": {"chunk_size": 5},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewClobCodec(testOptions(t, tc), "testfile", strings.NewReader("hello, world\n"))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		var events []string
+		for c.Next() {
+			content := c.Value()["content"].(common.MapStr)
+			if content["sha256"] != testContentSha256 {
+				t.Errorf("%q | Expected the hash of the whole object, got %v", tn, content["sha256"])
+			}
+
+			if content["mime_type"] != "text/plain; charset=utf-8" || content["size"] != int64(13) {
+				t.Errorf("%q | Expected a 13 byte text object, got %v", tn, content)
+			}
+
+			events = append(events, c.Value()["event"].(string))
+		}
+
+		if c.Err() != nil {
+			t.Errorf("%q | Unexpected error %v", tn, c.Err())
+		}
+
+		if strings.Join(events, "") != "hello, world\n" {
+			t.Errorf("%q | Expected the whole object, got %q", tn, events)
+		}
+	}
+}
+
+func TestContentCodecChunks(t *testing.T) {
+	cases := map[string]struct {
+		Codec    string
+		Data     string
+		Options  map[string]interface{}
+		Expected []string
+	}{
+		"blob": {
+			Codec:    BlobCodecId,
+			Data:     "abcdefgh",
+			Options:  map[string]interface{}{"chunk_size": 3},
+			Expected: []string{"YWJj 1/3@0+3", "ZGVm 2/3@3+3", "Z2g= 3/3@6+2"},
+		},
+		"exact multiple": {
+			Codec:    ClobCodecId,
+			Data:     "abcdef",
+			Options:  map[string]interface{}{"chunk_size": 3},
+			Expected: []string{"abc 1/2@0+3", "def 2/2@3+3"},
+		},
+		"one chunk": {
+			Codec:    ClobCodecId,
+			Data:     "abc",
+			Options:  map[string]interface{}{"chunk_size": 1024},
+			Expected: []string{"abc 1/1@0+3"},
+		},
+		"empty": {
+			Codec:    ClobCodecId,
+			Data:     "",
+			Options:  map[string]interface{}{"chunk_size": 3},
+			Expected: []string{" 1/1@0+0"},
+		},
+		// the euro sign is 3 bytes and gets a chunk to itself
+		"utf-8 isn't split": {
+			Codec:    ClobCodecId,
+			Data:     "abécd€",
+			Options:  map[string]interface{}{"chunk_size": 3},
+			Expected: []string{"ab 1/4@0+2", "éc 2/4@2+3", "d 3/4@5+1", "€ 4/4@6+3"},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewCodec(tc.Codec, testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		var actual []string
+		for c.Next() {
+			chunk, _ := c.Value().GetValue("content.chunk")
+			fields := chunk.(common.MapStr)
+			actual = append(actual, fmt.Sprintf("%s %d/%d@%d+%d", c.Value()["event"],
+				fields["sequence"], fields["total"], fields["offset"], fields["size"]))
+
+			if c.Value()["line"] != fields["sequence"] {
+				t.Errorf("%q | Expected the line to be the sequence number, got %v", tn, c.Value()["line"])
+			}
+		}
+
+		if c.Err() != nil {
+			t.Errorf("%q | Unexpected error %v", tn, c.Err())
+		}
+
+		if fmt.Sprintf("%q", actual) != fmt.Sprintf("%q", tc.Expected) {
+			t.Errorf("%q | Expected %q, got %q", tn, tc.Expected, actual)
+		}
+	}
+}
+
+func TestContentCodecMaxSize(t *testing.T) {
+	cases := map[string]struct {
+		Options map[string]interface{}
+		Skipped bool
+	}{
+		"whole at the limit":   {Options: map[string]interface{}{"max_size": 13}},
+		"whole too large":      {Options: map[string]interface{}{"max_size": 12}, Skipped: true},
+		"chunked at the limit": {Options: map[string]interface{}{"max_size": 13, "chunk_size": 4}},
+		"chunked too large":    {Options: map[string]interface{}{"max_size": 12, "chunk_size": 4}, Skipped: true},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewBlobCodec(testOptions(t, tc.Options), "testfile", strings.NewReader("hello, world\n"))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		if !c.Next() {
+			t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+		}
+
+		skipped, _ := c.Value().GetValue("content.skipped")
+		if (skipped != nil) != tc.Skipped {
+			t.Errorf("%q | Got skip reason %v, expected? %v", tn, skipped, tc.Skipped)
+		}
+
+		if tc.Skipped {
+			if skipped != "the object is larger than the max_size of 12 bytes" {
+				t.Errorf("%q | Unexpected skip reason %v", tn, skipped)
+			}
+
+			if _, ok := c.Value()["event"]; ok {
+				t.Errorf("%q | Expected no content in %v", tn, c.Value())
+			}
+
+			if c.Next() || c.Err() != nil {
+				t.Errorf("%q | Expected a single event, got %v", tn, c.Err())
+			}
+		}
+	}
+}
+
+func TestContentCodecDefaultMaxSize(t *testing.T) {
+	cases := map[string]struct {
+		Options  map[string]interface{}
+		Expected int64
+	}{
+		"whole":               {Options: nil, Expected: defaultContentMaxSize},
+		"chunked":             {Options: map[string]interface{}{"chunk_size": 4}, Expected: 0},
+		"whole without limit": {Options: map[string]interface{}{"max_size": 0}, Expected: 0},
+		"whole with limit":    {Options: map[string]interface{}{"max_size": 12}, Expected: 12},
+	}
+
+	for tn, tc := range cases {
+		config, err := newContentConfig("blob", testOptions(t, tc.Options))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		if *config.MaxSize != tc.Expected {
+			t.Errorf("%q | Expected a max_size of %d, got %d", tn, tc.Expected, *config.MaxSize)
+		}
+	}
+}
+
+func TestContentCodecTempFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	options := testOptions(t, map[string]interface{}{"temp_dir": dir, "chunk_size": 2})
+	c, err := NewBlobCodec(options, "testfile", strings.NewReader("abcde"))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if !c.Next() {
+		t.Fatalf("Quit too early: %v", c.Err())
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected the object to be copied to a temporary file, got %d files", len(files))
+	}
+
+	count := 1
+	for c.Next() {
+		count++
+	}
+
+	if c.Err() != nil || count != 3 {
+		t.Errorf("Expected 3 chunks and no error, got %d and %v", count, c.Err())
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected the temporary file to be removed, got %d files", len(files))
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	CsvTypeString    = "string"
+	CsvTypeInt       = "int"
+	CsvTypeFloat     = "float"
+	CsvTypeBool      = "bool"
+	CsvTypeTimestamp = "timestamp"
+
+	CsvHeaderTrue  = "true"
+	CsvHeaderFalse = "false"
+	CsvHeaderAuto  = "auto"
+)
+
+// CsvConfig holds the options for the csv codec.
+type CsvConfig struct {
+	// Separator is the single character between fields, use "\t" for TSV files.
+	Separator string `config:"separator"`
+
+	// Comment is an optional character, lines starting with it are skipped.
+	Comment string `config:"comment"`
+
+	// LazyQuotes allows quotes to appear in unquoted fields and non-doubled
+	// quotes in quoted fields.
+	LazyQuotes bool `config:"lazy_quotes"`
+
+	// TrimLeadingSpace ignores leading whitespace in fields.
+	TrimLeadingSpace bool `config:"trim_leading_space"`
+
+	// Header treats the first row of the file as the column names, auto
+	// detects whether it is one.
+	Header string `config:"header"`
+
+	// Columns explicitly names the columns, it takes precedence over the header.
+	Columns []string `config:"columns"`
+
+	// Types maps column names to the type the value is converted to.
+	Types map[string]string `config:"types"`
+
+	// TimestampLayout is the Go time layout used to parse timestamp columns.
+	TimestampLayout string `config:"timestamp_layout"`
+}
+
+var defaultCsvConfig = CsvConfig{
+	Separator:       ",",
+	Header:          CsvHeaderFalse,
+	TimestampLayout: time.RFC3339,
+}
+
+func (c *CsvConfig) Validate() error {
+	if utf8.RuneCountInString(c.Separator) != 1 {
+		return fmt.Errorf("csv separator must be a single character, got %q", c.Separator)
+	}
+
+	if c.Comment != "" && utf8.RuneCountInString(c.Comment) != 1 {
+		return fmt.Errorf("csv comment must be a single character, got %q", c.Comment)
+	}
+
+	if c.Comment == c.Separator {
+		return fmt.Errorf("csv comment and separator must be different, both are %q", c.Comment)
+	}
+
+	for _, r := range c.Separator + c.Comment {
+		if r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return fmt.Errorf("csv separator and comment can't be quotes or newlines, got %q", r)
+		}
+	}
+
+	switch c.Header {
+	case CsvHeaderTrue, CsvHeaderFalse, CsvHeaderAuto:
+	default:
+		return fmt.Errorf("csv header must be one of %q, %q or %q, got %q",
+			CsvHeaderTrue, CsvHeaderFalse, CsvHeaderAuto, c.Header)
+	}
+
+	for column, typ := range c.Types {
+		switch typ {
+		case CsvTypeString, CsvTypeInt, CsvTypeFloat, CsvTypeBool, CsvTypeTimestamp:
+		default:
+			return fmt.Errorf("csv column %q has unknown type %q", column, typ)
+		}
+	}
+
+	return nil
+}
+
+func newCsvConfig(options *common.Config) (*CsvConfig, error) {
+	config, err := unpackCodecConfig("csv", defaultCsvConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*CsvConfig), nil
+}
+
+func init() {
+	Register(CsvCodecId, NewCsvCodec, defaultCsvConfig)
+}
+
+func NewCsvCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newCsvConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return newCsvCodec(config, path, input), nil
+}
+
+// newCsvCodec creates a csv codec with a config that's already validated.
+func newCsvCodec(config *CsvConfig, path string, input io.Reader) *CsvCodec {
+	reader := csv.NewReader(input)
+	reader.Comma, _ = utf8.DecodeRuneInString(config.Separator)
+	if config.Comment != "" {
+		reader.Comment, _ = utf8.DecodeRuneInString(config.Comment)
+	}
+	reader.LazyQuotes = config.LazyQuotes
+	reader.TrimLeadingSpace = config.TrimLeadingSpace
+	reader.FieldsPerRecord = -1
+
+	return &CsvCodec{
+		reader:     reader,
+		config:     config,
+		columns:    config.Columns,
+		readHeader: config.Header == CsvHeaderFalse,
+		path:       path,
+	}
+}
+
+// CsvCodec reads a delimited text file row by row. Each row is sent as an
+// event with the values keyed by their column name and converted to the
+// configured types.
+type CsvCodec struct {
+	reader     *csv.Reader
+	config     *CsvConfig
+	columns    []string
+	readHeader bool
+
+	// peeked holds the row after the first one, read to detect the header
+	peeked    []string
+	peekedErr error
+	hasPeeked bool
+
+	// record is the last row read, before it's converted
+	record     []string
+	value      common.MapStr
+	err        error
+	bad        []byte
+	lineNumber int
+	path       string
+}
+
+func (codec *CsvCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	record, err := codec.read()
+	if err == nil && !codec.readHeader {
+		codec.readHeader = true
+
+		isHeader := codec.config.Header == CsvHeaderTrue
+		if codec.config.Header == CsvHeaderAuto {
+			codec.peeked, codec.peekedErr = codec.reader.Read()
+			codec.hasPeeked = true
+			isHeader = codec.looksLikeHeader(record, codec.peeked)
+		}
+
+		if isHeader {
+			// explicit columns override the header but it still gets skipped
+			if len(codec.columns) == 0 {
+				codec.columns = record
+			}
+
+			record, err = codec.read()
+		}
+	}
+
+	if err != nil {
+		// rows that can't be parsed still count so the numbers stay in step
+		if _, ok := err.(*csv.ParseError); ok {
+			codec.lineNumber++
+			codec.bad = []byte{}
+		}
+
+		codec.err = err
+		return false
+	}
+
+	codec.lineNumber++
+	codec.record = record
+	row, err := codec.convert(record)
+	if err != nil {
+		codec.err = fmt.Errorf("row %d: %v", codec.lineNumber, err)
+		codec.bad = codec.raw()
+		return false
+	}
+
+	codec.value = common.MapStr{
+		"csv":  row,
+		"file": codec.path,
+		"line": codec.lineNumber,
+	}
+
+	return true
+}
+
+// raw is the last row read joined back together, quotes aren't restored.
+func (codec *CsvCodec) raw() []byte {
+	return []byte(strings.Join(codec.record, codec.config.Separator))
+}
+
+func (codec *CsvCodec) read() ([]string, error) {
+	if codec.hasPeeked {
+		codec.hasPeeked = false
+		return codec.peeked, codec.peekedErr
+	}
+
+	return codec.reader.Read()
+}
+
+// looksLikeHeader guesses whether first names the columns. A header has a
+// distinct, non-empty name for each column and none of them are numbers or
+// convert to the column's type, while the next row has a value that does.
+// Without that evidence, e.g. if every value is text, the row is data.
+func (codec *CsvCodec) looksLikeHeader(first []string, next []string) bool {
+	names := make(map[string]bool)
+	for _, name := range first {
+		if name == "" || names[name] || codec.looksTyped(name, name) {
+			return false
+		}
+
+		names[name] = true
+	}
+
+	for i, text := range next {
+		if i < len(first) && text != "" && codec.looksTyped(first[i], text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// looksTyped checks if text is a number, or converts to the type configured
+// for the column.
+func (codec *CsvCodec) looksTyped(column string, text string) bool {
+	if _, err := strconv.ParseFloat(text, 64); err == nil {
+		return true
+	}
+
+	typ := codec.config.Types[column]
+	if typ == "" || typ == CsvTypeString {
+		return false
+	}
+
+	_, err := convertCsvValue(typ, text, codec.config.TimestampLayout)
+	return err == nil
+}
+
+func (codec *CsvCodec) convert(record []string) (common.MapStr, error) {
+	row := common.MapStr{}
+
+	for i, text := range record {
+		column := codec.columnName(i)
+		typ := codec.config.Types[column]
+
+		if typ == "" || typ == CsvTypeString {
+			row[column] = text
+			continue
+		}
+
+		// empty cells have no value to convert so they're left out
+		if text == "" {
+			continue
+		}
+
+		value, err := convertCsvValue(typ, text, codec.config.TimestampLayout)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %v", column, err)
+		}
+
+		row[column] = value
+	}
+
+	return row, nil
+}
+
+func (codec *CsvCodec) columnName(index int) string {
+	if index < len(codec.columns) && codec.columns[index] != "" {
+		return codec.columns[index]
+	}
+
+	// columns are numbered from 1 like lines
+	return fmt.Sprintf("column%d", index+1)
+}
+
+func convertCsvValue(typ, text, timestampLayout string) (interface{}, error) {
+	switch typ {
+	case CsvTypeInt:
+		return strconv.ParseInt(text, 10, 64)
+	case CsvTypeFloat:
+		return strconv.ParseFloat(text, 64)
+	case CsvTypeBool:
+		return strconv.ParseBool(text)
+	case CsvTypeTimestamp:
+		return time.Parse(timestampLayout, text)
+	default:
+		return text, nil
+	}
+}
+
+func (codec *CsvCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *CsvCodec) Err() error {
+	// EOF is the expected end of the file
+	if codec.err == io.EOF {
+		return nil
+	}
+
+	return codec.err
+}
+
+// Recover skips the row that couldn't be parsed or converted. Rows with bad
+// quoting are skipped up to where the parser could carry on, their raw text
+// isn't kept.
+func (codec *CsvCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.lineNumber, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
@@ -0,0 +1,300 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestCsvCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"long separator":     {"separator": ";;"},
+		"empty separator":    {"separator": ""},
+		"quote separator":    {"separator": `"`},
+		"newline separator":  {"separator": "\n"},
+		"long comment":       {"comment": "//"},
+		"comment separator":  {"comment": ","},
+		"unknown type":       {"types": map[string]interface{}{"a": "uuid"}},
+		"unknown type alias": {"types": map[string]interface{}{"a": "integer"}},
+		"unknown header":     {"header": "maybe"},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewCsvCodec(testOptions(t, tc), "testfile", strings.NewReader(""))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestCsvCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Options   map[string]interface{}
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   ``,
+			Length: 0,
+		},
+		"header only": {
+			Data:    "a,b",
+			Options: map[string]interface{}{"header": true},
+			Length:  0,
+		},
+		"no header": {
+			Data:   "1,2\n3,4",
+			Length: 2,
+		},
+		"header": {
+			Data:    "a,b\n1,2\n3,4\n",
+			Options: map[string]interface{}{"header": true},
+			Length:  2,
+		},
+		"comments": {
+			Data:    "# export\n1,2\n# more\n3,4",
+			Options: map[string]interface{}{"comment": "#"},
+			Length:  2,
+		},
+		"bad quotes": {
+			Data:      "1,2\n3,\"4\n",
+			Length:    1,
+			ExpectErr: true,
+		},
+		"bad type": {
+			Data:      "a\n1\nb\n",
+			Options:   map[string]interface{}{"header": true, "types": map[string]interface{}{"a": "int"}},
+			Length:    1,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewCsvCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d rows, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestCsvCodecValue(t *testing.T) {
+	ts := time.Date(2018, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		Data     string
+		Options  map[string]interface{}
+		Expected []common.MapStr
+	}{
+		"positional columns": {
+			Data: "a,b\nc",
+			Expected: []common.MapStr{
+				{"column1": "a", "column2": "b"},
+				{"column1": "c"},
+			},
+		},
+		"header columns": {
+			Data:    "name,count\nfoo,3\n",
+			Options: map[string]interface{}{"header": true},
+			Expected: []common.MapStr{
+				{"name": "foo", "count": "3"},
+			},
+		},
+		"explicit columns skip header": {
+			Data:    "name,count\nfoo,3\n",
+			Options: map[string]interface{}{"header": true, "columns": []string{"n", "c"}},
+			Expected: []common.MapStr{
+				{"n": "foo", "c": "3"},
+			},
+		},
+		"extra values": {
+			Data:    "foo,3,x\n",
+			Options: map[string]interface{}{"columns": []string{"n", "c"}},
+			Expected: []common.MapStr{
+				{"n": "foo", "c": "3", "column3": "x"},
+			},
+		},
+		"tsv with quotes": {
+			Data:    "\"a\tb\"\t\"say \"\"hi\"\"\"\n",
+			Options: map[string]interface{}{"separator": "\t"},
+			Expected: []common.MapStr{
+				{"column1": "a\tb", "column2": `say "hi"`},
+			},
+		},
+		"typed": {
+			Data: "i,f,b,t,s\n-3,1.5,true,2018-06-01T12:30:00Z,007\n,,,,\n",
+			Options: map[string]interface{}{
+				"header": true,
+				"types": map[string]interface{}{
+					"i": "int",
+					"f": "float",
+					"b": "bool",
+					"t": "timestamp",
+					"s": "string",
+				},
+			},
+			Expected: []common.MapStr{
+				{"i": int64(-3), "f": 1.5, "b": true, "t": ts, "s": "007"},
+				{"s": ""},
+			},
+		},
+		"detected header": {
+			Data:    "name,count\nfoo,3\n",
+			Options: map[string]interface{}{"header": "auto"},
+			Expected: []common.MapStr{
+				{"name": "foo", "count": "3"},
+			},
+		},
+		"detected header from types": {
+			Data:    "name,joined\nfoo,2018-06-01T12:30:00Z\n",
+			Options: map[string]interface{}{"header": "auto", "types": map[string]interface{}{"joined": "timestamp"}},
+			Expected: []common.MapStr{
+				{"name": "foo", "joined": ts},
+			},
+		},
+		"numeric first row isn't a header": {
+			Data:    "1,2\n3,4\n",
+			Options: map[string]interface{}{"header": "auto"},
+			Expected: []common.MapStr{
+				{"column1": "1", "column2": "2"},
+				{"column1": "3", "column2": "4"},
+			},
+		},
+		"text only isn't a header": {
+			Data:    "foo,bar\nbaz,qux\n",
+			Options: map[string]interface{}{"header": "auto"},
+			Expected: []common.MapStr{
+				{"column1": "foo", "column2": "bar"},
+				{"column1": "baz", "column2": "qux"},
+			},
+		},
+		"repeated names aren't a header": {
+			Data:    "a,a\nb,1\n",
+			Options: map[string]interface{}{"header": "auto"},
+			Expected: []common.MapStr{
+				{"column1": "a", "column2": "a"},
+				{"column1": "b", "column2": "1"},
+			},
+		},
+		"custom timestamp": {
+			Data: "2018-06-01 12:30:00\n",
+			Options: map[string]interface{}{
+				"columns":          []string{"t"},
+				"types":            map[string]interface{}{"t": "timestamp"},
+				"timestamp_layout": "2006-01-02 15:04:05",
+			},
+			Expected: []common.MapStr{
+				{"t": ts},
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewCsvCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		for i, row := range tc.Expected {
+			if !c.Next() {
+				t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+			}
+
+			expected := common.MapStr{
+				"csv":  row,
+				"file": "testfile",
+				"line": i + 1,
+			}
+
+			expectedS := fmt.Sprintf("%v", expected)
+			actualS := fmt.Sprintf("%v", c.Value())
+			if expectedS != actualS {
+				t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+			}
+		}
+
+		if c.Next() {
+			t.Errorf("%q | Expected end of input, got %v", tn, c.Value())
+		}
+	}
+}
+
+func TestCsvCodecRecover(t *testing.T) {
+	cases := map[string]struct {
+		Data    string
+		Options map[string]interface{}
+		// Expected holds the a column of each row, or the row of error events
+		Expected []interface{}
+	}{
+		"bad type": {
+			Data:     "a\n1\nb\n3\n",
+			Options:  map[string]interface{}{"header": true, "types": map[string]interface{}{"a": "int"}},
+			Expected: []interface{}{int64(1), 2, int64(3)},
+		},
+		"bare quote": {
+			Data:     "a\n1\n2\"x\n3\n",
+			Options:  map[string]interface{}{"header": true},
+			Expected: []interface{}{"1", 2, "3"},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewCsvCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		var actual []interface{}
+		for {
+			for c.Next() {
+				actual = append(actual, c.Value()["csv"].(common.MapStr)["a"])
+			}
+
+			if c.Err() == nil {
+				break
+			}
+
+			event, err := c.(RecoverableCodec).Recover()
+			if err != nil {
+				t.Fatalf("%q | Unexpected error recovering from %v: %v", tn, c.Err(), err)
+			}
+
+			actual = append(actual, event["line"])
+		}
+
+		if fmt.Sprintf("%#v", actual) != fmt.Sprintf("%#v", tc.Expected) {
+			t.Errorf("%q | Expected %#v, got %#v", tn, tc.Expected, actual)
+		}
+	}
+}
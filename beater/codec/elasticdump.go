@@ -0,0 +1,164 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/outputs/elasticsearch"
+)
+
+// maxDocumentLength is the longest line the Elasticsearch document codecs
+// read, documents are often longer than the scanner's default of 64KiB.
+const maxDocumentLength = 64 * 1024 * 1024
+
+// documentJson decodes Elasticsearch documents keeping the precision of
+// numbers.
+var documentJson = &JsonConfig{UseNumber: true}
+
+func newDocumentScanner(input io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), maxDocumentLength)
+	return scanner
+}
+
+// elasticdumpHit is a line of an elasticdump data file.
+type elasticdumpHit struct {
+	Index   string `json:"_index"`
+	Type    string `json:"_type"`
+	Id      string `json:"_id"`
+	Routing string `json:"_routing"`
+
+	// older versions of Elasticsearch return the routing with the fields
+	Fields struct {
+		Routing string `json:"_routing"`
+	} `json:"fields"`
+
+	Source map[string]interface{} `json:"_source"`
+}
+
+func init() {
+	Register(ElasticdumpCodecId, withoutOptions(NewElasticdumpCodec), nil)
+}
+
+func NewElasticdumpCodec(path string, input io.Reader) Codec {
+	return &ElasticdumpCodec{
+		scanner: newDocumentScanner(input),
+		path:    path,
+	}
+}
+
+// ElasticdumpCodec reads the data files written by elasticdump, one search
+// hit per line. Each hit's _source is sent as the event and its index, type,
+// ID and routing are put in @metadata so it's restored where it came from.
+// The Elasticsearch output always uses its own type, the type is only kept
+// for outputs that pass @metadata on like Logstash.
+type ElasticdumpCodec struct {
+	scanner    *bufio.Scanner
+	value      common.MapStr
+	err        error
+	bad        []byte
+	lineNumber int
+	path       string
+}
+
+func (codec *ElasticdumpCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for codec.scanner.Scan() {
+		codec.lineNumber++
+
+		line := codec.scanner.Bytes()
+		if strings.TrimSpace(string(line)) == "" {
+			continue
+		}
+
+		fields, err := codec.parse(line)
+		if err != nil {
+			codec.err = fmt.Errorf("line %d: %v", codec.lineNumber, err)
+			codec.bad = append([]byte(nil), line...)
+			return false
+		}
+
+		codec.value = fields
+		return true
+	}
+
+	codec.err = codec.scanner.Err()
+	return false
+}
+
+func (codec *ElasticdumpCodec) parse(line []byte) (common.MapStr, error) {
+	var hit elasticdumpHit
+	if err := documentJson.unmarshal(line, &hit); err != nil {
+		return nil, err
+	}
+
+	if hit.Source == nil {
+		return nil, errors.New("the hit has no _source")
+	}
+
+	fields := common.MapStr(documentJson.normalize(hit.Source, 1).(map[string]interface{}))
+
+	routing := hit.Routing
+	if routing == "" {
+		routing = hit.Fields.Routing
+	}
+
+	// the raw index is used without the date the output adds to index
+	meta := common.MapStr{}
+	for key, value := range map[string]string{
+		elasticsearch.RawIndexMetaKey: hit.Index,
+		"type":                        hit.Type,
+		"id":                          hit.Id,
+		elasticsearch.RoutingMetaKey:  routing,
+	} {
+		if value != "" {
+			meta[key] = value
+		}
+	}
+
+	if len(meta) > 0 {
+		fields["@metadata"] = meta
+	}
+
+	return fields, nil
+}
+
+func (codec *ElasticdumpCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *ElasticdumpCodec) Err() error {
+	return codec.err
+}
+
+// Recover skips a line that isn't a hit.
+func (codec *ElasticdumpCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.lineNumber, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
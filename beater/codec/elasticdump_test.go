@@ -0,0 +1,130 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestElasticdumpCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Length    int
+		ExpectErr bool
+	}{
+		"empty":          {Data: "", Length: 0},
+		"hits":           {Data: `{"_index":"a","_id":"1","_source":{}}` + "\n\n" + `{"_index":"a","_id":"2","_source":{"b":1}}`, Length: 2},
+		"missing source": {Data: `{"_index":"a","_id":"1"}`, Length: 0, ExpectErr: true},
+		"not json":       {Data: `{"_index":"a","_id":"1","_source":{}}` + "\nnope\n", Length: 1, ExpectErr: true},
+		"source array":   {Data: `{"_source":[1]}`, Length: 0, ExpectErr: true},
+	}
+
+	for tn, tc := range cases {
+		c := NewElasticdumpCodec("testfile", strings.NewReader(tc.Data))
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected %d events, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestElasticdumpCodecValue(t *testing.T) {
+	cases := map[string]struct {
+		Line     string
+		Expected common.MapStr
+	}{
+		"hit": {
+			Line: `{"_index":"logs-2018","_type":"doc","_id":"abc","_score":1,"_source":{"message":"hi","bytes":9007199254740993,"user":{"name":"x"}}}`,
+			Expected: common.MapStr{
+				"message":   "hi",
+				"bytes":     int64(9007199254740993),
+				"user":      map[string]interface{}{"name": "x"},
+				"@metadata": common.MapStr{"raw_index": "logs-2018", "type": "doc", "id": "abc"},
+			},
+		},
+		"routing": {
+			Line: `{"_index":"a","_id":"1","_routing":"r1","_source":{}}`,
+			Expected: common.MapStr{
+				"@metadata": common.MapStr{"raw_index": "a", "id": "1", "routing": "r1"},
+			},
+		},
+		"routing in fields": {
+			Line: `{"_index":"a","_id":"1","fields":{"_routing":"r2"},"_source":{}}`,
+			Expected: common.MapStr{
+				"@metadata": common.MapStr{"raw_index": "a", "id": "1", "routing": "r2"},
+			},
+		},
+		"source only": {
+			Line:     `{"_source":{"a":1.5}}`,
+			Expected: common.MapStr{"a": 1.5},
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewElasticdumpCodec("testfile", strings.NewReader(tc.Line))
+		if !c.Next() {
+			t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+		}
+
+		expected := fmt.Sprintf("%v", tc.Expected)
+		actual := fmt.Sprintf("%v", c.Value())
+		if expected != actual {
+			t.Errorf("%q | Expected %v, got %v", tn, expected, actual)
+		}
+	}
+}
+
+func TestElasticdumpCodecRecover(t *testing.T) {
+	data := `{"_id":"1","_source":{}}` + "\n" + `{"_id":"2"}` + "\n" + `{"_id":"3","_source":{}}`
+	c := NewElasticdumpCodec("testfile", strings.NewReader(data))
+
+	var ids []interface{}
+	for {
+		for c.Next() {
+			ids = append(ids, c.Value()["@metadata"].(common.MapStr)["id"])
+		}
+
+		if c.Err() == nil {
+			break
+		}
+
+		event, err := c.(RecoverableCodec).Recover()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if event["line"] != 2 || event["error"].(common.MapStr)["raw"] != `{"_id":"2"}` {
+			t.Errorf("Expected an error event for line 2, got %v", event)
+		}
+	}
+
+	if fmt.Sprintf("%v", ids) != "[1 3]" {
+		t.Errorf("Expected hits 1 and 3, got %v", ids)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/outputs/elasticsearch"
+)
+
+const (
+	BulkActionIndex  = "index"
+	BulkActionCreate = "create"
+	BulkActionUpdate = "update"
+	BulkActionDelete = "delete"
+)
+
+// bulkMetadata maps the action's parameters to the @metadata keys, the index
+// is a raw_index so the output doesn't add a date to it. The first parameter
+// set wins, so routing is preferred to the older _routing.
+var bulkMetadata = []struct {
+	param string
+	key   string
+}{
+	{"_index", elasticsearch.RawIndexMetaKey},
+	{"_id", "id"},
+	{"pipeline", "pipeline"},
+	{"routing", elasticsearch.RoutingMetaKey},
+	{"_routing", elasticsearch.RoutingMetaKey},
+}
+
+func init() {
+	Register(ElasticsearchBulkCodecId, withoutOptions(NewElasticsearchBulkCodec), nil)
+}
+
+func NewElasticsearchBulkCodec(path string, input io.Reader) Codec {
+	return &ElasticsearchBulkCodec{
+		scanner: newDocumentScanner(input),
+		logger:  logp.NewLogger("elasticsearch-bulk"),
+		path:    path,
+	}
+}
+
+// ElasticsearchBulkCodec replays Elasticsearch bulk request bodies: action
+// lines each followed by a source line, except for deletes. Each source is
+// sent as the event with the action's type, index, ID, pipeline and routing
+// in @metadata. The output can only index and create documents, so updates
+// and deletes are skipped with a warning.
+type ElasticsearchBulkCodec struct {
+	scanner *bufio.Scanner
+	logger  *logp.Logger
+	value   common.MapStr
+	err     error
+
+	// bad holds the lines of a malformed pair starting at badLine,
+	// badAction is set if the action couldn't be read
+	bad       []byte
+	badLine   int
+	badAction bool
+
+	// pending is a line read ahead while recovering
+	pending []byte
+
+	lineNumber int
+	path       string
+}
+
+func (codec *ElasticsearchBulkCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for {
+		action, ok := codec.scanLine()
+		if !ok {
+			return false
+		}
+
+		actionLine := codec.lineNumber
+		op, meta, err := parseBulkAction(action)
+		if err != nil {
+			return codec.fail(actionLine, err, action, true)
+		}
+
+		if op == BulkActionDelete {
+			codec.logger.Warnf("Skipping the delete action on line %d of %q, deletes can't be replayed", actionLine, codec.path)
+			continue
+		}
+
+		source, ok := codec.scanLine()
+		if !ok {
+			if codec.err == nil {
+				codec.fail(actionLine, fmt.Errorf("the %s action has no source", op), action, false)
+			}
+
+			return false
+		}
+
+		// a partial document would replace the whole document
+		if op == BulkActionUpdate {
+			codec.logger.Warnf("Skipping the update action on line %d of %q, updates can't be replayed", actionLine, codec.path)
+			continue
+		}
+
+		fields, err := parseBulkSource(source)
+		if err != nil {
+			pair := append(append(append([]byte(nil), action...), '\n'), source...)
+			return codec.fail(actionLine, fmt.Errorf("source on line %d: %v", codec.lineNumber, err), pair, false)
+		}
+
+		fields["@metadata"] = meta
+		codec.value = fields
+		return true
+	}
+}
+
+// scanLine reads the next line that isn't blank.
+func (codec *ElasticsearchBulkCodec) scanLine() ([]byte, bool) {
+	if line := codec.pending; line != nil {
+		codec.pending = nil
+		return line, true
+	}
+
+	for codec.scanner.Scan() {
+		codec.lineNumber++
+
+		if line := codec.scanner.Bytes(); len(bytes.TrimSpace(line)) > 0 {
+			return append([]byte(nil), line...), true
+		}
+	}
+
+	codec.err = codec.scanner.Err()
+	return nil, false
+}
+
+func (codec *ElasticsearchBulkCodec) fail(line int, err error, raw []byte, badAction bool) bool {
+	codec.err = fmt.Errorf("line %d: %v", line, err)
+	codec.bad = raw
+	codec.badLine = line
+	codec.badAction = badAction
+	return false
+}
+
+// parseBulkAction reads an action line e.g. {"index":{"_index":"logs","_id":"1"}}.
+func parseBulkAction(line []byte) (string, common.MapStr, error) {
+	var action map[string]map[string]interface{}
+	if err := documentJson.unmarshal(line, &action); err != nil {
+		return "", nil, fmt.Errorf("invalid action: %v", err)
+	}
+
+	if len(action) != 1 {
+		return "", nil, fmt.Errorf("expected one action, got %d", len(action))
+	}
+
+	for op, params := range action {
+		switch op {
+		case BulkActionIndex, BulkActionCreate, BulkActionUpdate, BulkActionDelete:
+		default:
+			return "", nil, fmt.Errorf("unknown action %q", op)
+		}
+
+		meta := common.MapStr{elasticsearch.OpTypeMetaKey: op}
+		for _, m := range bulkMetadata {
+			if _, set := meta[m.key]; set {
+				continue
+			}
+
+			if value, ok := params[m.param]; ok && value != nil {
+				meta[m.key] = fmt.Sprintf("%v", value)
+			}
+		}
+
+		return op, meta, nil
+	}
+
+	panic("unreachable")
+}
+
+// parseBulkSource reads the document of an index or create action.
+func parseBulkSource(line []byte) (common.MapStr, error) {
+	var source map[string]interface{}
+	if err := documentJson.unmarshal(line, &source); err != nil {
+		return nil, err
+	}
+
+	if source == nil {
+		return nil, errors.New("the source is null")
+	}
+
+	return common.MapStr(documentJson.normalize(source, 1).(map[string]interface{})), nil
+}
+
+func (codec *ElasticsearchBulkCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *ElasticsearchBulkCodec) Err() error {
+	return codec.err
+}
+
+// Recover skips a malformed action and its source.
+func (codec *ElasticsearchBulkCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	err, raw := codec.err, codec.bad
+	codec.err, codec.bad = nil, nil
+
+	// the line after a bad action is its source unless it's an action
+	if codec.badAction {
+		if line, ok := codec.scanLine(); ok {
+			if _, _, actionErr := parseBulkAction(line); actionErr == nil {
+				codec.pending = line
+			} else {
+				raw = append(append(raw, '\n'), line...)
+			}
+		}
+	}
+
+	return newErrorEvent(codec.path, codec.badLine, raw, err), nil
+}
@@ -0,0 +1,260 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestElasticsearchBulkCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Length    int
+		ErrLine   int
+		ExpectErr bool
+	}{
+		"empty": {Data: "", Length: 0},
+		"pairs": {
+			Data:   "{\"index\":{}}\n{\"a\":1}\n\n{\"create\":{\"_id\":\"2\"}}\n{\"a\":2}\n",
+			Length: 2,
+		},
+		"delete": {
+			Data:   "{\"index\":{}}\n{\"a\":1}\n{\"delete\":{\"_id\":\"1\"}}\n{\"index\":{}}\n{\"a\":1}\n",
+			Length: 2,
+		},
+		"update doc": {
+			Data:   "{\"update\":{\"_id\":\"1\"}}\n{\"doc\":{\"a\":1},\"doc_as_upsert\":true}\n",
+			Length: 0,
+		},
+		"scripted update": {
+			Data:   "{\"update\":{\"_id\":\"1\"}}\n{\"script\":{\"source\":\"ctx._source.a++\"}}\n",
+			Length: 0,
+		},
+		"update without source": {
+			Data:      "{\"update\":{\"_id\":\"1\"}}\n",
+			ErrLine:   1,
+			ExpectErr: true,
+		},
+		"missing source": {
+			Data:      "{\"index\":{}}\n{\"a\":1}\n{\"index\":{}}\n",
+			Length:    1,
+			ErrLine:   3,
+			ExpectErr: true,
+		},
+		"unknown action": {
+			Data:      "{\"upsert\":{}}\n{\"a\":1}\n",
+			ErrLine:   1,
+			ExpectErr: true,
+		},
+		"two actions": {
+			Data:      "{\"index\":{},\"create\":{}}\n{\"a\":1}\n",
+			ErrLine:   1,
+			ExpectErr: true,
+		},
+		"bad source": {
+			Data:      "{\"index\":{}}\n{\"a\":1}\n{\"index\":{}}\n[1]\n",
+			Length:    1,
+			ErrLine:   3,
+			ExpectErr: true,
+		},
+		"null source": {
+			Data:      "{\"index\":{}}\nnull\n",
+			ErrLine:   1,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewElasticsearchBulkCodec("testfile", strings.NewReader(tc.Data))
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if hasErr && !strings.HasPrefix(c.Err().Error(), fmt.Sprintf("line %d:", tc.ErrLine)) {
+			t.Errorf("%q | Expected the error on line %d, got %v", tn, tc.ErrLine, c.Err())
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected %d events, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestElasticsearchBulkCodecValue(t *testing.T) {
+	data := `{"index":{"_index":"logs","_type":"doc","_id":"1","pipeline":"geoip"}}
+{"message":"hi","count":9007199254740993}
+{"create":{"_index":"logs","_id":2,"routing":"user1"}}
+{"message":"bye"}
+`
+
+	expected := []common.MapStr{
+		{
+			"message":   "hi",
+			"count":     int64(9007199254740993),
+			"@metadata": common.MapStr{"op_type": "index", "raw_index": "logs", "id": "1", "pipeline": "geoip"},
+		},
+		{
+			"message":   "bye",
+			"@metadata": common.MapStr{"op_type": "create", "raw_index": "logs", "id": "2", "routing": "user1"},
+		},
+	}
+
+	c := NewElasticsearchBulkCodec("testfile", strings.NewReader(data))
+	for i, expectedFields := range expected {
+		if !c.Next() {
+			t.Fatalf("Quit too early: %v", c.Err())
+		}
+
+		expectedS := fmt.Sprintf("%v", expectedFields)
+		actualS := fmt.Sprintf("%v", c.Value())
+		if expectedS != actualS {
+			t.Errorf("%d | Expected %v, got %v", i, expectedS, actualS)
+		}
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
+
+func TestElasticsearchBulkCodecRouting(t *testing.T) {
+	cases := map[string]struct {
+		Action   string
+		Expected interface{}
+	}{
+		"routing":      {Action: `{"index":{"routing":"a"}}`, Expected: "a"},
+		"old routing":  {Action: `{"index":{"_routing":"b"}}`, Expected: "b"},
+		"both":         {Action: `{"index":{"_routing":"b","routing":"a"}}`, Expected: "a"},
+		"null routing": {Action: `{"index":{"routing":null,"_routing":"b"}}`, Expected: "b"},
+		"no routing":   {Action: `{"index":{}}`, Expected: nil},
+	}
+
+	for tn, tc := range cases {
+		// run each case a few times so an order that depends on map iteration shows up
+		for i := 0; i < 10; i++ {
+			c := NewElasticsearchBulkCodec("testfile", strings.NewReader(tc.Action+"\n{}\n"))
+			if !c.Next() {
+				t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+			}
+
+			if routing, _ := c.Value().GetValue("@metadata.routing"); routing != tc.Expected {
+				t.Errorf("%q | Expected routing %v, got %v", tn, tc.Expected, routing)
+				break
+			}
+		}
+	}
+}
+
+// Updates and deletes are skipped without an error, so files that mix them with
+// index and create actions are read to the end under the default on_error: abort.
+func TestElasticsearchBulkCodecMixedActions(t *testing.T) {
+	data := `{"index":{"_index":"logs","_id":"1"}}
+{"message":"a"}
+{"delete":{"_index":"logs","_id":"2"}}
+{"update":{"_index":"logs","_id":"1"}}
+{"doc":{"message":"b"}}
+{"create":{"_index":"logs"}}
+{"message":"c"}
+{"delete":{"_index":"logs","_id":"3"}}
+`
+
+	c := NewElasticsearchBulkCodec("testfile", strings.NewReader(data))
+
+	var actual []interface{}
+	for c.Next() {
+		actual = append(actual, c.Value()["message"])
+	}
+
+	if c.Err() != nil {
+		t.Errorf("Unexpected error %v", c.Err())
+	}
+
+	if fmt.Sprintf("%v", actual) != "[a c]" {
+		t.Errorf("Expected the index and create sources [a c], got %v", actual)
+	}
+}
+
+func TestElasticsearchBulkCodecRecover(t *testing.T) {
+	cases := map[string]struct {
+		Data string
+		// Expected holds the message of each event, or the line of error events
+		Expected []interface{}
+		Raw      []string
+	}{
+		"bad action with source": {
+			Data:     "{\"index\":{}}\n{\"message\":\"a\"}\n{\"upsert\":{}}\n{\"message\":\"b\"}\n{\"index\":{}}\n{\"message\":\"c\"}\n",
+			Expected: []interface{}{"a", 3, "c"},
+			Raw:      []string{"{\"upsert\":{}}\n{\"message\":\"b\"}"},
+		},
+		"bad action without source": {
+			Data:     "{\"delet\":{}}\n{\"index\":{}}\n{\"message\":\"a\"}\n",
+			Expected: []interface{}{1, "a"},
+			Raw:      []string{`{"delet":{}}`},
+		},
+		"bad source": {
+			Data:     "{\"index\":{}}\n{\"message\":\n{\"index\":{}}\n{\"message\":\"a\"}\n",
+			Expected: []interface{}{1, "a"},
+			Raw:      []string{"{\"index\":{}}\n{\"message\":"},
+		},
+		"missing source at the end": {
+			Data:     "{\"index\":{}}\n{\"message\":\"a\"}\n{\"create\":{}}\n",
+			Expected: []interface{}{"a", 3},
+			Raw:      []string{`{"create":{}}`},
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewElasticsearchBulkCodec("testfile", strings.NewReader(tc.Data))
+
+		var actual []interface{}
+		var raws []string
+		for {
+			for c.Next() {
+				actual = append(actual, c.Value()["message"])
+			}
+
+			if c.Err() == nil {
+				break
+			}
+
+			event, err := c.(RecoverableCodec).Recover()
+			if err != nil {
+				t.Fatalf("%q | Unexpected error recovering from %v: %v", tn, c.Err(), err)
+			}
+
+			actual = append(actual, event["line"])
+			raws = append(raws, event["error"].(common.MapStr)["raw"].(string))
+		}
+
+		if fmt.Sprintf("%v", actual) != fmt.Sprintf("%v", tc.Expected) {
+			t.Errorf("%q | Expected %v, got %v", tn, tc.Expected, actual)
+		}
+
+		if fmt.Sprintf("%q", raws) != fmt.Sprintf("%q", tc.Raw) {
+			t.Errorf("%q | Expected raw data %q, got %q", tn, tc.Raw, raws)
+		}
+	}
+}
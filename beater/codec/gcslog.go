@@ -0,0 +1,176 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	GcsLogTypeUsage   = "usage"
+	GcsLogTypeStorage = "storage"
+)
+
+// gcsLogName matches the objects Cloud Storage writes usage and storage
+// logs to e.g. my-bucket_usage_2018_05_01_12_00_00_00a4f3d2_v0
+var gcsLogName = regexp.MustCompile(`^(.+)_(usage|storage)_(\d{4}_\d{2}_\d{2}_\d{2}_\d{2}_\d{2})_([^_]+)_v(\d+)$`)
+
+// gcsLogColumnTypes are the types of the numeric columns of both logs, the
+// other columns are strings.
+var gcsLogColumnTypes = map[string]string{
+	"time_micros":        CsvTypeInt,
+	"c_ip_type":          CsvTypeInt,
+	"sc_status":          CsvTypeInt,
+	"cs_bytes":           CsvTypeInt,
+	"sc_bytes":           CsvTypeInt,
+	"time_taken_micros":  CsvTypeInt,
+	"storage_byte_hours": CsvTypeInt,
+}
+
+func init() {
+	Register(GcsAccessLogCodecId, withoutOptions(NewGcsAccessLogCodec), nil)
+}
+
+func NewGcsAccessLogCodec(path string, input io.Reader) Codec {
+	codec := &GcsAccessLogCodec{
+		object: parseGcsLogName(path),
+		path:   path,
+	}
+
+	if codec.object == nil {
+		codec.err = fmt.Errorf("%q isn't named like a usage or storage log", path)
+		return codec
+	}
+
+	config := defaultCsvConfig
+	config.Header = CsvHeaderTrue
+	config.Types = gcsLogColumnTypes
+
+	codec.csv = newCsvCodec(&config, path, input)
+	return codec
+}
+
+// GcsAccessLogCodec reads the usage and storage logs Cloud Storage writes
+// for a bucket, which log is chosen by the object name. Rows are sent under
+// gcs_usage or gcs_storage with numbers converted and the time of the
+// request, or of the storage log, as the timestamp.
+type GcsAccessLogCodec struct {
+	csv    *CsvCodec
+	object common.MapStr
+	value  common.MapStr
+	err    error
+	bad    []byte
+	path   string
+}
+
+func (codec *GcsAccessLogCodec) Next() bool {
+	if codec.err != nil || !codec.csv.Next() {
+		return false
+	}
+
+	row := codec.csv.Value()["csv"].(common.MapStr)
+	line := codec.csv.Value()["line"]
+
+	timestamp := codec.object["timestamp"]
+	if micros, ok := row["time_micros"].(int64); ok {
+		timestamp = time.Unix(micros/1e6, micros%1e6*1e3).UTC()
+	}
+
+	if ip, ok := row["c_ip"].(string); ok && ip != "" {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			codec.err = fmt.Errorf("row %v: column \"c_ip\": %q isn't an IP address", line, ip)
+			codec.bad = codec.csv.raw()
+			return false
+		}
+
+		row["c_ip"] = parsed.String()
+	}
+
+	codec.value = common.MapStr{
+		"gcs_log":    codec.object.Clone(),
+		"@timestamp": timestamp,
+		"file":       codec.path,
+		"line":       line,
+	}
+
+	// gcs_usage or gcs_storage
+	codec.value["gcs_"+codec.object["type"].(string)] = row
+
+	return true
+}
+
+// parseGcsLogName reads the bucket, log type, hour and version from the name
+// of a log object. It returns nil for other names.
+func parseGcsLogName(objectPath string) common.MapStr {
+	match := gcsLogName.FindStringSubmatch(path.Base(objectPath))
+	if match == nil {
+		return nil
+	}
+
+	timestamp, err := time.Parse("2006_01_02_15_04_05", match[3])
+	if err != nil {
+		return nil
+	}
+
+	version, err := strconv.Atoi(match[5])
+	if err != nil {
+		return nil
+	}
+
+	return common.MapStr{
+		"bucket":    match[1],
+		"type":      match[2],
+		"timestamp": timestamp,
+		"id":        match[4],
+		"version":   version,
+	}
+}
+
+func (codec *GcsAccessLogCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *GcsAccessLogCodec) Err() error {
+	if codec.err != nil {
+		return codec.err
+	}
+
+	return codec.csv.Err()
+}
+
+// Recover skips the row that couldn't be parsed, the file can't be read at
+// all if its name isn't a log's.
+func (codec *GcsAccessLogCodec) Recover() (common.MapStr, error) {
+	if codec.bad != nil {
+		event := newErrorEvent(codec.path, codec.csv.lineNumber, codec.bad, codec.err)
+		codec.err, codec.bad = nil, nil
+		return event, nil
+	}
+
+	if codec.err != nil {
+		return nil, errNotRecoverable
+	}
+
+	return codec.csv.Recover()
+}
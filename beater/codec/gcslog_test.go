@@ -0,0 +1,202 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	testGcsUsageLog = `"time_micros","c_ip","c_ip_type","c_ip_region","cs_method","cs_uri","sc_status","cs_bytes","sc_bytes","time_taken_micros","cs_host","cs_referer","cs_user_agent","s_request_id","cs_operation","cs_bucket","cs_object"
+"1525176184123456","2001:db8::0001","2","","GET","/storage/v1/b/my-bucket/o/a.txt","200","0","512","41000","www.googleapis.com","","curl/7.58.0","AEnB2Uo","GET_Object","my-bucket","a.txt"
+`
+	testGcsStorageLog = `"bucket","storage_byte_hours"
+"my-bucket","4320000"
+`
+)
+
+func TestGcsAccessLogCodecValue(t *testing.T) {
+	cases := map[string]struct {
+		Path     string
+		Data     string
+		Expected common.MapStr
+	}{
+		"usage": {
+			Path: "logs/my-bucket_usage_2018_05_01_12_00_00_00a4f3d2_v0",
+			Data: testGcsUsageLog,
+			Expected: common.MapStr{
+				"@timestamp": time.Date(2018, 5, 1, 12, 3, 4, 123456000, time.UTC),
+				"gcs_usage": common.MapStr{
+					"time_micros":       int64(1525176184123456),
+					"c_ip":              "2001:db8::1",
+					"c_ip_type":         int64(2),
+					"c_ip_region":       "",
+					"cs_method":         "GET",
+					"cs_uri":            "/storage/v1/b/my-bucket/o/a.txt",
+					"sc_status":         int64(200),
+					"cs_bytes":          int64(0),
+					"sc_bytes":          int64(512),
+					"time_taken_micros": int64(41000),
+					"cs_host":           "www.googleapis.com",
+					"cs_referer":        "",
+					"cs_user_agent":     "curl/7.58.0",
+					"s_request_id":      "AEnB2Uo",
+					"cs_operation":      "GET_Object",
+					"cs_bucket":         "my-bucket",
+					"cs_object":         "a.txt",
+				},
+				"gcs_log": common.MapStr{
+					"bucket":    "my-bucket",
+					"type":      "usage",
+					"timestamp": time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC),
+					"id":        "00a4f3d2",
+					"version":   0,
+				},
+			},
+		},
+		"storage": {
+			Path: "my_bucket_storage_2018_05_01_07_00_00_1234abcd_v0",
+			Data: testGcsStorageLog,
+			Expected: common.MapStr{
+				"@timestamp": time.Date(2018, 5, 1, 7, 0, 0, 0, time.UTC),
+				"gcs_storage": common.MapStr{
+					"bucket":             "my-bucket",
+					"storage_byte_hours": int64(4320000),
+				},
+				"gcs_log": common.MapStr{
+					"bucket":    "my_bucket",
+					"type":      "storage",
+					"timestamp": time.Date(2018, 5, 1, 7, 0, 0, 0, time.UTC),
+					"id":        "1234abcd",
+					"version":   0,
+				},
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewGcsAccessLogCodec(tc.Path, strings.NewReader(tc.Data))
+		if !c.Next() {
+			t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+		}
+
+		tc.Expected["file"] = tc.Path
+		tc.Expected["line"] = 1
+
+		expected := fmt.Sprintf("%v", tc.Expected)
+		actual := fmt.Sprintf("%v", c.Value())
+		if expected != actual {
+			t.Errorf("%q | Expected %v, got %v", tn, expected, actual)
+		}
+
+		if c.Next() || c.Err() != nil {
+			t.Errorf("%q | Expected the end of the file, got %v", tn, c.Err())
+		}
+	}
+}
+
+func TestGcsAccessLogCodecErr(t *testing.T) {
+	cases := map[string]struct {
+		Path string
+		Data string
+	}{
+		"unknown name":   {Path: "my-bucket_access_2018_05_01_12_00_00_00a4f3d2_v0", Data: testGcsStorageLog},
+		"bad date":       {Path: "my-bucket_usage_2018_13_01_12_00_00_00a4f3d2_v0", Data: testGcsUsageLog},
+		"bad number":     {Path: "b_storage_2018_05_01_07_00_00_1234abcd_v0", Data: "\"bucket\",\"storage_byte_hours\"\n\"b\",\"many\"\n"},
+		"bad ip address": {Path: "b_usage_2018_05_01_07_00_00_1234abcd_v0", Data: "\"time_micros\",\"c_ip\"\n\"1\",\"nope\"\n"},
+	}
+
+	for tn, tc := range cases {
+		c := NewGcsAccessLogCodec(tc.Path, strings.NewReader(tc.Data))
+		if c.Next() {
+			t.Errorf("%q | Expected no events, got %v", tn, c.Value())
+		}
+
+		if c.Err() == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestGcsAccessLogCodecRecover(t *testing.T) {
+	cases := map[string]struct {
+		Path string
+		Data string
+		// Expected holds the line of each event, negative for error events
+		Expected []int
+		Raw      []string
+	}{
+		"bad ip address": {
+			Path:     "b_usage_2018_05_01_07_00_00_1234abcd_v0",
+			Data:     "\"time_micros\",\"c_ip\"\n\"1\",\"nope\"\n\"2\",\"10.0.0.1\"\n",
+			Expected: []int{-1, 2},
+			Raw:      []string{"1,nope"},
+		},
+		"bad number": {
+			Path:     "b_storage_2018_05_01_07_00_00_1234abcd_v0",
+			Data:     "\"bucket\",\"storage_byte_hours\"\n\"b\",\"many\"\n\"b\",\"5\"\n",
+			Expected: []int{-1, 2},
+			Raw:      []string{"b,many"},
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewGcsAccessLogCodec(tc.Path, strings.NewReader(tc.Data))
+
+		var lines []int
+		var raws []string
+		for {
+			for c.Next() {
+				lines = append(lines, c.Value()["line"].(int))
+			}
+
+			if c.Err() == nil {
+				break
+			}
+
+			event, err := c.(RecoverableCodec).Recover()
+			if err != nil {
+				t.Fatalf("%q | Unexpected error recovering from %v: %v", tn, c.Err(), err)
+			}
+
+			lines = append(lines, -event["line"].(int))
+			raws = append(raws, event["error"].(common.MapStr)["raw"].(string))
+		}
+
+		if fmt.Sprintf("%v", lines) != fmt.Sprintf("%v", tc.Expected) {
+			t.Errorf("%q | Expected lines %v, got %v", tn, tc.Expected, lines)
+		}
+
+		if fmt.Sprintf("%q", raws) != fmt.Sprintf("%q", tc.Raw) {
+			t.Errorf("%q | Expected raw data %q, got %q", tn, tc.Raw, raws)
+		}
+	}
+}
+
+func TestGcsAccessLogCodecUnknownName(t *testing.T) {
+	c := NewGcsAccessLogCodec("my-bucket_access_2018_05_01_12_00_00_00a4f3d2_v0", strings.NewReader(testGcsStorageLog))
+	if c.Next() {
+		t.Fatalf("Expected no events, got %v", c.Value())
+	}
+
+	if _, err := c.(RecoverableCodec).Recover(); err != errNotRecoverable {
+		t.Errorf("Expected the file not to be recoverable, got %v", err)
+	}
+}
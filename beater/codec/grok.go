@@ -0,0 +1,355 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	// maxGrokDepth limits how deeply patterns can reference each other so
+	// recursive definitions fail rather than loop forever.
+	maxGrokDepth = 32
+)
+
+var (
+	// matches %{NAME}, %{NAME:field} and %{NAME:field:type}
+	grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(int|float))?\}`)
+)
+
+// GrokConfig holds the options for the grok codec.
+type GrokConfig struct {
+	// Patterns are tried in order against each line, the first match wins.
+	Patterns []string `config:"patterns"`
+
+	// PatternDefinitions adds to or overrides the built-in pattern library.
+	PatternDefinitions map[string]string `config:"pattern_definitions"`
+
+	// UnmatchedTag is added to the tags of lines that match no pattern.
+	UnmatchedTag string `config:"unmatched_tag"`
+
+	// DropUnmatched skips lines that match no pattern rather than sending them.
+	DropUnmatched bool `config:"drop_unmatched"`
+
+	// UnmatchedIndex and UnmatchedPipeline send lines that match no pattern
+	// to a different index or ingest pipeline than the rest of the file.
+	UnmatchedIndex    string `config:"unmatched_index"`
+	UnmatchedPipeline string `config:"unmatched_pipeline"`
+}
+
+var defaultGrokConfig = GrokConfig{
+	UnmatchedTag: "_grokparsefailure",
+}
+
+func (c *GrokConfig) Validate() error {
+	if c.DropUnmatched && (c.UnmatchedIndex != "" || c.UnmatchedPipeline != "") {
+		return errors.New("grok drop_unmatched can't be used with unmatched_index or unmatched_pipeline")
+	}
+
+	_, err := c.compile()
+	return err
+}
+
+func (c *GrokConfig) compile() ([]*grokPattern, error) {
+	if len(c.Patterns) == 0 {
+		return nil, errors.New("grok codec needs at least one pattern")
+	}
+
+	var out []*grokPattern
+	for _, pattern := range c.Patterns {
+		compiled, err := compileGrokPattern(pattern, c.PatternDefinitions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grok pattern %q: %v", pattern, err)
+		}
+
+		out = append(out, compiled)
+	}
+
+	return out, nil
+}
+
+func newGrokConfig(options *common.Config) (*GrokConfig, error) {
+	config, err := unpackCodecConfig("grok", defaultGrokConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*GrokConfig), nil
+}
+
+func init() {
+	Register(GrokCodecId, NewGrokCodec, defaultGrokConfig)
+}
+
+func NewGrokCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newGrokConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	patterns, err := config.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &GrokCodec{
+		scanner:  bufio.NewScanner(input),
+		config:   config,
+		patterns: patterns,
+		path:     path,
+	}, nil
+}
+
+// GrokCodec reads a file line by line and parses each line with the first
+// matching grok pattern. Captured values are sent under the "grok" field
+// along with the raw line.
+type GrokCodec struct {
+	scanner    *bufio.Scanner
+	config     *GrokConfig
+	patterns   []*grokPattern
+	value      common.MapStr
+	lineNumber int
+	path       string
+}
+
+func (codec *GrokCodec) Next() bool {
+	for codec.scanner.Scan() {
+		codec.lineNumber++
+		line := codec.scanner.Text()
+
+		codec.value = common.MapStr{
+			"event": line,
+			"file":  codec.path,
+			"line":  codec.lineNumber,
+		}
+
+		if fields, ok := codec.match(line); ok {
+			codec.value["grok"] = fields
+			return true
+		}
+
+		if codec.config.DropUnmatched {
+			continue
+		}
+
+		if codec.config.UnmatchedTag != "" {
+			codec.value["tags"] = []string{codec.config.UnmatchedTag}
+		}
+
+		if meta := codec.unmatchedMetadata(); meta != nil {
+			codec.value["@metadata"] = meta
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// unmatchedMetadata is where lines that match no pattern are sent, nil if
+// they go wherever the rest of the file does.
+func (codec *GrokCodec) unmatchedMetadata() common.MapStr {
+	meta := common.MapStr{}
+	if codec.config.UnmatchedIndex != "" {
+		meta["index"] = codec.config.UnmatchedIndex
+	}
+
+	if codec.config.UnmatchedPipeline != "" {
+		meta["pipeline"] = codec.config.UnmatchedPipeline
+	}
+
+	if len(meta) == 0 {
+		return nil
+	}
+
+	return meta
+}
+
+func (codec *GrokCodec) match(line string) (common.MapStr, bool) {
+	for _, pattern := range codec.patterns {
+		if fields, ok := pattern.match(line); ok {
+			return fields, true
+		}
+	}
+
+	return nil, false
+}
+
+func (codec *GrokCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *GrokCodec) Err() error {
+	return codec.scanner.Err()
+}
+
+// grokCapture is the destination of a capture group in a compiled pattern.
+type grokCapture struct {
+	field string
+	typ   string
+}
+
+type grokPattern struct {
+	regexp *regexp.Regexp
+
+	// captures is indexed by sub-expression number
+	captures []grokCapture
+}
+
+func compileGrokPattern(pattern string, definitions map[string]string) (*grokPattern, error) {
+	compiler := &grokCompiler{
+		definitions: definitions,
+		captures:    make(map[string]grokCapture),
+	}
+
+	expanded, err := compiler.expand(pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+
+	captures := make([]grokCapture, len(re.SubexpNames()))
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+
+		capture, ok := compiler.captures[name]
+		if !ok {
+			// a regular named group e.g. (?P<field>...)
+			capture = grokCapture{field: name}
+		}
+
+		captures[i] = capture
+	}
+
+	return &grokPattern{
+		regexp:   re,
+		captures: captures,
+	}, nil
+}
+
+func (p *grokPattern) match(line string) (common.MapStr, bool) {
+	indexes := p.regexp.FindStringSubmatchIndex(line)
+	if indexes == nil {
+		return nil, false
+	}
+
+	fields := common.MapStr{}
+	for i, capture := range p.captures {
+		start, end := indexes[2*i], indexes[2*i+1]
+		if capture.field == "" || start < 0 {
+			continue
+		}
+
+		// outer groups come first and win over nested ones of the same name
+		if exists, _ := fields.HasKey(capture.field); exists {
+			continue
+		}
+
+		fields.Put(capture.field, convertGrokValue(capture.typ, line[start:end]))
+	}
+
+	return fields, true
+}
+
+func convertGrokValue(typ, text string) interface{} {
+	switch typ {
+	case "int":
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	}
+
+	// values that fail conversion are kept as they were written
+	return text
+}
+
+// grokCompiler expands %{...} references into a plain regular expression,
+// replacing named references with uniquely named groups.
+type grokCompiler struct {
+	definitions map[string]string
+	captures    map[string]grokCapture
+	groups      int
+}
+
+func (gc *grokCompiler) lookup(name string) (string, bool) {
+	if definition, ok := gc.definitions[name]; ok {
+		return definition, true
+	}
+
+	definition, ok := grokLibrary[name]
+	return definition, ok
+}
+
+func (gc *grokCompiler) expand(pattern string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", fmt.Errorf("patterns are nested more than %d deep, check for recursive definitions", maxGrokDepth)
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, m := range grokReference.FindAllStringSubmatchIndex(pattern, -1) {
+		out.WriteString(pattern[last:m[0]])
+		last = m[1]
+
+		name := pattern[m[2]:m[3]]
+		definition, ok := gc.lookup(name)
+		if !ok {
+			return "", fmt.Errorf("unknown pattern %%{%s}", name)
+		}
+
+		expanded, err := gc.expand(definition, depth+1)
+		if err != nil {
+			return "", err
+		}
+
+		// references without a field name don't capture anything
+		if m[4] < 0 {
+			fmt.Fprintf(&out, "(?:%s)", expanded)
+			continue
+		}
+
+		capture := grokCapture{field: pattern[m[4]:m[5]]}
+		if m[6] >= 0 {
+			capture.typ = pattern[m[6]:m[7]]
+		}
+
+		group := fmt.Sprintf("grok%d", gc.groups)
+		gc.groups++
+		gc.captures[group] = capture
+
+		fmt.Fprintf(&out, "(?P<%s>%s)", group, expanded)
+	}
+
+	out.WriteString(pattern[last:])
+	return out.String(), nil
+}
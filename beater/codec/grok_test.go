@@ -0,0 +1,218 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestGrokCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"no patterns":     {},
+		"unknown pattern": {"patterns": []string{"%{NOPE:x}"}},
+		"bad regexp":      {"patterns": []string{"(unclosed"}},
+		"recursive": {
+			"patterns":            []string{"%{A}"},
+			"pattern_definitions": map[string]interface{}{"A": "a%{B}", "B": "b%{A}"},
+		},
+		"drop and route unmatched": {
+			"patterns":        []string{"%{INT:n}"},
+			"drop_unmatched":  true,
+			"unmatched_index": "failures",
+		},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewGrokCodec(testOptions(t, tc), "testfile", strings.NewReader(""))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestGrokLibraryCompiles(t *testing.T) {
+	for name := range grokLibrary {
+		if _, err := compileGrokPattern("%{"+name+":value}", nil); err != nil {
+			t.Errorf("%q | Failed to compile: %v", name, err)
+		}
+	}
+}
+
+func TestGrokCodecValue(t *testing.T) {
+	cases := map[string]struct {
+		Data     string
+		Options  map[string]interface{}
+		Expected []common.MapStr
+	}{
+		"level and message": {
+			Data: "2018-06-01T12:30:00Z WARN disk almost full\n",
+			Options: map[string]interface{}{
+				"patterns": []string{`^%{TIMESTAMP_ISO8601:ts} %{LOGLEVEL:level} %{GREEDYDATA:message}$`},
+			},
+			Expected: []common.MapStr{
+				{
+					"event": "2018-06-01T12:30:00Z WARN disk almost full",
+					"grok": common.MapStr{
+						"ts":      "2018-06-01T12:30:00Z",
+						"level":   "WARN",
+						"message": "disk almost full",
+					},
+				},
+			},
+		},
+		"first match wins and types": {
+			Data: "10.0.0.1 took 15ms\nfe80::1 took 1.5ms\n",
+			Options: map[string]interface{}{
+				"patterns": []string{
+					`^%{IPV4:client.ip} took %{INT:took:int}ms$`,
+					`^%{IP:client.ip} took %{NUMBER:took:float}ms$`,
+				},
+			},
+			Expected: []common.MapStr{
+				{
+					"event": "10.0.0.1 took 15ms",
+					"grok":  common.MapStr{"client": common.MapStr{"ip": "10.0.0.1"}, "took": int64(15)},
+				},
+				{
+					"event": "fe80::1 took 1.5ms",
+					"grok":  common.MapStr{"client": common.MapStr{"ip": "fe80::1"}, "took": 1.5},
+				},
+			},
+		},
+		"custom definitions and named groups": {
+			Data: "user=alice id=42",
+			Options: map[string]interface{}{
+				"patterns":            []string{`user=%{NAME:user} id=(?P<id>\d+)`},
+				"pattern_definitions": map[string]interface{}{"NAME": `[a-z]+`},
+			},
+			Expected: []common.MapStr{
+				{
+					"event": "user=alice id=42",
+					"grok":  common.MapStr{"user": "alice", "id": "42"},
+				},
+			},
+		},
+		"unmatched lines are tagged": {
+			Data: "nope",
+			Options: map[string]interface{}{
+				"patterns": []string{`^%{INT:n}$`},
+			},
+			Expected: []common.MapStr{
+				{"event": "nope", "tags": []string{"_grokparsefailure"}},
+			},
+		},
+		"unmatched lines are dropped": {
+			Data: "nope\n5\nnope",
+			Options: map[string]interface{}{
+				"patterns":       []string{`^%{INT:n:int}$`},
+				"drop_unmatched": true,
+			},
+			Expected: []common.MapStr{
+				{"event": "5", "grok": common.MapStr{"n": int64(5)}},
+			},
+		},
+		"unmatched lines are routed": {
+			Data: "nope\n5",
+			Options: map[string]interface{}{
+				"patterns":           []string{`^%{INT:n:int}$`},
+				"unmatched_tag":      "",
+				"unmatched_index":    "failures",
+				"unmatched_pipeline": "reparse",
+			},
+			Expected: []common.MapStr{
+				{"@metadata": common.MapStr{"index": "failures", "pipeline": "reparse"}, "event": "nope"},
+				{"event": "5", "grok": common.MapStr{"n": int64(5)}},
+			},
+		},
+		"apache combined": {
+			Data: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+			Options: map[string]interface{}{
+				"patterns": []string{`%{COMBINEDAPACHELOG}`},
+			},
+			Expected: []common.MapStr{
+				{
+					"event": `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`,
+					"grok": common.MapStr{
+						"clientip":    "127.0.0.1",
+						"ident":       "-",
+						"auth":        "frank",
+						"timestamp":   "10/Oct/2000:13:55:36 -0700",
+						"verb":        "GET",
+						"request":     "/apache_pb.gif",
+						"httpversion": "1.0",
+						"response":    int64(200),
+						"bytes":       int64(2326),
+						"referrer":    `"http://www.example.com/start.html"`,
+						"agent":       `"Mozilla/4.08"`,
+					},
+				},
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewGrokCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		for _, expected := range tc.Expected {
+			if !c.Next() {
+				t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+			}
+
+			actual := c.Value()
+			delete(actual, "file")
+			delete(actual, "line")
+
+			expectedS := fmt.Sprintf("%v", expected)
+			actualS := fmt.Sprintf("%v", actual)
+			if expectedS != actualS {
+				t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+			}
+		}
+
+		if c.Next() {
+			t.Errorf("%q | Expected end of input, got %v", tn, c.Value())
+		}
+	}
+}
+
+func TestGrokCodecLineNumbers(t *testing.T) {
+	options := testOptions(t, map[string]interface{}{
+		"patterns":       []string{`^%{INT:n}$`},
+		"drop_unmatched": true,
+	})
+
+	c, _ := NewGrokCodec(options, "testfile", strings.NewReader("a\n1\nb\n2"))
+
+	for _, expected := range []int{2, 4} {
+		if !c.Next() {
+			t.Fatal("Quit too early.")
+		}
+
+		if c.Value()["line"] != expected {
+			t.Errorf("Expected line %d, got %v", expected, c.Value()["line"])
+		}
+
+		if c.Value()["file"] != "testfile" {
+			t.Errorf("Expected file to be 'testfile', got %q", c.Value()["file"])
+		}
+	}
+}
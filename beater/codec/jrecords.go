@@ -0,0 +1,195 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// jsonRecords walks a document down to the array at the record path so the
+// codecs can decode its elements one at a time, capturing envelope fields on
+// the way.
+type jsonRecords struct {
+	decoder  *json.Decoder
+	path     []string
+	fields   []string
+	envelope common.MapStr
+}
+
+func newJsonRecords(decoder *json.Decoder, config *JsonConfig) *jsonRecords {
+	return &jsonRecords{
+		decoder: decoder,
+		path:    strings.Split(config.RecordPath, "."),
+		fields:  config.EnvelopeFields,
+	}
+}
+
+// open reads the next document up to the first element of the record array.
+// It returns io.EOF if there are no more documents.
+func (r *jsonRecords) open() error {
+	r.envelope = common.MapStr{}
+
+	prefix := ""
+	for _, key := range r.path {
+		if err := r.expectDelim('{', prefix); err != nil {
+			return err
+		}
+
+		found := false
+		for r.decoder.More() {
+			token, err := r.decoder.Token()
+			if err != nil {
+				return err
+			}
+
+			name, _ := token.(string)
+			if name == key {
+				found = true
+				break
+			}
+
+			if err := r.skip(joinJsonPath(prefix, name)); err != nil {
+				return err
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("record path %q not found", strings.Join(r.path, "."))
+		}
+
+		prefix = joinJsonPath(prefix, key)
+	}
+
+	return r.expectDelim('[', prefix)
+}
+
+// close reads the rest of the document after the record array.
+func (r *jsonRecords) close() error {
+	// the closing bracket of the array
+	if _, err := r.decoder.Token(); err != nil {
+		return err
+	}
+
+	for range r.path {
+		for r.decoder.More() {
+			if _, err := r.decoder.Token(); err != nil {
+				return err
+			}
+
+			var ignored json.RawMessage
+			if err := r.decoder.Decode(&ignored); err != nil {
+				return err
+			}
+		}
+
+		if _, err := r.decoder.Token(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *jsonRecords) expectDelim(delim json.Delim, path string) error {
+	token, err := r.decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		if path == "" {
+			path = "the document"
+		}
+
+		return fmt.Errorf("expected %q at %s, got %v", delim, path, token)
+	}
+
+	return nil
+}
+
+// skip reads the value at path, keeping it if it holds envelope fields.
+func (r *jsonRecords) skip(path string) error {
+	for _, field := range r.fields {
+		if field == path || strings.HasPrefix(field, path+".") {
+			var value interface{}
+			if err := r.decoder.Decode(&value); err != nil {
+				return err
+			}
+
+			r.envelope[path] = value
+			return nil
+		}
+	}
+
+	var ignored json.RawMessage
+	return r.decoder.Decode(&ignored)
+}
+
+// merge copies the envelope fields into a record, fields already in the
+// record are kept.
+func (r *jsonRecords) merge(record map[string]interface{}) {
+	if len(r.fields) == 0 {
+		return
+	}
+
+	envelope := common.MapStr{}
+	for path, value := range r.envelope {
+		envelope.Put(path, value)
+	}
+
+	for _, field := range r.fields {
+		value, err := envelope.GetValue(field)
+		if err != nil {
+			continue
+		}
+
+		if found, _ := common.MapStr(record).HasKey(field); !found {
+			putJsonPath(record, field, value)
+		}
+	}
+}
+
+// putJsonPath is like MapStr.Put but creates plain maps like the decoder so
+// the other JSON options apply to them.
+func putJsonPath(fields map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := fields[key].(map[string]interface{})
+		if !ok {
+			if _, exists := fields[key]; exists {
+				return
+			}
+
+			child = make(map[string]interface{})
+			fields[key] = child
+		}
+
+		fields = child
+	}
+
+	fields[keys[len(keys)-1]] = value
+}
+
+func joinJsonPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestJsonCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"empty key":              {"record_path": "data..items"},
+		"envelope without path":  {"envelope_fields": []string{"id"}},
+		"envelope is the path":   {"record_path": "data.items", "envelope_fields": []string{"data.items"}},
+		"envelope holds path":    {"record_path": "data.items", "envelope_fields": []string{"data"}},
+		"envelope inside record": {"record_path": "data.items", "envelope_fields": []string{"data.items.id"}},
+		"empty target":           {"target": ""},
+		"expand and flatten":     {"expand_keys": true, "flatten": true},
+		"negative depth":         {"max_depth": -1},
+	}
+
+	for tn, tc := range cases {
+		if _, err := NewJsonArrayCodec(testOptions(t, tc), "testfile", strings.NewReader("")); err == nil {
+			t.Errorf("%q | Expected an error from json-array", tn)
+		}
+
+		if _, err := NewJsonStreamCodec(testOptions(t, tc), "testfile", strings.NewReader("")); err == nil {
+			t.Errorf("%q | Expected an error from json-stream", tn)
+		}
+	}
+}
+
+func TestJsonRecordsNextErr(t *testing.T) {
+	type constructor func(*common.Config, string, io.Reader) (Codec, error)
+
+	cases := map[string]struct {
+		Json         string
+		ArrayLength  int
+		StreamLength int
+		ExpectErr    bool
+	}{
+		"empty records": {
+			Json:         `{"data":{"items":[]}}`,
+			ArrayLength:  0,
+			StreamLength: 0,
+		},
+		"records": {
+			Json:         `{"skip":[1,{"a":2}],"data":{"items":[{"a":1},{"a":2}],"after":true}}`,
+			ArrayLength:  2,
+			StreamLength: 2,
+		},
+		"concatenated documents": {
+			Json:         `{"data":{"items":[{"a":1}]}} {"data":{"items":[]}} {"data":{"items":[{"a":2}]}}`,
+			ArrayLength:  1,
+			StreamLength: 2,
+		},
+		"missing path": {
+			Json:         `{"data":{"other":[]}}`,
+			ArrayLength:  0,
+			StreamLength: 0,
+			ExpectErr:    true,
+		},
+		"not an array": {
+			Json:         `{"data":{"items":{}}}`,
+			ArrayLength:  0,
+			StreamLength: 0,
+			ExpectErr:    true,
+		},
+		"not an object": {
+			Json:         `[{"data":{"items":[]}}]`,
+			ArrayLength:  0,
+			StreamLength: 0,
+			ExpectErr:    true,
+		},
+		"truncated": {
+			Json:         `{"data":{"items":[{"a":1},{"a":`,
+			ArrayLength:  1,
+			StreamLength: 1,
+			ExpectErr:    true,
+		},
+	}
+
+	constructors := map[string]constructor{
+		"json-array":  NewJsonArrayCodec,
+		"json-stream": NewJsonStreamCodec,
+	}
+
+	for tn, tc := range cases {
+		for name, newCodec := range constructors {
+			options := testOptions(t, map[string]interface{}{"record_path": "data.items"})
+			c, err := newCodec(options, "file/path", strings.NewReader(tc.Json))
+			if err != nil {
+				t.Fatalf("%q | %s: Unexpected error %v", tn, name, err)
+			}
+
+			counter := 0
+			for c.Next() {
+				counter++
+			}
+
+			hasErr := c.Err() != nil
+			if hasErr != tc.ExpectErr {
+				t.Errorf("%q | %s: Got error %v, expected? %v", tn, name, c.Err(), tc.ExpectErr)
+			}
+
+			expected := tc.ArrayLength
+			if name == "json-stream" {
+				expected = tc.StreamLength
+			}
+
+			if counter != expected {
+				t.Errorf("%q | %s: Expected to decode %d records, got %d", tn, name, expected, counter)
+			}
+		}
+	}
+}
+
+func TestJsonRecordsValue(t *testing.T) {
+	const data = `{"requestId":"r1","meta":{"page":2,"cursor":"x"},"Records":[{"a":1},{"a":2,"requestId":"own"}]}
+{"requestId":"r2","Records":[{"a":3}],"meta":{"page":3}}`
+
+	options := testOptions(t, map[string]interface{}{
+		"record_path":     "Records",
+		"envelope_fields": []string{"requestId", "meta.page"},
+	})
+
+	c, err := NewJsonStreamCodec(options, "file/path", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fields after the records aren't seen when they're read
+	expected := []common.MapStr{
+		{"a": 1, "requestId": "r1", "meta": common.MapStr{"page": 2}},
+		{"a": 2, "requestId": "own", "meta": common.MapStr{"page": 2}},
+		{"a": 3, "requestId": "r2"},
+	}
+
+	for i, expectedFields := range expected {
+		if !c.Next() {
+			t.Fatalf("Quit too early: %v", c.Err())
+		}
+
+		expectedS := fmt.Sprintf("%v", common.MapStr{
+			"json": expectedFields,
+			"line": i + 1,
+			"path": "file/path",
+		})
+
+		actualS := fmt.Sprintf("%v", c.Value())
+		if expectedS != actualS {
+			t.Errorf("Expected %v, got %v", expectedS, actualS)
+		}
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
@@ -15,32 +15,324 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/elastic/beats/libbeat/common"
 )
 
 type JsonObject map[string]interface{}
 
-func NewJsonArrayCodec(path string, input io.Reader) Codec {
+// JsonConfig holds the options for the json-array and json-stream codecs.
+type JsonConfig struct {
+	// RecordPath is the dotted path of an array of records inside each
+	// document e.g. Records for CloudTrail or data.items. Documents are read
+	// as a whole when it's empty.
+	RecordPath string `config:"record_path"`
+
+	// EnvelopeFields are dotted paths of fields outside of the records that
+	// are copied into each record. Only fields before the array are seen.
+	EnvelopeFields []string `config:"envelope_fields"`
+
+	// UseNumber keeps the precision of numbers, integers are decoded as 64 bit
+	// integers rather than floats and ones too big for them become strings.
+	UseNumber bool `config:"use_number"`
+
+	// Target is the field the decoded document goes under.
+	Target string `config:"target"`
+
+	// KeysUnderRoot puts the decoded keys at the root of the event instead of
+	// under Target.
+	KeysUnderRoot bool `config:"keys_under_root"`
+
+	// OverwriteKeys lets keys under the root replace the fields the codec
+	// adds, like line.
+	OverwriteKeys bool `config:"overwrite_keys"`
+
+	// ExpandKeys turns keys with dots into nested objects e.g. {"a.b": 1}
+	// becomes {"a": {"b": 1}}.
+	ExpandKeys bool `config:"expand_keys"`
+
+	// MaxDepth is the deepest level of objects and arrays kept, deeper values
+	// are kept as JSON strings. The document is level 1, 0 means no limit.
+	MaxDepth int `config:"max_depth"`
+
+	// Flatten turns nested objects into dotted keys e.g. {"a": {"b": 1}}
+	// becomes {"a.b": 1}.
+	Flatten bool `config:"flatten"`
+}
+
+var defaultJsonConfig = JsonConfig{
+	RecordPath:     "",
+	EnvelopeFields: nil,
+	UseNumber:      false,
+	Target:         "json",
+	KeysUnderRoot:  false,
+	OverwriteKeys:  false,
+	ExpandKeys:     false,
+	MaxDepth:       0,
+	Flatten:        false,
+}
+
+func (c *JsonConfig) Validate() error {
+	if c.Target == "" && !c.KeysUnderRoot {
+		return errors.New("json target can't be empty, use keys_under_root to put keys at the root")
+	}
+
+	if c.ExpandKeys && c.Flatten {
+		return errors.New("json expand_keys and flatten can't both be set")
+	}
+
+	if c.MaxDepth < 0 {
+		return fmt.Errorf("json max_depth must be at least 0, got %d", c.MaxDepth)
+	}
+
+	if c.RecordPath == "" {
+		if len(c.EnvelopeFields) > 0 {
+			return errors.New("envelope_fields needs a record_path")
+		}
+
+		return nil
+	}
+
+	for _, key := range strings.Split(c.RecordPath, ".") {
+		if key == "" {
+			return fmt.Errorf("record_path %q has an empty key", c.RecordPath)
+		}
+	}
+
+	for _, field := range c.EnvelopeFields {
+		if field == "" || field == c.RecordPath ||
+			strings.HasPrefix(c.RecordPath, field+".") || strings.HasPrefix(field, c.RecordPath+".") {
+			return fmt.Errorf("envelope field %q must be outside of the record_path %q", field, c.RecordPath)
+		}
+	}
+
+	return nil
+}
+
+func newJsonConfig(options *common.Config) (*JsonConfig, error) {
+	config, err := unpackCodecConfig("json", defaultJsonConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*JsonConfig), nil
+}
+
+// newDecoder creates a decoder for input with the config's number handling.
+func (c *JsonConfig) newDecoder(input io.Reader) *json.Decoder {
+	decoder := json.NewDecoder(input)
+	if c.UseNumber {
+		decoder.UseNumber()
+	}
+
+	return decoder
+}
+
+// unmarshal decodes a single value with the config's number handling.
+func (c *JsonConfig) unmarshal(data []byte, value interface{}) error {
+	return c.newDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// event builds the event for a decoded document.
+func (c *JsonConfig) event(document map[string]interface{}, path string, lineNumber int) common.MapStr {
+	fields := c.normalize(document, 1).(map[string]interface{})
+
+	if c.ExpandKeys {
+		fields = expandJsonKeys(fields)
+	}
+
+	if c.Flatten {
+		flat := make(map[string]interface{})
+		flattenJson(flat, "", fields)
+		fields = flat
+	}
+
+	event := common.MapStr{
+		"line": lineNumber,
+		"path": path,
+	}
+
+	if !c.KeysUnderRoot {
+		event[c.Target] = fields
+		return event
+	}
+
+	for key, value := range fields {
+		if _, exists := event[key]; exists && !c.OverwriteKeys {
+			continue
+		}
+
+		event[key] = value
+	}
+
+	return event
+}
+
+// normalize converts json.Numbers and replaces values deeper than MaxDepth
+// with their JSON.
+func (c *JsonConfig) normalize(value interface{}, depth int) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return convertJsonNumber(v)
+
+	case map[string]interface{}:
+		if c.MaxDepth > 0 && depth > c.MaxDepth {
+			return encodeJson(v)
+		}
+
+		for key, item := range v {
+			v[key] = c.normalize(item, depth+1)
+		}
+
+	case []interface{}:
+		if c.MaxDepth > 0 && depth > c.MaxDepth {
+			return encodeJson(v)
+		}
+
+		for i, item := range v {
+			v[i] = c.normalize(item, depth+1)
+		}
+	}
+
+	return value
+}
+
+func convertJsonNumber(number json.Number) interface{} {
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+
+	if u, err := strconv.ParseUint(number.String(), 10, 64); err == nil {
+		return u
+	}
+
+	// integers too big for 64 bits would lose precision as floats
+	if !strings.ContainsAny(number.String(), ".eE") {
+		return number.String()
+	}
+
+	f, _ := number.Float64()
+	return f
+}
+
+func encodeJson(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(data)
+}
+
+// expandJsonKeys turns dotted keys into nested objects, keys that conflict
+// with a non-object are kept as they are.
+func expandJsonKeys(fields map[string]interface{}) map[string]interface{} {
+	expanded := make(map[string]interface{})
+
+	// plain keys first so dotted keys merge into them
+	for key, value := range fields {
+		if !strings.Contains(key, ".") {
+			expanded[key] = expandJsonValue(value)
+		}
+	}
+
+	for key, value := range fields {
+		if !strings.Contains(key, ".") {
+			continue
+		}
+
+		parts := strings.Split(key, ".")
+		parent := expanded
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := parent[part].(map[string]interface{})
+			if !ok {
+				if _, exists := parent[part]; exists {
+					parent = nil
+					break
+				}
+
+				child = make(map[string]interface{})
+				parent[part] = child
+			}
+
+			parent = child
+		}
+
+		if parent == nil {
+			expanded[key] = expandJsonValue(value)
+			continue
+		}
+
+		parent[parts[len(parts)-1]] = expandJsonValue(value)
+	}
+
+	return expanded
+}
+
+func expandJsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return expandJsonKeys(v)
+
+	case []interface{}:
+		for i, item := range v {
+			v[i] = expandJsonValue(item)
+		}
+	}
+
+	return value
+}
+
+// flattenJson adds the leaves of the nested objects in fields to flat with
+// dotted keys, arrays are kept as they are.
+func flattenJson(flat map[string]interface{}, prefix string, fields map[string]interface{}) {
+	for key, value := range fields {
+		if object, ok := value.(map[string]interface{}); ok && len(object) > 0 {
+			flattenJson(flat, joinJsonPath(prefix, key), object)
+			continue
+		}
+
+		flat[joinJsonPath(prefix, key)] = value
+	}
+}
+
+func init() {
+	Register(JsonArrayCodecId, NewJsonArrayCodec, defaultJsonConfig)
+}
+
+func NewJsonArrayCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newJsonConfig(options)
+	if err != nil {
+		return nil, err
+	}
 
 	codec := &JsonArrayCodec{
-		decoder:    json.NewDecoder(input),
+		decoder:    config.newDecoder(input),
 		value:      common.MapStr{},
 		err:        nil,
 		lineNumber: 0,
 		path:       path,
+		config:     config,
+	}
+
+	if config.RecordPath != "" {
+		codec.records = newJsonRecords(codec.decoder, config)
 	}
 
 	codec.init()
 
-	return codec
+	return codec, nil
 }
 
-// JsonArrayCodec iterates over a serialized JSON array of objects
+// JsonArrayCodec iterates over a serialized JSON array of objects, either the
+// whole document or an array inside of it at the record path.
 //
 // Inspiration for this decoding technique taken from go's JSON documentation
 // https://golang.org/pkg/encoding/json/#Decoder.Decode
@@ -50,9 +342,16 @@ type JsonArrayCodec struct {
 	err        error
 	lineNumber int
 	path       string
+	config     *JsonConfig
+	records    *jsonRecords
 }
 
 func (codec *JsonArrayCodec) init() {
+	if codec.records != nil {
+		codec.err = codec.records.open()
+		return
+	}
+
 	// Pull off the leading bracket before decoding objects
 	token, err := codec.decoder.Token()
 	codec.err = err
@@ -70,13 +369,13 @@ func (codec *JsonArrayCodec) Next() bool {
 	jsonData := make(map[string]interface{})
 	codec.err = codec.decoder.Decode(&jsonData)
 
-	codec.lineNumber++
-	codec.value = common.MapStr{
-		"json": jsonData,
-		"line": codec.lineNumber,
-		"path": codec.path,
+	if codec.records != nil {
+		codec.records.merge(jsonData)
 	}
 
+	codec.lineNumber++
+	codec.value = codec.config.event(jsonData, codec.path, codec.lineNumber)
+
 	return codec.err == nil
 }
 
@@ -34,7 +34,7 @@ func TestNewJsonArrayCodecInitValid(t *testing.T) {
 
 	for _, jsonStream := range valids {
 		reader := strings.NewReader(jsonStream)
-		codec := NewJsonArrayCodec("file/path", reader)
+		codec, _ := NewJsonArrayCodec(nil, "file/path", reader)
 
 		if codec.Err() != nil {
 			t.Errorf("Expected initialiation to work for %q", jsonStream)
@@ -55,7 +55,7 @@ func TestNewJsonArrayCodecInitInvalid(t *testing.T) {
 
 	for _, jsonStream := range valids {
 		reader := strings.NewReader(jsonStream)
-		codec := NewJsonArrayCodec("file/path", reader)
+		codec, _ := NewJsonArrayCodec(nil, "file/path", reader)
 
 		if codec.Err() == nil {
 			t.Errorf("Expected initialiation to fail for %q", jsonStream)
@@ -98,7 +98,7 @@ func TestJsonArrayNextErr(t *testing.T) {
 
 	for tn, tc := range cases {
 		reader := strings.NewReader(tc.Json)
-		c := NewJsonArrayCodec("file/path", reader)
+		c, _ := NewJsonArrayCodec(nil, "file/path", reader)
 
 		if c.Err() != nil {
 			t.Errorf("%q | Not expected to start with an error, got %q", tn, c.Err())
@@ -126,7 +126,7 @@ func TestJsonArrayValue(t *testing.T) {
 	const data = `[{"num":3.3, "str":"33", "bool": true}]`
 
 	reader := strings.NewReader(data)
-	c := NewJsonArrayCodec("file/path", reader)
+	c, _ := NewJsonArrayCodec(nil, "file/path", reader)
 
 	c.Next()
 	v := c.Value()
@@ -150,3 +150,91 @@ func TestJsonArrayValue(t *testing.T) {
 		t.Errorf("Expected %v, got %v", expected, v)
 	}
 }
+
+func TestJsonCodecOptionsValue(t *testing.T) {
+	const data = `{"id":9007199254740993,"big":123456789012345678901234567890,"f":1.5,"line":"x","a.b":1,"a":{"c":{"d":[1,{"e":2}]}}}`
+
+	cases := map[string]struct {
+		Options  map[string]interface{}
+		Expected common.MapStr
+	}{
+		"defaults": {
+			Options: nil,
+			Expected: common.MapStr{
+				"json": common.MapStr{"id": 9007199254740992.0, "big": 1.2345678901234568e+29, "f": 1.5, "line": "x", "a.b": 1.0,
+					"a": common.MapStr{"c": common.MapStr{"d": []interface{}{1.0, common.MapStr{"e": 2.0}}}}},
+				"line": 1,
+				"path": "file/path",
+			},
+		},
+		"use number and target": {
+			Options: map[string]interface{}{"use_number": true, "target": "doc", "max_depth": 2},
+			Expected: common.MapStr{
+				"doc": common.MapStr{"id": int64(9007199254740993), "big": "123456789012345678901234567890", "f": 1.5, "line": "x", "a.b": int64(1),
+					"a": common.MapStr{"c": `{"d":[1,{"e":2}]}`}},
+				"line": 1,
+				"path": "file/path",
+			},
+		},
+		"keys under root": {
+			Options: map[string]interface{}{"use_number": true, "keys_under_root": true, "max_depth": 1},
+			Expected: common.MapStr{
+				"id": int64(9007199254740993), "big": "123456789012345678901234567890", "f": 1.5, "a.b": int64(1),
+				"a":    `{"c":{"d":[1,{"e":2}]}}`,
+				"line": 1,
+				"path": "file/path",
+			},
+		},
+		"overwrite keys": {
+			Options: map[string]interface{}{"keys_under_root": true, "overwrite_keys": true, "max_depth": 1},
+			Expected: common.MapStr{
+				"id": 9007199254740992.0, "big": 1.2345678901234568e+29, "f": 1.5, "a.b": 1.0,
+				"a":    `{"c":{"d":[1,{"e":2}]}}`,
+				"line": "x",
+				"path": "file/path",
+			},
+		},
+		"expand keys": {
+			Options: map[string]interface{}{"use_number": true, "expand_keys": true},
+			Expected: common.MapStr{
+				"json": common.MapStr{"id": int64(9007199254740993), "big": "123456789012345678901234567890", "f": 1.5, "line": "x",
+					"a": common.MapStr{"b": int64(1), "c": common.MapStr{"d": []interface{}{int64(1), common.MapStr{"e": int64(2)}}}}},
+				"line": 1,
+				"path": "file/path",
+			},
+		},
+		"flatten": {
+			Options: map[string]interface{}{"use_number": true, "flatten": true},
+			Expected: common.MapStr{
+				"json": common.MapStr{"id": int64(9007199254740993), "big": "123456789012345678901234567890", "f": 1.5, "line": "x",
+					"a.b": int64(1), "a.c.d": []interface{}{int64(1), common.MapStr{"e": int64(2)}}},
+				"line": 1,
+				"path": "file/path",
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		array, err := NewJsonArrayCodec(testOptions(t, tc.Options), "file/path", strings.NewReader("["+data+"]"))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		stream, err := NewJsonStreamCodec(testOptions(t, tc.Options), "file/path", strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		for name, c := range map[string]Codec{"json-array": array, "json-stream": stream} {
+			if !c.Next() {
+				t.Fatalf("%q | %s: Quit too early: %v", tn, name, c.Err())
+			}
+
+			expectedS := fmt.Sprintf("%v", tc.Expected)
+			actualS := fmt.Sprintf("%v", c.Value())
+			if expectedS != actualS {
+				t.Errorf("%q | %s: Expected %v, got %v", tn, name, expectedS, actualS)
+			}
+		}
+	}
+}
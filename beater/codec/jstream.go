@@ -15,30 +15,54 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/json"
-
+	"fmt"
 	"io"
+	"io/ioutil"
 
 	"github.com/elastic/beats/libbeat/common"
 )
 
-func NewJsonStreamCodec(path string, input io.Reader) Codec {
+func init() {
+	Register(JsonStreamcodecId, NewJsonStreamCodec, defaultJsonConfig)
+}
+
+func NewJsonStreamCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newJsonConfig(options)
+	if err != nil {
+		return nil, err
+	}
 
 	codec := &JsonStreamCodec{
-		decoder:    json.NewDecoder(input),
+		input:      &jsonStreamInput{input: input},
 		lineNumber: 0,
 		path:       path,
+		config:     config,
 	}
 
-	return codec
+	codec.decoder = config.newDecoder(codec.input)
+
+	if config.RecordPath != "" {
+		codec.records = newJsonRecords(codec.decoder, config)
+	}
+
+	return codec, nil
 }
 
+// JsonStreamCodec reads concatenated JSON objects, or the records in each of
+// them if there's a record path.
 type JsonStreamCodec struct {
+	input      *jsonStreamInput
 	decoder    *json.Decoder
 	value      common.MapStr
 	err        error
+	bad        json.RawMessage
 	lineNumber int
 	path       string
+	config     *JsonConfig
+	records    *jsonRecords
+	inRecords  bool
 }
 
 func (codec *JsonStreamCodec) Next() bool {
@@ -46,17 +70,164 @@ func (codec *JsonStreamCodec) Next() bool {
 		return false
 	}
 
+	if codec.records != nil && !codec.nextRecord() {
+		return false
+	}
+
+	// decoding the raw value first leaves the decoder ready for the next one
+	// if the value isn't an object
+	var raw json.RawMessage
+	if codec.err = codec.decoder.Decode(&raw); codec.err != nil {
+		return false
+	}
+
 	jsonData := make(map[string]interface{})
-	codec.err = codec.decoder.Decode(&jsonData)
+	if err := codec.config.unmarshal(raw, &jsonData); err != nil {
+		codec.err = fmt.Errorf("object %d: %v", codec.lineNumber+1, err)
+		codec.bad = raw
+		return false
+	}
+
+	if codec.records != nil {
+		codec.records.merge(jsonData)
+	}
+
+	codec.lineNumber++
+	codec.value = codec.config.event(jsonData, codec.path, codec.lineNumber)
+
+	return true
+}
+
+// Recover skips a value that isn't an object, or the rest of the line for
+// malformed JSON. Malformed JSON can't be skipped inside a record path.
+func (codec *JsonStreamCodec) Recover() (common.MapStr, error) {
+	raw := []byte(codec.bad)
+	if raw == nil {
+		_, syntax := codec.err.(*json.SyntaxError)
+		if codec.records != nil || !(syntax || codec.err == io.ErrUnexpectedEOF) {
+			return nil, errNotRecoverable
+		}
+
+		var err error
+		if raw, err = codec.input.skipLine(codec.decoder.Buffered()); err != nil {
+			return nil, err
+		}
+
+		codec.decoder = codec.config.newDecoder(codec.input)
+	}
 
 	codec.lineNumber++
-	codec.value = common.MapStr{
-		"json": jsonData,
-		"line": codec.lineNumber,
-		"path": codec.path,
+	event := newErrorEvent(codec.path, codec.lineNumber, raw, codec.err)
+	codec.err, codec.bad = nil, nil
+
+	return event, nil
+}
+
+// nextRecord moves through documents until there's a record to decode.
+func (codec *JsonStreamCodec) nextRecord() bool {
+	for !codec.inRecords || !codec.decoder.More() {
+		if codec.inRecords {
+			if codec.err = codec.records.close(); codec.err != nil {
+				return false
+			}
+		}
+
+		if codec.err = codec.records.open(); codec.err != nil {
+			return false
+		}
+
+		codec.inRecords = true
+	}
+
+	return true
+}
+
+// jsonStreamInput is the input of the decoder. It lets the data a failed
+// decoder had buffered be read again by a new one.
+type jsonStreamInput struct {
+	pending []byte
+	input   io.Reader
+}
+
+func (r *jsonStreamInput) Read(p []byte) (int, error) {
+	if len(r.pending) > 0 {
+		n := copy(p, r.pending)
+		r.pending = r.pending[n:]
+		return n, nil
+	}
+
+	return r.input.Read(p)
+}
+
+// skipLine drops the data up to and including the next newline, starting
+// with the data a failed decoder had buffered. It returns the dropped line.
+func (r *jsonStreamInput) skipLine(buffered io.Reader) ([]byte, error) {
+	unused, err := ioutil.ReadAll(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	r.pending = append(unused, r.pending...)
+
+	// the decoder stops before the whitespace between values
+	for {
+		r.pending = bytes.TrimLeft(r.pending, " \t\r\n")
+		if len(r.pending) > 0 {
+			break
+		}
+
+		if err := r.fill(); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+
+			return nil, err
+		}
+	}
+
+	var line []byte
+	for {
+		end := bytes.IndexByte(r.pending, '\n')
+		chunk := r.pending
+		if end >= 0 {
+			chunk = r.pending[:end]
+		}
+
+		// very long lines are skipped but not all of it is kept
+		if room := maxErrorRawLength - len(line); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+
+			line = append(line, chunk...)
+		}
+
+		if end >= 0 {
+			r.pending = r.pending[end+1:]
+			return line, nil
+		}
+
+		if err := r.fill(); err != nil {
+			if err == io.EOF {
+				return line, nil
+			}
+
+			return nil, err
+		}
+	}
+}
+
+// fill replaces the pending data with the next read from the input.
+func (r *jsonStreamInput) fill() error {
+	buf := make([]byte, 32*1024)
+	n, err := r.input.Read(buf)
+	r.pending = buf[:n]
+
+	if n > 0 && err == io.EOF {
+		return nil
 	}
 
-	return codec.err == nil
+	return err
 }
 
 func (codec *JsonStreamCodec) Value() common.MapStr {
@@ -60,7 +60,7 @@ func TestJsonStreamNextErr(t *testing.T) {
 
 	for tn, tc := range cases {
 		reader := strings.NewReader(tc.Json)
-		c := NewJsonStreamCodec("file/path", reader)
+		c, _ := NewJsonStreamCodec(nil, "file/path", reader)
 
 		if c.Err() != nil {
 			t.Errorf("%q | Not expected to start with an error, got %q", tn, c.Err())
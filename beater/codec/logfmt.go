@@ -0,0 +1,335 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	DuplicateKeysLast  = "last"
+	DuplicateKeysFirst = "first"
+	DuplicateKeysArray = "array"
+)
+
+// LogfmtConfig holds the options for the logfmt codec.
+type LogfmtConfig struct {
+	// FieldSeparator goes between key/value pairs. If it's whitespace any run
+	// of whitespace separates pairs.
+	FieldSeparator string `config:"field_separator"`
+
+	// PairSeparator goes between a key and its value.
+	PairSeparator string `config:"pair_separator"`
+
+	// Quotes are the characters that can surround values containing separators.
+	Quotes string `config:"quotes"`
+
+	// DuplicateKeys says which value to keep if a key appears more than once.
+	DuplicateKeys string `config:"duplicate_keys"`
+
+	// InferTypes converts numbers, booleans and durations from strings.
+	InferTypes bool `config:"infer_types"`
+}
+
+var defaultLogfmtConfig = LogfmtConfig{
+	FieldSeparator: " ",
+	PairSeparator:  "=",
+	Quotes:         `"`,
+	DuplicateKeys:  DuplicateKeysLast,
+	InferTypes:     false,
+}
+
+func (c *LogfmtConfig) Validate() error {
+	if c.FieldSeparator == "" || c.PairSeparator == "" {
+		return errors.New("logfmt separators must not be empty")
+	}
+
+	if strings.Contains(c.FieldSeparator, c.PairSeparator) || strings.Contains(c.PairSeparator, c.FieldSeparator) {
+		return fmt.Errorf("logfmt field separator %q and pair separator %q must not overlap", c.FieldSeparator, c.PairSeparator)
+	}
+
+	if strings.ContainsAny(c.Quotes, c.FieldSeparator+c.PairSeparator+`\`) {
+		return fmt.Errorf("logfmt quotes %q must not contain separators or backslashes", c.Quotes)
+	}
+
+	switch c.DuplicateKeys {
+	case DuplicateKeysLast, DuplicateKeysFirst, DuplicateKeysArray:
+	default:
+		return fmt.Errorf("logfmt duplicate_keys must be one of %q, %q or %q, got %q",
+			DuplicateKeysLast, DuplicateKeysFirst, DuplicateKeysArray, c.DuplicateKeys)
+	}
+
+	return nil
+}
+
+func newLogfmtConfig(options *common.Config) (*LogfmtConfig, error) {
+	config, err := unpackCodecConfig("logfmt", defaultLogfmtConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*LogfmtConfig), nil
+}
+
+func init() {
+	Register(LogfmtCodecId, NewLogfmtCodec, defaultLogfmtConfig)
+}
+
+func NewLogfmtCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newLogfmtConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogfmtCodec{
+		scanner:      bufio.NewScanner(input),
+		config:       config,
+		splitOnSpace: strings.TrimSpace(config.FieldSeparator) == "",
+		path:         path,
+	}, nil
+}
+
+// LogfmtCodec reads a file of key/value pairs line by line e.g.
+// `level=info msg="request done" dur=3ms` and sends the pairs under "logfmt".
+type LogfmtCodec struct {
+	scanner      *bufio.Scanner
+	config       *LogfmtConfig
+	splitOnSpace bool
+	value        common.MapStr
+	err          error
+	bad          []byte
+	lineNumber   int
+	path         string
+}
+
+func (codec *LogfmtCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for codec.scanner.Scan() {
+		codec.lineNumber++
+		line := codec.scanner.Text()
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields, err := codec.parse(line)
+		if err != nil {
+			codec.err = fmt.Errorf("line %d: %v", codec.lineNumber, err)
+			codec.bad = []byte(line)
+			return false
+		}
+
+		codec.value = common.MapStr{
+			"logfmt": fields,
+			"event":  line,
+			"file":   codec.path,
+			"line":   codec.lineNumber,
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// separatorAt returns the length of the field separator at the start of s or
+// zero if there isn't one.
+func (codec *LogfmtCodec) separatorAt(s string) int {
+	if codec.splitOnSpace {
+		return len(s) - len(strings.TrimLeftFunc(s, unicode.IsSpace))
+	}
+
+	if strings.HasPrefix(s, codec.config.FieldSeparator) {
+		return len(codec.config.FieldSeparator)
+	}
+
+	return 0
+}
+
+func (codec *LogfmtCodec) parse(line string) (common.MapStr, error) {
+	fields := common.MapStr{}
+	pairSeparator := codec.config.PairSeparator
+
+	for rest := line; rest != ""; {
+		if n := codec.separatorAt(rest); n > 0 {
+			rest = rest[n:]
+			continue
+		}
+
+		// the key runs until the pair separator or the next field
+		end := 0
+		for end < len(rest) && !strings.HasPrefix(rest[end:], pairSeparator) && codec.separatorAt(rest[end:]) == 0 {
+			end++
+		}
+
+		key := rest[:end]
+		rest = rest[end:]
+
+		if !strings.HasPrefix(rest, pairSeparator) {
+			// a bare key is a flag
+			codec.put(fields, key, true)
+			continue
+		}
+
+		rest = rest[len(pairSeparator):]
+
+		var value string
+		var quoted bool
+		var err error
+		if rest != "" && strings.IndexByte(codec.config.Quotes, rest[0]) >= 0 {
+			value, rest, err = readLogfmtQuoted(rest)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %v", key, err)
+			}
+
+			quoted = true
+		} else {
+			end = 0
+			for end < len(rest) && codec.separatorAt(rest[end:]) == 0 {
+				end++
+			}
+
+			value, rest = rest[:end], rest[end:]
+		}
+
+		if key == "" {
+			return nil, fmt.Errorf("value %q has no key", value)
+		}
+
+		// quoted values are always strings
+		if codec.config.InferTypes && !quoted {
+			codec.put(fields, key, inferLogfmtType(value))
+		} else {
+			codec.put(fields, key, value)
+		}
+	}
+
+	return fields, nil
+}
+
+func (codec *LogfmtCodec) put(fields common.MapStr, key string, value interface{}) {
+	existing, found := fields[key]
+	if !found {
+		fields[key] = value
+		return
+	}
+
+	switch codec.config.DuplicateKeys {
+	case DuplicateKeysFirst:
+	case DuplicateKeysArray:
+		if values, ok := existing.([]interface{}); ok {
+			fields[key] = append(values, value)
+		} else {
+			fields[key] = []interface{}{existing, value}
+		}
+	default:
+		fields[key] = value
+	}
+}
+
+// readLogfmtQuoted reads a value surrounded by the quote it starts with,
+// backslashes escape the next character.
+func readLogfmtQuoted(s string) (string, string, error) {
+	quote := s[0]
+
+	var value bytes.Buffer
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case quote:
+			return value.String(), s[i+1:], nil
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				case 't':
+					value.WriteByte('\t')
+				case 'r':
+					value.WriteByte('\r')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+		}
+
+		value.WriteByte(s[i])
+	}
+
+	return "", "", errors.New("unterminated quoted value")
+}
+
+// inferLogfmtType converts numbers, booleans and durations, durations are
+// converted to seconds.
+func inferLogfmtType(value string) interface{} {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i
+	}
+
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return d.Seconds()
+	}
+
+	return value
+}
+
+func (codec *LogfmtCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *LogfmtCodec) Err() error {
+	if codec.err != nil {
+		return codec.err
+	}
+
+	return codec.scanner.Err()
+}
+
+// Recover skips the line that couldn't be parsed.
+func (codec *LogfmtCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.lineNumber, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
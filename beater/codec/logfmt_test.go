@@ -0,0 +1,177 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestLogfmtCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"empty field separator": {"field_separator": ""},
+		"empty pair separator":  {"pair_separator": ""},
+		"same separators":       {"field_separator": ":", "pair_separator": ":"},
+		"quote is separator":    {"quotes": `"=`},
+		"unknown duplicates":    {"duplicate_keys": "merge"},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewLogfmtCodec(testOptions(t, tc), "testfile", strings.NewReader(""))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestLogfmtCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   "",
+			Length: 0,
+		},
+		"blank lines": {
+			Data:   "a=1\n\n  \nb=2\n",
+			Length: 2,
+		},
+		"unterminated quote": {
+			Data:      "a=1\nmsg=\"oops\n",
+			Length:    1,
+			ExpectErr: true,
+		},
+		"missing key": {
+			Data:      "=1",
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewLogfmtCodec(nil, "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d lines, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestLogfmtCodecValue(t *testing.T) {
+	cases := map[string]struct {
+		Data     string
+		Options  map[string]interface{}
+		Expected common.MapStr
+	}{
+		"logfmt": {
+			Data: `level=info msg="request \"done\"" dur=3ms  path=/a?b=c debug`,
+			Expected: common.MapStr{
+				"level": "info",
+				"msg":   `request "done"`,
+				"dur":   "3ms",
+				"path":  "/a?b=c",
+				"debug": true,
+			},
+		},
+		"inferred types": {
+			Data:    `n=-3 f=1.5 ok=true no=false dur=1m30s q="42" s=abc inf=Inf`,
+			Options: map[string]interface{}{"infer_types": true},
+			Expected: common.MapStr{
+				"n":   int64(-3),
+				"f":   1.5,
+				"ok":  true,
+				"no":  false,
+				"dur": 90.0,
+				"q":   "42",
+				"s":   "abc",
+				"inf": "Inf",
+			},
+		},
+		"custom separators and quotes": {
+			Data: `a:'x, y', b: 2,c:`,
+			Options: map[string]interface{}{
+				"field_separator": ",",
+				"pair_separator":  ":",
+				"quotes":          `'"`,
+			},
+			Expected: common.MapStr{
+				"a":  "x, y",
+				" b": " 2",
+				"c":  "",
+			},
+		},
+		"duplicates last": {
+			Data:     `a=1 a=2 a=3`,
+			Expected: common.MapStr{"a": "3"},
+		},
+		"duplicates first": {
+			Data:     `a=1 a=2 a=3`,
+			Options:  map[string]interface{}{"duplicate_keys": "first"},
+			Expected: common.MapStr{"a": "1"},
+		},
+		"duplicates array": {
+			Data:     `a=1 a=2 a=3 b=4`,
+			Options:  map[string]interface{}{"duplicate_keys": "array", "infer_types": true},
+			Expected: common.MapStr{"a": []interface{}{int64(1), int64(2), int64(3)}, "b": int64(4)},
+		},
+		"query string": {
+			Data:     `a=1&b=two&c`,
+			Options:  map[string]interface{}{"field_separator": "&"},
+			Expected: common.MapStr{"a": "1", "b": "two", "c": true},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewLogfmtCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		if !c.Next() {
+			t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+		}
+
+		expected := common.MapStr{
+			"logfmt": tc.Expected,
+			"event":  tc.Data,
+			"file":   "testfile",
+			"line":   1,
+		}
+
+		expectedS := fmt.Sprintf("%v", expected)
+		actualS := fmt.Sprintf("%v", c.Value())
+		if expectedS != actualS {
+			t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// msgpackTimestampExt is the extension type for timestamps.
+const msgpackTimestampExt = -1
+
+// msgpackDecoder decodes MessagePack values
+// https://github.com/msgpack/msgpack/blob/master/spec.md
+// Binary values become base64 strings, timestamps become dates and other
+// extension types become objects with their type and base64 data.
+type msgpackDecoder struct {
+	input *bufio.Reader
+}
+
+// readUint reads a big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.input, buf[8-size:]); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func (d *msgpackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxBinaryStreamDepth {
+		return nil, fmt.Errorf("values nested more than %d deep", maxBinaryStreamDepth)
+	}
+
+	b, err := d.input.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b&0xf0 == 0x80:
+		return d.decodeMap(uint64(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return d.decodeArray(uint64(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return d.decodeString(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+
+	case 0xc4, 0xc5, 0xc6:
+		length, err := d.readUint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := readBinaryString(d.input, length)
+		return base64.StdEncoding.EncodeToString(data), err
+
+	case 0xc7, 0xc8, 0xc9:
+		length, err := d.readUint(1 << (b - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+
+		return d.decodeExt(length)
+
+	case 0xca:
+		bits, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+
+	case 0xcb:
+		bits, err := d.readUint(8)
+		return math.Float64frombits(bits), err
+
+	case 0xcc, 0xcd, 0xce:
+		value, err := d.readUint(1 << (b - 0xcc))
+		return int64(value), err
+
+	case 0xcf:
+		value, err := d.readUint(8)
+		if value > math.MaxInt64 {
+			return value, err
+		}
+
+		return int64(value), err
+
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := uint(1) << (b - 0xd0)
+		value, err := d.readUint(int(size))
+
+		// sign extend from the top bit of the value
+		shift := 64 - 8*size
+		return int64(value<<shift) >> shift, err
+
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (b - 0xd4))
+
+	case 0xd9, 0xda, 0xdb:
+		length, err := d.readUint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+
+		return d.decodeString(length)
+
+	case 0xdc, 0xdd:
+		length, err := d.readUint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+
+		return d.decodeArray(length, depth)
+
+	case 0xde, 0xdf:
+		length, err := d.readUint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+
+		return d.decodeMap(length, depth)
+	}
+
+	return nil, fmt.Errorf("invalid type byte 0x%x", b)
+}
+
+func (d *msgpackDecoder) decodeString(length uint64) (interface{}, error) {
+	data, err := readBinaryString(d.input, length)
+	return string(data), err
+}
+
+func (d *msgpackDecoder) decodeArray(length uint64, depth int) (interface{}, error) {
+	if length > maxBinaryStreamLength {
+		return nil, fmt.Errorf("array length %d is more than the maximum %d", length, maxBinaryStreamLength)
+	}
+
+	values := []interface{}{}
+	for i := uint64(0); i < length; i++ {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (d *msgpackDecoder) decodeMap(length uint64, depth int) (interface{}, error) {
+	if length > maxBinaryStreamLength {
+		return nil, fmt.Errorf("map length %d is more than the maximum %d", length, maxBinaryStreamLength)
+	}
+
+	fields := common.MapStr{}
+	for i := uint64(0); i < length; i++ {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		// keys can be any type but fields need strings
+		fields[fmt.Sprintf("%v", key)] = value
+	}
+
+	return fields, nil
+}
+
+func (d *msgpackDecoder) decodeExt(length uint64) (interface{}, error) {
+	extType, err := d.input.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readBinaryString(d.input, length)
+	if err != nil {
+		return nil, err
+	}
+
+	if int8(extType) != msgpackTimestampExt {
+		return common.MapStr{
+			"type": int8(extType),
+			"data": base64.StdEncoding.EncodeToString(data),
+		}, nil
+	}
+
+	switch len(data) {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		bits := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(bits&0x3ffffffff), int64(bits>>34)).UTC(), nil
+	case 12:
+		nanos := binary.BigEndian.Uint32(data)
+		return time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(nanos)).UTC(), nil
+	}
+
+	return nil, fmt.Errorf("invalid timestamp length %d", len(data))
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestMsgpackCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      []byte
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   nil,
+			Length: 0,
+		},
+		"single map": {
+			Data:   []byte{0x81, 0xa1, 'a', 0x01},
+			Length: 1,
+		},
+		"concatenated": {
+			Data:   []byte{0x81, 0xa1, 'a', 0x01, 0x80, 0x81, 0xa1, 'b', 0xc0},
+			Length: 3,
+		},
+		"second truncated": {
+			Data:      []byte{0x80, 0x81, 0xa1, 'a'},
+			Length:    1,
+			ExpectErr: true,
+		},
+		"non map": {
+			Data:      []byte{0x80, 0x92, 0x01, 0x02},
+			Length:    1,
+			ExpectErr: true,
+		},
+		"invalid type": {
+			Data:      []byte{0x81, 0xa1, 'a', 0xc1},
+			Length:    0,
+			ExpectErr: true,
+		},
+		"huge string": {
+			Data:      []byte{0x81, 0xa1, 'a', 0xdb, 0xff, 0xff, 0xff, 0xff},
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewMsgpackCodec("testfile", bytes.NewReader(tc.Data))
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d maps, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestMsgpackCodecValue(t *testing.T) {
+	data := concat(
+		[]byte{0x8c},
+		[]byte{0xa3, 'u', '8', 'n'}, []byte{0xcc, 0xff},
+		[]byte{0xa3, 'n', 'e', 'g'}, []byte{0xd1, 0xff, 0x00},
+		[]byte{0xa3, 'f', 'i', 'x'}, []byte{0xff},
+		[]byte{0xa3, 'b', 'i', 'g'}, []byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		[]byte{0xa3, 'f', '6', '4'}, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+		[]byte{0xa3, 's', 't', 'r'}, []byte{0xd9, 0x02, 'h', 'i'},
+		[]byte{0xa3, 'b', 'i', 'n'}, []byte{0xc4, 0x02, 'h', 'i'},
+		[]byte{0xa3, 'a', 'r', 'r'}, []byte{0x92, 0xc3, 0xc2},
+		[]byte{0xa3, 'm', 'a', 'p'}, []byte{0x81, 0x01, 0xc0},
+		[]byte{0xa2, 't', 's'}, []byte{0xd6, 0xff, 0x59, 0x68, 0x2f, 0x00},
+		[]byte{0xa3, 't', 's', '8'}, []byte{0xd7, 0xff, 0x00, 0x00, 0x00, 0x04, 0x59, 0x68, 0x2f, 0x00},
+		[]byte{0xa3, 'e', 'x', 't'}, []byte{0xd4, 0x05, 0x2a},
+	)
+
+	expected := common.MapStr{
+		"msgpack": common.MapStr{
+			"u8n": int64(255),
+			"neg": int64(-256),
+			"fix": int64(-1),
+			"big": uint64(18446744073709551615),
+			"f64": float64(1.5),
+			"str": "hi",
+			"bin": "aGk=",
+			"arr": []interface{}{true, false},
+			"map": common.MapStr{"1": nil},
+			"ts":  time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC),
+			"ts8": time.Date(2017, 7, 14, 2, 40, 0, 1, time.UTC),
+			"ext": common.MapStr{"type": int8(5), "data": "Kg=="},
+		},
+		"file": "testfile",
+		"line": 1,
+	}
+
+	c := NewMsgpackCodec("testfile", bytes.NewReader(data))
+	if !c.Next() {
+		t.Fatalf("Quit too early: %v", c.Err())
+	}
+
+	expectedS := fmt.Sprintf("%v", expected)
+	actualS := fmt.Sprintf("%v", c.Value())
+	if expectedS != actualS {
+		t.Errorf("Expected %v, got %v", expectedS, actualS)
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
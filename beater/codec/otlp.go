@@ -0,0 +1,412 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// otlpLevels are the levels of each range of four severity numbers, e.g.
+// INFO to INFO4 are 9 to 12.
+var otlpLevels = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// otlpSeverityNumbers maps the names of the SeverityNumber enum, which the
+// JSON mapping can use instead of the numbers, e.g. SEVERITY_NUMBER_INFO2.
+var otlpSeverityNumbers = newOtlpSeverityNumbers()
+
+func newOtlpSeverityNumbers() map[string]int64 {
+	numbers := map[string]int64{"SEVERITY_NUMBER_UNSPECIFIED": 0}
+
+	for i, level := range otlpLevels {
+		name := "SEVERITY_NUMBER_" + strings.ToUpper(level)
+		numbers[name] = int64(i*4 + 1)
+
+		for j := 2; j <= 4; j++ {
+			numbers[fmt.Sprintf("%s%d", name, j)] = int64(i*4 + j)
+		}
+	}
+
+	return numbers
+}
+
+// otlpLogsData is the JSON mapping of the LogsData message the file
+// exporter writes on each line.
+type otlpLogsData struct {
+	ResourceLogs []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		SchemaUrl string          `json:"schemaUrl"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+
+		// ScopeLogs were called InstrumentationLibraryLogs before OTLP 0.15
+		InstrumentationLibraryLogs []otlpScopeLogs `json:"instrumentationLibraryLogs"`
+	} `json:"resourceLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope                  otlpScope       `json:"scope"`
+	InstrumentationLibrary otlpScope       `json:"instrumentationLibrary"`
+	LogRecords             []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpScope struct {
+	Name       string         `json:"name"`
+	Version    string         `json:"version"`
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpLogRecord struct {
+	// 64 bit integers are strings in JSON
+	TimeUnixNano           json.Number     `json:"timeUnixNano"`
+	ObservedTimeUnixNano   json.Number     `json:"observedTimeUnixNano"`
+	SeverityNumber         json.RawMessage `json:"severityNumber"`
+	SeverityText           string          `json:"severityText"`
+	Body                   *otlpAnyValue   `json:"body"`
+	Attributes             []otlpKeyValue  `json:"attributes"`
+	DroppedAttributesCount int64           `json:"droppedAttributesCount"`
+	Flags                  int64           `json:"flags"`
+	TraceId                string          `json:"traceId"`
+	SpanId                 string          `json:"spanId"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string      `json:"stringValue"`
+	BoolValue   *bool        `json:"boolValue"`
+	IntValue    *json.Number `json:"intValue"`
+	DoubleValue *json.Number `json:"doubleValue"`
+	BytesValue  *string      `json:"bytesValue"`
+	ArrayValue  *struct {
+		Values []otlpAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []otlpKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+}
+
+func init() {
+	Register(OtlpJsonCodecId, withoutOptions(NewOtlpJsonCodec), nil)
+}
+
+func NewOtlpJsonCodec(path string, input io.Reader) Codec {
+	return &OtlpJsonCodec{
+		scanner: newDocumentScanner(input),
+		path:    path,
+	}
+}
+
+// OtlpJsonCodec reads OpenTelemetry logs written as OTLP JSON, one LogsData
+// message per line like the collector's file exporter writes them. It sends
+// an event for each LogRecord under otel with the attributes of its resource
+// and scope, and its timestamp as the event's.
+type OtlpJsonCodec struct {
+	scanner    *bufio.Scanner
+	pending    []common.MapStr
+	value      common.MapStr
+	err        error
+	bad        []byte
+	lineNumber int
+	path       string
+}
+
+func (codec *OtlpJsonCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for len(codec.pending) == 0 {
+		if !codec.scanner.Scan() {
+			return false
+		}
+
+		codec.lineNumber++
+
+		line := codec.scanner.Bytes()
+		if strings.TrimSpace(string(line)) == "" {
+			continue
+		}
+
+		events, err := codec.parse(line)
+		if err != nil {
+			codec.err = fmt.Errorf("line %d: %v", codec.lineNumber, err)
+			codec.bad = append([]byte(nil), line...)
+			return false
+		}
+
+		codec.pending = events
+	}
+
+	codec.value = codec.pending[0]
+	codec.pending = codec.pending[1:]
+	return true
+}
+
+func (codec *OtlpJsonCodec) parse(line []byte) ([]common.MapStr, error) {
+	var data otlpLogsData
+	if err := json.Unmarshal(line, &data); err != nil {
+		return nil, err
+	}
+
+	var events []common.MapStr
+	for _, resourceLogs := range data.ResourceLogs {
+		resource := common.MapStr{}
+		putOtlpAttributes(resource, resourceLogs.Resource.Attributes)
+		if resourceLogs.SchemaUrl != "" {
+			resource["schema_url"] = resourceLogs.SchemaUrl
+		}
+
+		for _, scopeLogs := range append(resourceLogs.ScopeLogs, resourceLogs.InstrumentationLibraryLogs...) {
+			scopeInfo := scopeLogs.Scope
+			if scopeInfo.Name == "" {
+				scopeInfo = scopeLogs.InstrumentationLibrary
+			}
+
+			scope := common.MapStr{}
+			putOtlpAttributes(scope, scopeInfo.Attributes)
+			putOtlpString(scope, "name", scopeInfo.Name)
+			putOtlpString(scope, "version", scopeInfo.Version)
+
+			for i := range scopeLogs.LogRecords {
+				fields, err := normalizeOtlpLogRecord(&scopeLogs.LogRecords[i])
+				if err != nil {
+					return nil, err
+				}
+
+				otel := fields["otel"].(common.MapStr)
+				if len(resource) > 0 {
+					otel["resource"] = resource.Clone()
+				}
+
+				if len(scope) > 0 {
+					otel["scope"] = scope.Clone()
+				}
+
+				fields["file"] = codec.path
+				fields["line"] = codec.lineNumber
+				events = append(events, fields)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// normalizeOtlpLogRecord maps the fields of a LogRecord.
+func normalizeOtlpLogRecord(record *otlpLogRecord) (common.MapStr, error) {
+	otel := common.MapStr{}
+	event := common.MapStr{"otel": otel}
+
+	timestamp, err := parseOtlpTime(record.TimeUnixNano)
+	if err != nil {
+		return nil, err
+	}
+
+	observed, err := parseOtlpTime(record.ObservedTimeUnixNano)
+	if err != nil {
+		return nil, err
+	}
+
+	// the observed time is when the collector read the record, it's used if
+	// the source didn't set a time
+	switch {
+	case timestamp != nil:
+		event["@timestamp"] = *timestamp
+	case observed != nil:
+		event["@timestamp"] = *observed
+	}
+
+	if observed != nil {
+		otel["observed_timestamp"] = *observed
+	}
+
+	severity := common.MapStr{}
+	putOtlpString(severity, "text", record.SeverityText)
+
+	number, err := parseOtlpSeverityNumber(record.SeverityNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if number > 0 {
+		severity["number"] = number
+		if level := int(number-1) / 4; level < len(otlpLevels) {
+			otel["level"] = otlpLevels[level]
+		}
+	}
+
+	if len(severity) > 0 {
+		otel["severity"] = severity
+	}
+
+	if record.Body != nil {
+		otel["body"] = record.Body.value()
+	}
+
+	putOtlpAttributes(otel, record.Attributes)
+	putOtlpString(otel, "trace_id", strings.ToLower(record.TraceId))
+	putOtlpString(otel, "span_id", strings.ToLower(record.SpanId))
+
+	// the lowest bit of the W3C trace flags is whether the trace is sampled
+	if record.Flags != 0 {
+		otel["flags"] = record.Flags
+		otel["trace_sampled"] = record.Flags&1 == 1
+	}
+
+	if record.DroppedAttributesCount > 0 {
+		otel["dropped_attributes_count"] = record.DroppedAttributesCount
+	}
+
+	return event, nil
+}
+
+// value converts an AnyValue to the value it holds. Bytes are kept in base64
+// and doubles that aren't finite are kept as strings.
+func (v *otlpAnyValue) value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		if i, err := strconv.ParseInt(string(*v.IntValue), 10, 64); err == nil {
+			return i
+		}
+
+		return string(*v.IntValue)
+	case v.DoubleValue != nil:
+		f, err := strconv.ParseFloat(string(*v.DoubleValue), 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return string(*v.DoubleValue)
+		}
+
+		return f
+	case v.BytesValue != nil:
+		return *v.BytesValue
+	case v.ArrayValue != nil:
+		values := []interface{}{}
+		for i := range v.ArrayValue.Values {
+			values = append(values, v.ArrayValue.Values[i].value())
+		}
+
+		return values
+	case v.KvlistValue != nil:
+		return otlpKeyValues(v.KvlistValue.Values)
+	}
+
+	return nil
+}
+
+func otlpKeyValues(keyValues []otlpKeyValue) common.MapStr {
+	fields := common.MapStr{}
+	for i := range keyValues {
+		fields[keyValues[i].Key] = keyValues[i].Value.value()
+	}
+
+	return fields
+}
+
+// putOtlpAttributes sets attributes if there are any. The keys are kept as
+// they are e.g. service.name.
+func putOtlpAttributes(fields common.MapStr, attributes []otlpKeyValue) {
+	if len(attributes) > 0 {
+		fields["attributes"] = otlpKeyValues(attributes)
+	}
+}
+
+func putOtlpString(fields common.MapStr, key string, value string) {
+	if value != "" {
+		fields[key] = value
+	}
+}
+
+// parseOtlpTime reads nanoseconds since the epoch, zero means the time isn't
+// set and returns nil.
+func parseOtlpTime(nanos json.Number) (*time.Time, error) {
+	if nanos == "" {
+		return nil, nil
+	}
+
+	n, err := strconv.ParseUint(string(nanos), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q isn't a time in nanoseconds", nanos)
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	t := time.Unix(0, int64(n)).UTC()
+	return &t, nil
+}
+
+// parseOtlpSeverityNumber reads a SeverityNumber given as a number or by
+// its name.
+func parseOtlpSeverityNumber(raw json.RawMessage) (int64, error) {
+	if len(raw) == 0 {
+		return 0, nil
+	}
+
+	var number int64
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number, nil
+	}
+
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		if number, ok := otlpSeverityNumbers[name]; ok {
+			return number, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%s isn't a severity number", raw)
+}
+
+func (codec *OtlpJsonCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *OtlpJsonCodec) Err() error {
+	if codec.err != nil {
+		return codec.err
+	}
+
+	return codec.scanner.Err()
+}
+
+// Recover skips the line that couldn't be parsed, none of its records are
+// sent.
+func (codec *OtlpJsonCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.lineNumber, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestOtlpJsonCodecValue(t *testing.T) {
+	data := `{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},` +
+		`"schemaUrl":"https://opentelemetry.io/schemas/1.9.0","scopeLogs":[{"scope":{"name":"app","version":"1.2"},"logRecords":[` +
+		`{"timeUnixNano":"1525176184123456789","observedTimeUnixNano":"1525176185000000000","severityNumber":17,"severityText":"ERROR",` +
+		`"body":{"stringValue":"payment failed"},"attributes":[{"key":"retries","value":{"intValue":"3"}},{"key":"ok","value":{"boolValue":false}}],` +
+		`"traceId":"5B8EFFF798038103D269B633813FC60C","spanId":"EEE19B7EC3C1B174","flags":1},` +
+		`{"observedTimeUnixNano":1525176186000000000,"severityNumber":"SEVERITY_NUMBER_INFO2",` +
+		`"body":{"kvlistValue":{"values":[{"key":"ratio","value":{"doubleValue":0.5}},{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"intValue":2}]}}}]}}}` +
+		`]}]}]}`
+
+	resource := common.MapStr{
+		"attributes": common.MapStr{"service.name": "checkout"},
+		"schema_url": "https://opentelemetry.io/schemas/1.9.0",
+	}
+	scope := common.MapStr{"name": "app", "version": "1.2"}
+
+	expected := []common.MapStr{
+		{
+			"@timestamp": time.Date(2018, 5, 1, 12, 3, 4, 123456789, time.UTC),
+			"otel": common.MapStr{
+				"observed_timestamp": time.Date(2018, 5, 1, 12, 3, 5, 0, time.UTC),
+				"severity":           common.MapStr{"number": int64(17), "text": "ERROR"},
+				"level":              "error",
+				"body":               "payment failed",
+				"attributes":         common.MapStr{"retries": int64(3), "ok": false},
+				"trace_id":           "5b8efff798038103d269b633813fc60c",
+				"span_id":            "eee19b7ec3c1b174",
+				"flags":              int64(1),
+				"trace_sampled":      true,
+				"resource":           resource,
+				"scope":              scope,
+			},
+			"file": "logs.json",
+			"line": 1,
+		},
+		{
+			"@timestamp": time.Date(2018, 5, 1, 12, 3, 6, 0, time.UTC),
+			"otel": common.MapStr{
+				"observed_timestamp": time.Date(2018, 5, 1, 12, 3, 6, 0, time.UTC),
+				"severity":           common.MapStr{"number": int64(10)},
+				"level":              "info",
+				"body":               common.MapStr{"ratio": 0.5, "tags": []interface{}{"a", int64(2)}},
+				"resource":           resource,
+				"scope":              scope,
+			},
+			"file": "logs.json",
+			"line": 1,
+		},
+	}
+
+	c := NewOtlpJsonCodec("logs.json", strings.NewReader(data))
+	for i, expectedFields := range expected {
+		if !c.Next() {
+			t.Fatalf("Quit too early: %v", c.Err())
+		}
+
+		expectedS := fmt.Sprintf("%v", expectedFields)
+		actualS := fmt.Sprintf("%v", c.Value())
+		if expectedS != actualS {
+			t.Errorf("%d | Expected %v, got %v", i, expectedS, actualS)
+		}
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
+
+func TestOtlpJsonCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Bodies    []interface{}
+		ExpectErr bool
+	}{
+		"empty": {Data: ""},
+		"no records": {
+			Data: `{"resourceLogs":[{"scopeLogs":[{"logRecords":[]}]}]}` + "\n" + `{}`,
+		},
+		"several lines and resources": {
+			Data: `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"a"}}]}]},{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"b"}}]}]}]}` +
+				"\n\n" + `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"c"}}]}]}]}`,
+			Bodies: []interface{}{"a", "b", "c"},
+		},
+		"instrumentation library logs": {
+			Data:   `{"resourceLogs":[{"instrumentationLibraryLogs":[{"instrumentationLibrary":{"name":"old"},"logRecords":[{"body":{"stringValue":"a"}}]}]}]}`,
+			Bodies: []interface{}{"a"},
+		},
+		"bad json": {
+			Data:      `{"resourceLogs":[`,
+			ExpectErr: true,
+		},
+		"bad time": {
+			Data:      `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"yesterday"}]}]}]}`,
+			ExpectErr: true,
+		},
+		"bad severity": {
+			Data:      `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"severityNumber":"LOUD"}]}]}]}`,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewOtlpJsonCodec("logs.json", strings.NewReader(tc.Data))
+
+		var bodies []interface{}
+		for c.Next() {
+			body, _ := c.Value().GetValue("otel.body")
+			bodies = append(bodies, body)
+		}
+
+		if (c.Err() != nil) != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if fmt.Sprintf("%v", bodies) != fmt.Sprintf("%v", tc.Bodies) {
+			t.Errorf("%q | Expected %v, got %v", tn, tc.Bodies, bodies)
+		}
+	}
+}
+
+func TestOtlpJsonCodecRecover(t *testing.T) {
+	data := `{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"a"}}]}]}]}
+{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"b"}},{"timeUnixNano":"x"}]}]}]}
+{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"body":{"stringValue":"c"}}]}]}]}
+`
+
+	c := NewOtlpJsonCodec("logs.json", strings.NewReader(data))
+
+	var actual []interface{}
+	for {
+		for c.Next() {
+			body, _ := c.Value().GetValue("otel.body")
+			actual = append(actual, body)
+		}
+
+		if c.Err() == nil {
+			break
+		}
+
+		event, err := c.(RecoverableCodec).Recover()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual = append(actual, event["line"])
+	}
+
+	if fmt.Sprintf("%v", actual) != "[a 2 c]" {
+		t.Errorf("Expected the records of the good lines, got %v", actual)
+	}
+}
+
+func TestOtlpSeverityNumbers(t *testing.T) {
+	cases := map[string]int64{
+		"SEVERITY_NUMBER_UNSPECIFIED": 0,
+		"SEVERITY_NUMBER_TRACE":       1,
+		"SEVERITY_NUMBER_DEBUG4":      8,
+		"SEVERITY_NUMBER_INFO":        9,
+		"SEVERITY_NUMBER_WARN3":       15,
+		"SEVERITY_NUMBER_FATAL4":      24,
+	}
+
+	for name, expected := range cases {
+		if actual, ok := otlpSeverityNumbers[name]; !ok || actual != expected {
+			t.Errorf("%q | Expected %d, got %d", name, expected, actual)
+		}
+	}
+}
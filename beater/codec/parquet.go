@@ -0,0 +1,781 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	// maxParquetAllocation protects against allocating huge buffers for
+	// corrupt lengths and counts.
+	maxParquetAllocation = 256 * 1024 * 1024
+
+	parquetMagic = "PAR1"
+
+	parquetRequired = 0
+	parquetOptional = 1
+	parquetRepeated = 2
+
+	// julianUnixEpoch is the Julian day of 1970-01-01, used by INT96 timestamps
+	julianUnixEpoch = 2440588
+)
+
+// ParquetConfig holds the options for the parquet codec.
+type ParquetConfig struct {
+	// Columns limits the fields that are read to these dotted paths of schema
+	// field names and everything under them. All columns are read if empty.
+	Columns []string `config:"columns"`
+
+	// BinaryAsString converts binary columns without a string annotation to
+	// strings, some writers leave the annotation out.
+	BinaryAsString bool `config:"binary_as_string"`
+
+	// TempDir is where objects are copied so they can be read out of order.
+	// Defaults to the system temporary directory.
+	TempDir string `config:"temp_dir"`
+}
+
+var defaultParquetConfig = ParquetConfig{
+	Columns:        nil,
+	BinaryAsString: false,
+	TempDir:        "",
+}
+
+func (c *ParquetConfig) Validate() error {
+	for _, column := range c.Columns {
+		if strings.TrimSpace(column) == "" {
+			return errors.New("parquet columns must not be empty")
+		}
+	}
+
+	return validateTempDir("parquet", c.TempDir)
+}
+
+// validateTempDir checks the temp_dir option of codecs that copy objects to
+// a temporary file, empty means the system temporary directory.
+func validateTempDir(codec string, dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("%s temp_dir: %v", codec, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s temp_dir %q isn't a directory", codec, dir)
+	}
+
+	return nil
+}
+
+func newParquetConfig(options *common.Config) (*ParquetConfig, error) {
+	config, err := unpackCodecConfig("parquet", defaultParquetConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*ParquetConfig), nil
+}
+
+func init() {
+	Register(ParquetCodecId, NewParquetCodec, defaultParquetConfig)
+}
+
+func NewParquetCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newParquetConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	codec := &ParquetCodec{
+		config: config,
+		path:   path,
+	}
+
+	codec.init(input)
+
+	return codec, nil
+}
+
+// ParquetCodec reads a Parquet file one row group at a time, sending one
+// event per row with the columns under "parquet". The footer is at the end of
+// the file, so objects that can't be read out of order are first copied to a
+// temporary file rather than into memory.
+type ParquetCodec struct {
+	config     *ParquetConfig
+	file       io.ReaderAt
+	tempFile   *os.File
+	schema     *parquetNode
+	columns    []*parquetColumn
+	rowGroups  []thriftFields
+	rowGroup   int
+	rowsLeft   int64
+	data       []*parquetColumnData
+	cursors    []parquetCursor
+	value      common.MapStr
+	err        error
+	lineNumber int
+	path       string
+}
+
+// parquetNode is an element of the file schema.
+type parquetNode struct {
+	name       string
+	repetition int64
+	physical   int64
+	typeLength int
+	logical    string
+	scale      int
+	children   []*parquetNode
+}
+
+func (node *parquetNode) isGroup() bool {
+	return node.physical < 0
+}
+
+// parquetColumn is a leaf of the schema and the nodes leading to it.
+type parquetColumn struct {
+	path           string
+	nodes          []*parquetNode
+	leaf           *parquetNode
+	maxDef         int
+	maxRep         int
+	binaryAsString bool
+}
+
+// parquetCursor tracks the next level and value of a column in a row group
+// and the position in each list the column repeats in.
+type parquetCursor struct {
+	level   int
+	value   int
+	indexes []int
+}
+
+func (codec *ParquetCodec) init(input io.Reader) {
+	size, err := codec.open(input)
+	if err != nil {
+		codec.fail(err)
+		return
+	}
+
+	if size < int64(2*len(parquetMagic)+4) {
+		codec.fail(errors.New("Not a Parquet file."))
+		return
+	}
+
+	tail := make([]byte, 8)
+	if _, err := codec.file.ReadAt(tail, size-8); err != nil || string(tail[4:]) != parquetMagic {
+		codec.fail(errors.New("Not a Parquet file."))
+		return
+	}
+
+	footerLength := int64(binary.LittleEndian.Uint32(tail))
+	if footerLength > size-8-int64(len(parquetMagic)) {
+		codec.fail(fmt.Errorf("invalid parquet footer length %d", footerLength))
+		return
+	}
+
+	footer := make([]byte, footerLength)
+	if _, err := codec.file.ReadAt(footer, size-8-footerLength); err != nil {
+		codec.fail(fmt.Errorf("error reading parquet footer: %v", err))
+		return
+	}
+
+	metadata, err := (&thriftReader{input: bytes.NewReader(footer)}).readStruct()
+	if err != nil {
+		codec.fail(fmt.Errorf("error reading parquet footer: %v", err))
+		return
+	}
+
+	elements := metadata.structs(2)
+	if len(elements) == 0 {
+		codec.fail(errors.New("parquet file has no schema"))
+		return
+	}
+
+	codec.schema, elements, err = buildParquetSchema(elements)
+	if err == nil && len(elements) > 0 {
+		err = errors.New("schema elements outside the root")
+	}
+
+	if err != nil {
+		codec.fail(fmt.Errorf("error reading parquet schema: %v", err))
+		return
+	}
+
+	if codec.columns, err = projectParquetColumns(codec.schema, codec.config); err != nil {
+		codec.fail(err)
+		return
+	}
+
+	codec.rowGroups = metadata.structs(4)
+}
+
+// open makes the input readable out of order and returns its size.
+func (codec *ParquetCodec) open(input io.Reader) (int64, error) {
+	if file, ok := input.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		codec.file = file
+		return file.Seek(0, io.SeekEnd)
+	}
+
+	tempFile, err := ioutil.TempFile(codec.config.TempDir, "gcsbeat-parquet-")
+	if err != nil {
+		return 0, fmt.Errorf("error creating temporary file: %v", err)
+	}
+
+	codec.tempFile = tempFile
+	codec.file = tempFile
+
+	size, err := io.Copy(tempFile, input)
+	if err != nil {
+		return 0, fmt.Errorf("error copying to temporary file: %v", err)
+	}
+
+	return size, nil
+}
+
+// fail stops the codec with the given error, or nil at the end of the file,
+// and removes the temporary file.
+func (codec *ParquetCodec) fail(err error) {
+	codec.err = err
+	codec.data = nil
+
+	if codec.tempFile != nil {
+		codec.tempFile.Close()
+		os.Remove(codec.tempFile.Name())
+		codec.tempFile = nil
+	}
+}
+
+// buildParquetSchema converts the depth-first list of schema elements into a
+// tree, it returns the elements after the node.
+func buildParquetSchema(elements []thriftFields) (*parquetNode, []thriftFields, error) {
+	if len(elements) == 0 {
+		return nil, nil, errors.New("missing schema elements")
+	}
+
+	element := elements[0]
+	elements = elements[1:]
+
+	node := &parquetNode{
+		name:       element.str(4),
+		repetition: element.i64(3),
+		physical:   -1,
+		typeLength: int(element.i64(2)),
+		scale:      int(element.i64(7)),
+		logical:    parquetLogicalType(element),
+	}
+
+	if element.has(1) {
+		node.physical = element.i64(1)
+	}
+
+	if node.logical == "decimal" && element.strct(10).has(5) {
+		node.scale = int(element.strct(10).strct(5).i64(1))
+	}
+
+	children := element.i64(5)
+	if children < 0 || children > int64(len(elements)) {
+		return nil, nil, fmt.Errorf("element %q has %d children", node.name, children)
+	}
+
+	for i := int64(0); i < children; i++ {
+		var child *parquetNode
+		var err error
+		if child, elements, err = buildParquetSchema(elements); err != nil {
+			return nil, nil, err
+		}
+
+		node.children = append(node.children, child)
+	}
+
+	if node.isGroup() && len(node.children) == 0 {
+		return nil, nil, fmt.Errorf("element %q has no type or children", node.name)
+	}
+
+	return node, elements, nil
+}
+
+// parquetConvertedTypes are the names of the legacy ConvertedType enum.
+var parquetConvertedTypes = map[int64]string{
+	0:  "string",
+	1:  "map",
+	2:  "map",
+	3:  "list",
+	4:  "enum",
+	5:  "decimal",
+	6:  "date",
+	7:  "time-millis",
+	8:  "time-micros",
+	9:  "timestamp-millis",
+	10: "timestamp-micros",
+	13: "uint",
+	14: "uint",
+	19: "json",
+}
+
+// parquetLogicalType names the logical type of an element, preferring the
+// LogicalType union to the older ConvertedType.
+func parquetLogicalType(element thriftFields) string {
+	logical := element.strct(10)
+	unit := func(id int16) string {
+		switch u := logical.strct(id).strct(2); {
+		case u.has(1):
+			return "millis"
+		case u.has(2):
+			return "micros"
+		case u.has(3):
+			return "nanos"
+		}
+
+		return ""
+	}
+
+	switch {
+	case logical.has(1):
+		return "string"
+	case logical.has(2):
+		return "map"
+	case logical.has(3):
+		return "list"
+	case logical.has(4):
+		return "enum"
+	case logical.has(5):
+		return "decimal"
+	case logical.has(6):
+		return "date"
+	case logical.has(7):
+		return "time-" + unit(7)
+	case logical.has(8):
+		return "timestamp-" + unit(8)
+	case logical.has(10):
+		if !logical.strct(10).boolean(2, true) {
+			return "uint"
+		}
+
+		return ""
+	case logical.has(12):
+		return "json"
+	case logical.has(14):
+		return "uuid"
+	}
+
+	if element.has(6) {
+		return parquetConvertedTypes[element.i64(6)]
+	}
+
+	return ""
+}
+
+// projectParquetColumns lists the leaf columns selected by the config.
+func projectParquetColumns(schema *parquetNode, config *ParquetConfig) ([]*parquetColumn, error) {
+	var all []*parquetColumn
+
+	var walk func(node *parquetNode, column parquetColumn)
+	walk = func(node *parquetNode, column parquetColumn) {
+		column.nodes = append(append([]*parquetNode{}, column.nodes...), node)
+		if column.path != "" {
+			column.path += "."
+		}
+
+		column.path += node.name
+
+		switch node.repetition {
+		case parquetOptional:
+			column.maxDef++
+		case parquetRepeated:
+			column.maxDef++
+			column.maxRep++
+		}
+
+		if !node.isGroup() {
+			column.leaf = node
+			column.binaryAsString = config.BinaryAsString
+			all = append(all, &column)
+			return
+		}
+
+		for _, child := range node.children {
+			walk(child, column)
+		}
+	}
+
+	for _, child := range schema.children {
+		walk(child, parquetColumn{})
+	}
+
+	if len(config.Columns) == 0 {
+		return all, nil
+	}
+
+	var selected []*parquetColumn
+	for _, name := range config.Columns {
+		found := false
+		for _, column := range all {
+			if column.path == name || strings.HasPrefix(column.path, name+".") {
+				found = true
+				selected = append(selected, column)
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("parquet column %q isn't in the file", name)
+		}
+	}
+
+	return selected, nil
+}
+
+func (codec *ParquetCodec) Next() bool {
+	if codec.err != nil || codec.file == nil {
+		return false
+	}
+
+	for codec.rowsLeft == 0 {
+		if codec.rowGroup == len(codec.rowGroups) {
+			codec.fail(nil)
+			return false
+		}
+
+		if err := codec.readRowGroup(); err != nil {
+			codec.fail(fmt.Errorf("error reading parquet row group %d: %v", codec.rowGroup, err))
+			return false
+		}
+	}
+
+	record := common.MapStr{}
+	for i, column := range codec.columns {
+		if err := codec.assemble(record, column, codec.data[i], &codec.cursors[i]); err != nil {
+			codec.fail(fmt.Errorf("error reading parquet row %d: column %q: %v", codec.lineNumber+1, column.path, err))
+			return false
+		}
+	}
+
+	codec.rowsLeft--
+	codec.lineNumber++
+	codec.value = common.MapStr{
+		"parquet": simplifyParquetValue(record, codec.schema),
+		"file":    codec.path,
+		"line":    codec.lineNumber,
+	}
+
+	return true
+}
+
+// readRowGroup decodes the selected columns of the next row group.
+func (codec *ParquetCodec) readRowGroup() error {
+	rowGroup := codec.rowGroups[codec.rowGroup]
+	codec.rowGroup++
+
+	chunks := make(map[string]thriftFields)
+	for _, chunk := range rowGroup.structs(1) {
+		if chunk.str(1) != "" {
+			return fmt.Errorf("columns in other files aren't supported")
+		}
+
+		meta := chunk.strct(3)
+		chunks[strings.Join(meta.strs(3), ".")] = meta
+	}
+
+	// free the previous row group before reading the next
+	codec.data = make([]*parquetColumnData, len(codec.columns))
+	codec.cursors = make([]parquetCursor, len(codec.columns))
+
+	for i, column := range codec.columns {
+		meta, ok := chunks[column.path]
+		if !ok {
+			return fmt.Errorf("column %q is missing", column.path)
+		}
+
+		data, err := readParquetColumn(codec.file, column, meta)
+		if err != nil {
+			return fmt.Errorf("column %q: %v", column.path, err)
+		}
+
+		codec.data[i] = data
+		codec.cursors[i].indexes = make([]int, column.maxRep+1)
+	}
+
+	codec.rowsLeft = rowGroup.i64(3)
+	return nil
+}
+
+// assemble adds the column's values for the next row to record by following
+// the repetition and definition levels, see
+// https://github.com/julienledem/redelm/wiki/The-striping-and-assembly-algorithms-from-the-Dremel-paper
+func (codec *ParquetCodec) assemble(record common.MapStr, column *parquetColumn, data *parquetColumnData, cursor *parquetCursor) error {
+	if cursor.level >= len(data.rep) {
+		return errors.New("fewer values than rows")
+	}
+
+	for first := true; cursor.level < len(data.rep); first = false {
+		rep, def := data.rep[cursor.level], data.def[cursor.level]
+		if !first && rep == 0 {
+			break
+		}
+
+		var value interface{}
+		if int(def) == column.maxDef {
+			if cursor.value >= len(data.values) {
+				return errors.New("fewer values than levels")
+			}
+
+			value = data.values[cursor.value]
+			cursor.value++
+		}
+
+		column.place(record, rep, def, value, cursor.indexes)
+		cursor.level++
+	}
+
+	return nil
+}
+
+// place puts a value at the position in record described by its levels.
+// Columns of the same repeated group share the list elements at each index.
+func (column *parquetColumn) place(record common.MapStr, rep, def int32, value interface{}, indexes []int) {
+	container := record
+	var nodeDef, nodeRep int32
+
+	for i, node := range column.nodes {
+		leaf := i == len(column.nodes)-1
+
+		switch node.repetition {
+		case parquetOptional:
+			nodeDef++
+			if def < nodeDef {
+				if _, ok := container[node.name]; !ok {
+					container[node.name] = nil
+				}
+
+				return
+			}
+
+		case parquetRepeated:
+			nodeDef++
+			nodeRep++
+			list, _ := container[node.name].([]interface{})
+			if def < nodeDef {
+				// an empty list
+				if list == nil {
+					container[node.name] = []interface{}{}
+				}
+
+				return
+			}
+
+			// levels above rep start a new list
+			if nodeRep > rep {
+				indexes[nodeRep] = 0
+			} else if nodeRep == rep {
+				indexes[nodeRep]++
+			}
+
+			index := indexes[nodeRep]
+			for len(list) <= index {
+				if leaf {
+					list = append(list, nil)
+				} else {
+					list = append(list, common.MapStr{})
+				}
+			}
+
+			container[node.name] = list
+			if leaf {
+				list[index] = value
+				return
+			}
+
+			container = list[index].(common.MapStr)
+			continue
+		}
+
+		if leaf {
+			container[node.name] = value
+			return
+		}
+
+		child, ok := container[node.name].(common.MapStr)
+		if !ok {
+			child = common.MapStr{}
+			container[node.name] = child
+		}
+
+		container = child
+	}
+}
+
+// simplifyParquetValue turns LIST and MAP annotated groups into arrays and
+// maps.
+func simplifyParquetValue(value interface{}, node *parquetNode) interface{} {
+	fields, ok := value.(common.MapStr)
+	if !ok || !node.isGroup() {
+		return value
+	}
+
+	if len(node.children) == 1 && node.children[0].repetition == parquetRepeated {
+		repeated := node.children[0]
+		entries, _ := fields[repeated.name].([]interface{})
+
+		switch node.logical {
+		case "list":
+			items := []interface{}{}
+			for _, entry := range entries {
+				if element := parquetListElement(node, repeated); element != nil {
+					wrapper, _ := entry.(common.MapStr)
+					items = append(items, simplifyParquetValue(wrapper[element.name], element))
+				} else {
+					items = append(items, simplifyParquetValue(entry, repeated))
+				}
+			}
+
+			return items
+
+		case "map":
+			if len(repeated.children) == 0 {
+				break
+			}
+
+			out := common.MapStr{}
+			key := repeated.children[0]
+			for _, entry := range entries {
+				pair, _ := entry.(common.MapStr)
+				var value interface{}
+				if len(repeated.children) > 1 {
+					value = simplifyParquetValue(pair[repeated.children[1].name], repeated.children[1])
+				}
+
+				out[fmt.Sprintf("%v", pair[key.name])] = value
+			}
+
+			return out
+		}
+	}
+
+	for _, child := range node.children {
+		childValue, ok := fields[child.name]
+		if !ok {
+			continue
+		}
+
+		if list, ok := childValue.([]interface{}); ok && child.repetition == parquetRepeated {
+			for i, item := range list {
+				list[i] = simplifyParquetValue(item, child)
+			}
+
+			continue
+		}
+
+		fields[child.name] = simplifyParquetValue(childValue, child)
+	}
+
+	return fields
+}
+
+// parquetListElement returns the element node of a standard three level
+// list, or nil if the repeated node is the element as in legacy two level
+// lists https://github.com/apache/parquet-format/blob/master/LogicalTypes.md#lists
+func parquetListElement(list, repeated *parquetNode) *parquetNode {
+	if !repeated.isGroup() || len(repeated.children) != 1 {
+		return nil
+	}
+
+	if repeated.name == "array" || repeated.name == list.name+"_tuple" {
+		return nil
+	}
+
+	return repeated.children[0]
+}
+
+// convertParquetValue converts a physical value to its logical type.
+func convertParquetValue(value interface{}, leaf *parquetNode, binaryAsString bool) interface{} {
+	switch v := value.(type) {
+	case int32:
+		switch leaf.logical {
+		case "decimal":
+			return formatDecimal(big.NewInt(int64(v)), leaf.scale)
+		case "date":
+			return time.Unix(int64(v)*24*60*60, 0).UTC()
+		case "time-millis":
+			return formatTimeOfDay(time.Duration(v)*time.Millisecond, "15:04:05.000")
+		case "uint":
+			return uint32(v)
+		}
+
+	case int64:
+		switch leaf.logical {
+		case "decimal":
+			return formatDecimal(big.NewInt(v), leaf.scale)
+		case "time-micros":
+			return formatTimeOfDay(time.Duration(v)*time.Microsecond, "15:04:05.000000")
+		case "time-nanos":
+			return formatTimeOfDay(time.Duration(v), "15:04:05.000000000")
+		case "timestamp-millis":
+			return time.Unix(v/1e3, (v%1e3)*1e6).UTC()
+		case "timestamp-micros":
+			return time.Unix(v/1e6, (v%1e6)*1e3).UTC()
+		case "timestamp-nanos":
+			return time.Unix(0, v).UTC()
+		case "uint":
+			return uint64(v)
+		}
+
+	case []byte:
+		switch {
+		case leaf.physical == parquetInt96 && len(v) == 12:
+			// nanoseconds of the day then the Julian day
+			nanos := int64(binary.LittleEndian.Uint64(v[:8]))
+			day := int64(binary.LittleEndian.Uint32(v[8:]))
+			return time.Unix((day-julianUnixEpoch)*24*60*60, nanos).UTC()
+		case leaf.logical == "decimal":
+			return formatDecimal(decimalFromBytes(v), leaf.scale)
+		case leaf.logical == "uuid" && len(v) == 16:
+			s := hex.EncodeToString(v)
+			return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+		case leaf.logical == "string", leaf.logical == "enum", leaf.logical == "json":
+			return string(v)
+		case binaryAsString && leaf.physical == parquetByteArray && leaf.logical == "":
+			return string(v)
+		}
+	}
+
+	return value
+}
+
+func (codec *ParquetCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *ParquetCodec) Err() error {
+	return codec.err
+}
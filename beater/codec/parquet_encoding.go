@@ -0,0 +1,664 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Physical types, encodings, compression codecs and page types from
+// https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetInt96     = 3
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+	parquetFixed     = 7
+
+	parquetPlain                = 0
+	parquetPlainDictionary      = 2
+	parquetRle                  = 3
+	parquetDeltaBinaryPacked    = 5
+	parquetDeltaLengthByteArray = 6
+	parquetDeltaByteArray       = 7
+	parquetRleDictionary        = 8
+	parquetByteStreamSplit      = 9
+
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+	parquetZstd         = 6
+
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+)
+
+var parquetCompressionNames = []string{"UNCOMPRESSED", "SNAPPY", "GZIP", "LZO", "BROTLI", "LZ4", "ZSTD", "LZ4_RAW"}
+
+// parquetColumnData holds the levels of every value in a column chunk and the
+// values that aren't null.
+type parquetColumnData struct {
+	rep    []int32
+	def    []int32
+	values []interface{}
+}
+
+// readParquetColumn reads and decodes all pages of a column chunk, values are
+// converted to their logical types.
+func readParquetColumn(file io.ReaderAt, column *parquetColumn, meta thriftFields) (*parquetColumnData, error) {
+	compression := meta.i64(4)
+	numValues := meta.i64(5)
+	size := meta.i64(7)
+
+	start := meta.i64(9)
+	if dictionary := meta.i64(11); meta.has(11) && dictionary > 0 && dictionary < start {
+		start = dictionary
+	}
+
+	switch compression {
+	case parquetUncompressed, parquetSnappy, parquetGzip, parquetZstd:
+	default:
+		name := fmt.Sprintf("%d", compression)
+		if compression >= 0 && compression < int64(len(parquetCompressionNames)) {
+			name = parquetCompressionNames[compression]
+		}
+
+		return nil, fmt.Errorf("unsupported compression %s, supported are UNCOMPRESSED, SNAPPY, GZIP and ZSTD", name)
+	}
+
+	input := bufio.NewReader(io.NewSectionReader(file, start, size))
+	data := &parquetColumnData{}
+	var dictionary []interface{}
+
+	for int64(len(data.rep)) < numValues {
+		header, err := (&thriftReader{input: input}).readStruct()
+		if err != nil {
+			return nil, fmt.Errorf("error reading page header: %v", err)
+		}
+
+		compressedSize := header.i64(3)
+		if compressedSize < 0 || compressedSize > maxParquetAllocation {
+			return nil, fmt.Errorf("invalid page size %d", compressedSize)
+		}
+
+		page := make([]byte, compressedSize)
+		if _, err := io.ReadFull(input, page); err != nil {
+			return nil, fmt.Errorf("error reading page: %v", err)
+		}
+
+		switch header.i64(1) {
+		case parquetDictionaryPage:
+			page, err = decompressParquetPage(compression, page)
+			if err != nil {
+				return nil, err
+			}
+
+			count := header.strct(7).i64(1)
+			dictionary, _, err = decodePlainValues(page, int(count), column.leaf)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding dictionary: %v", err)
+			}
+
+		case parquetDataPage:
+			page, err = decompressParquetPage(compression, page)
+			if err != nil {
+				return nil, err
+			}
+
+			pageHeader := header.strct(5)
+			count := int(pageHeader.i64(1))
+
+			// v1 levels are prefixed with their length
+			var rep, def []int32
+			if rep, page, err = decodeParquetLevels(page, column.maxRep, count, true); err != nil {
+				return nil, fmt.Errorf("error decoding repetition levels: %v", err)
+			}
+
+			if def, page, err = decodeParquetLevels(page, column.maxDef, count, true); err != nil {
+				return nil, fmt.Errorf("error decoding definition levels: %v", err)
+			}
+
+			if err := data.addPage(rep, def, column, pageHeader.i64(2), page, dictionary); err != nil {
+				return nil, err
+			}
+
+		case parquetDataPageV2:
+			pageHeader := header.strct(8)
+			count := int(pageHeader.i64(1))
+			defLength := pageHeader.i64(5)
+			repLength := pageHeader.i64(6)
+			if defLength < 0 || repLength < 0 || defLength+repLength > int64(len(page)) {
+				return nil, errors.New("invalid level lengths in data page")
+			}
+
+			// v2 levels are never compressed
+			rep, _, err := decodeParquetLevels(page[:repLength], column.maxRep, count, false)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding repetition levels: %v", err)
+			}
+
+			def, _, err := decodeParquetLevels(page[repLength:repLength+defLength], column.maxDef, count, false)
+			if err != nil {
+				return nil, fmt.Errorf("error decoding definition levels: %v", err)
+			}
+
+			values := page[repLength+defLength:]
+			if pageHeader.boolean(7, true) {
+				if values, err = decompressParquetPage(compression, values); err != nil {
+					return nil, err
+				}
+			}
+
+			if err := data.addPage(rep, def, column, pageHeader.i64(4), values, dictionary); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+func (data *parquetColumnData) addPage(rep, def []int32, column *parquetColumn, encoding int64, page []byte, dictionary []interface{}) error {
+	defined := 0
+	for _, level := range def {
+		if int(level) == column.maxDef {
+			defined++
+		}
+	}
+
+	values, err := decodeParquetValues(page, encoding, defined, column.leaf, dictionary)
+	if err != nil {
+		return fmt.Errorf("error decoding values: %v", err)
+	}
+
+	for _, value := range values {
+		data.values = append(data.values, convertParquetValue(value, column.leaf, column.binaryAsString))
+	}
+
+	data.rep = append(data.rep, rep...)
+	data.def = append(data.def, def...)
+	return nil
+}
+
+func decompressParquetPage(compression int64, page []byte) ([]byte, error) {
+	switch compression {
+	case parquetSnappy:
+		return decodeSnappy(page)
+
+	case parquetGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+
+		return ioutil.ReadAll(reader)
+
+	case parquetZstd:
+		return decodeZstd(page, maxParquetAllocation)
+	}
+
+	return page, nil
+}
+
+// decodeParquetLevels decodes count levels of a column with the given
+// maximum, columns that can't repeat or be null have no levels in the page.
+// It returns the rest of the page after the levels.
+func decodeParquetLevels(page []byte, maxLevel, count int, prefixed bool) ([]int32, []byte, error) {
+	if count < 0 || count > maxParquetAllocation {
+		return nil, nil, fmt.Errorf("invalid value count %d", count)
+	}
+
+	if maxLevel == 0 {
+		return make([]int32, count), page, nil
+	}
+
+	data := page
+	if prefixed {
+		if len(page) < 4 {
+			return nil, nil, errors.New("truncated levels")
+		}
+
+		length := binary.LittleEndian.Uint32(page)
+		if uint64(length) > uint64(len(page)-4) {
+			return nil, nil, errors.New("truncated levels")
+		}
+
+		data, page = page[4:4+length], page[4+length:]
+	}
+
+	bitWidth := 0
+	for maxLevel>>uint(bitWidth) > 0 {
+		bitWidth++
+	}
+
+	levels, err := decodeRleHybrid(data, bitWidth, count)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make([]int32, count)
+	for i, level := range levels {
+		if int(level) > maxLevel {
+			return nil, nil, fmt.Errorf("level %d is more than the maximum %d", level, maxLevel)
+		}
+
+		out[i] = int32(level)
+	}
+
+	return out, page, nil
+}
+
+// decodeRleHybrid decodes count values of the RLE/bit-packing hybrid encoding.
+func decodeRleHybrid(data []byte, bitWidth, count int) ([]uint64, error) {
+	if bitWidth > 64 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+
+	out := make([]uint64, 0, count)
+	byteWidth := (bitWidth + 7) / 8
+
+	for len(out) < count {
+		header, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("truncated run")
+		}
+
+		data = data[n:]
+
+		if header&1 == 0 {
+			// a run of the same value
+			if len(data) < byteWidth {
+				return nil, errors.New("truncated run")
+			}
+
+			var value uint64
+			for i := byteWidth - 1; i >= 0; i-- {
+				value = value<<8 | uint64(data[i])
+			}
+
+			data = data[byteWidth:]
+			for run := header >> 1; run > 0 && len(out) < count; run-- {
+				out = append(out, value)
+			}
+
+			continue
+		}
+
+		// groups of eight bit-packed values, the last group may be padded
+		values := int(header>>1) * 8
+		size := values * bitWidth / 8
+		if size > len(data) {
+			size = len(data)
+		}
+
+		unpacked := unpackBits(data[:size], bitWidth, values)
+		if len(unpacked) == 0 {
+			return nil, errors.New("truncated bit-packed run")
+		}
+
+		data = data[size:]
+		for _, value := range unpacked {
+			if len(out) == count {
+				break
+			}
+
+			out = append(out, value)
+		}
+	}
+
+	return out, nil
+}
+
+// unpackBits reads up to count values of bitWidth bits, least significant bit first.
+func unpackBits(data []byte, bitWidth, count int) []uint64 {
+	if bitWidth == 0 {
+		return make([]uint64, count)
+	}
+
+	if available := len(data) * 8 / bitWidth; available < count {
+		count = available
+	}
+
+	out := make([]uint64, count)
+	bit := 0
+	for i := range out {
+		var value uint64
+		for b := 0; b < bitWidth; b++ {
+			if data[bit/8]&(1<<uint(bit%8)) != 0 {
+				value |= 1 << uint(b)
+			}
+
+			bit++
+		}
+
+		out[i] = value
+	}
+
+	return out
+}
+
+// decodeParquetValues decodes count non-null physical values.
+func decodeParquetValues(page []byte, encoding int64, count int, leaf *parquetNode, dictionary []interface{}) ([]interface{}, error) {
+	switch encoding {
+	case parquetPlain:
+		values, _, err := decodePlainValues(page, count, leaf)
+		return values, err
+
+	case parquetPlainDictionary, parquetRleDictionary:
+		if len(page) == 0 {
+			if count == 0 {
+				return nil, nil
+			}
+
+			return nil, errors.New("missing dictionary indexes")
+		}
+
+		indexes, err := decodeRleHybrid(page[1:], int(page[0]), count)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			if index >= uint64(len(dictionary)) {
+				return nil, fmt.Errorf("dictionary index %d out of range", index)
+			}
+
+			values[i] = dictionary[index]
+		}
+
+		return values, nil
+
+	case parquetRle:
+		if leaf.physical != parquetBoolean || len(page) < 4 {
+			return nil, errors.New("invalid RLE values")
+		}
+
+		bits, err := decodeRleHybrid(page[4:], 1, count)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]interface{}, len(bits))
+		for i, bit := range bits {
+			values[i] = bit == 1
+		}
+
+		return values, nil
+
+	case parquetDeltaBinaryPacked:
+		deltas, _, err := decodeDeltaBinaryPacked(page)
+		if err != nil {
+			return nil, err
+		}
+
+		values := make([]interface{}, len(deltas))
+		for i, v := range deltas {
+			if leaf.physical == parquetInt32 {
+				values[i] = int32(v)
+			} else {
+				values[i] = v
+			}
+		}
+
+		return values, nil
+
+	case parquetDeltaLengthByteArray:
+		values, _, err := decodeDeltaLengthByteArray(page)
+		return values, err
+
+	case parquetDeltaByteArray:
+		prefixes, n, err := decodeDeltaBinaryPacked(page)
+		if err != nil {
+			return nil, err
+		}
+
+		suffixes, _, err := decodeDeltaLengthByteArray(page[n:])
+		if err != nil {
+			return nil, err
+		}
+
+		if len(prefixes) != len(suffixes) {
+			return nil, errors.New("prefix and suffix counts differ")
+		}
+
+		var previous []byte
+		for i, suffix := range suffixes {
+			prefix := prefixes[i]
+			if prefix < 0 || prefix > int64(len(previous)) {
+				return nil, fmt.Errorf("invalid prefix length %d", prefix)
+			}
+
+			value := append(append([]byte{}, previous[:prefix]...), suffix.([]byte)...)
+			suffixes[i] = value
+			previous = value
+		}
+
+		return suffixes, nil
+
+	case parquetByteStreamSplit:
+		width := parquetValueWidth(leaf)
+		if width == 0 || len(page) < width*count {
+			return nil, errors.New("invalid byte stream split values")
+		}
+
+		// byte k of value i is at k*count+i
+		joined := make([]byte, width*count)
+		for i := 0; i < count; i++ {
+			for k := 0; k < width; k++ {
+				joined[i*width+k] = page[k*count+i]
+			}
+		}
+
+		values, _, err := decodePlainValues(joined, count, leaf)
+		return values, err
+	}
+
+	return nil, fmt.Errorf("unsupported encoding %d", encoding)
+}
+
+// parquetValueWidth is the size of fixed width physical types.
+func parquetValueWidth(leaf *parquetNode) int {
+	switch leaf.physical {
+	case parquetInt32, parquetFloat:
+		return 4
+	case parquetInt64, parquetDouble:
+		return 8
+	case parquetInt96:
+		return 12
+	case parquetFixed:
+		return leaf.typeLength
+	}
+
+	return 0
+}
+
+// decodePlainValues decodes count values in the PLAIN encoding and returns
+// the number of bytes read.
+func decodePlainValues(data []byte, count int, leaf *parquetNode) ([]interface{}, int, error) {
+	if count < 0 || count > maxParquetAllocation {
+		return nil, 0, fmt.Errorf("invalid value count %d", count)
+	}
+
+	values := make([]interface{}, 0, count)
+	pos := 0
+
+	if leaf.physical == parquetBoolean {
+		if len(data)*8 < count {
+			return nil, 0, errors.New("truncated booleans")
+		}
+
+		for i := 0; i < count; i++ {
+			values = append(values, data[i/8]&(1<<uint(i%8)) != 0)
+		}
+
+		return values, (count + 7) / 8, nil
+	}
+
+	for i := 0; i < count; i++ {
+		width := parquetValueWidth(leaf)
+		if leaf.physical == parquetByteArray {
+			if len(data)-pos < 4 {
+				return nil, 0, errors.New("truncated byte array")
+			}
+
+			width = int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+		}
+
+		if width < 0 || len(data)-pos < width {
+			return nil, 0, errors.New("truncated values")
+		}
+
+		raw := data[pos : pos+width]
+		pos += width
+
+		switch leaf.physical {
+		case parquetInt32:
+			values = append(values, int32(binary.LittleEndian.Uint32(raw)))
+		case parquetInt64:
+			values = append(values, int64(binary.LittleEndian.Uint64(raw)))
+		case parquetFloat:
+			values = append(values, math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+		case parquetDouble:
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(raw)))
+		default:
+			values = append(values, append([]byte{}, raw...))
+		}
+	}
+
+	return values, pos, nil
+}
+
+// decodeDeltaBinaryPacked decodes the DELTA_BINARY_PACKED encoding and
+// returns the number of bytes read.
+func decodeDeltaBinaryPacked(data []byte) ([]int64, int, error) {
+	pos := 0
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return 0, errors.New("truncated delta header")
+		}
+
+		pos += n
+		return v, nil
+	}
+
+	blockSize, err := readUvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	miniblocks, err := readUvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := readUvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	first, n := binary.Varint(data[pos:])
+	if n <= 0 {
+		return nil, 0, errors.New("truncated delta header")
+	}
+
+	pos += n
+
+	if miniblocks == 0 || blockSize%miniblocks != 0 || total > maxParquetAllocation {
+		return nil, 0, errors.New("invalid delta header")
+	}
+
+	valuesPerMiniblock := int(blockSize / miniblocks)
+	values := make([]int64, 0, total)
+	if total > 0 {
+		values = append(values, first)
+	}
+
+	last := first
+	for uint64(len(values)) < total {
+		minDelta, n := binary.Varint(data[pos:])
+		if n <= 0 || len(data)-pos-n < int(miniblocks) {
+			return nil, 0, errors.New("truncated delta block")
+		}
+
+		pos += n
+		widths := data[pos : pos+int(miniblocks)]
+		pos += int(miniblocks)
+
+		for _, width := range widths {
+			if uint64(len(values)) == total {
+				break
+			}
+
+			if width > 64 {
+				return nil, 0, fmt.Errorf("invalid delta bit width %d", width)
+			}
+
+			size := valuesPerMiniblock * int(width) / 8
+			if len(data)-pos < size {
+				return nil, 0, errors.New("truncated delta miniblock")
+			}
+
+			for _, delta := range unpackBits(data[pos:pos+size], int(width), valuesPerMiniblock) {
+				if uint64(len(values)) == total {
+					break
+				}
+
+				// deltas wrap around like the writer's integers
+				last = int64(uint64(last) + uint64(minDelta) + delta)
+				values = append(values, last)
+			}
+
+			pos += size
+		}
+	}
+
+	return values, pos, nil
+}
+
+// decodeDeltaLengthByteArray decodes the DELTA_LENGTH_BYTE_ARRAY encoding and
+// returns the number of bytes read.
+func decodeDeltaLengthByteArray(data []byte) ([]interface{}, int, error) {
+	lengths, pos, err := decodeDeltaBinaryPacked(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values := make([]interface{}, len(lengths))
+	for i, length := range lengths {
+		if length < 0 || int64(len(data)-pos) < length {
+			return nil, 0, errors.New("truncated byte array")
+		}
+
+		values[i] = append([]byte{}, data[pos:pos+int(length)]...)
+		pos += int(length)
+	}
+
+	return values, pos, nil
+}
@@ -0,0 +1,625 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// tf is a thrift field for the test encoder, values are int64 for integers,
+// string for binary, bool, []tf for structs and tl for lists.
+type tf struct {
+	ID    int16
+	Type  byte
+	Value interface{}
+}
+
+type tl struct {
+	Type  byte
+	Items []interface{}
+}
+
+func writeThriftValue(buf *bytes.Buffer, valueType byte, value interface{}) {
+	switch valueType {
+	case thriftI16, thriftI32, thriftI64:
+		buf.Write(avroLong(value.(int64)))
+	case thriftBinary:
+		writeUvarint(buf, uint64(len(value.(string))))
+		buf.WriteString(value.(string))
+	case thriftList:
+		list := value.(tl)
+		if len(list.Items) < 15 {
+			buf.WriteByte(byte(len(list.Items))<<4 | list.Type)
+		} else {
+			buf.WriteByte(0xf0 | list.Type)
+			writeUvarint(buf, uint64(len(list.Items)))
+		}
+
+		for _, item := range list.Items {
+			writeThriftValue(buf, list.Type, item)
+		}
+	case thriftStruct:
+		writeThriftStruct(buf, value.([]tf))
+	}
+}
+
+func writeThriftStruct(buf *bytes.Buffer, fields []tf) {
+	var last int16
+	for _, field := range fields {
+		fieldType := field.Type
+		if fieldType == thriftTrue && !field.Value.(bool) {
+			fieldType = thriftFalse
+		}
+
+		if delta := field.ID - last; delta > 0 && delta <= 15 {
+			buf.WriteByte(byte(delta)<<4 | fieldType)
+		} else {
+			buf.WriteByte(fieldType)
+			buf.Write(avroLong(int64(field.ID)))
+		}
+
+		last = field.ID
+		if fieldType != thriftTrue && fieldType != thriftFalse {
+			writeThriftValue(buf, fieldType, field.Value)
+		}
+	}
+
+	buf.WriteByte(0)
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func thriftBytes(fields []tf) []byte {
+	var buf bytes.Buffer
+	writeThriftStruct(&buf, fields)
+	return buf.Bytes()
+}
+
+func ti32(id int16, v int64) tf  { return tf{id, thriftI32, v} }
+func ti64(id int16, v int64) tf  { return tf{id, thriftI64, v} }
+func tstr(id int16, v string) tf { return tf{id, thriftBinary, v} }
+
+// testLevels encodes levels as RLE runs of one.
+func testLevels(levels ...int) []byte {
+	var out []byte
+	for _, level := range levels {
+		out = append(out, 0x02, byte(level))
+	}
+
+	return out
+}
+
+// testPrefixed adds the length prefix used by v1 data pages.
+func testPrefixed(data []byte) []byte {
+	prefix := make([]byte, 4)
+	binary.LittleEndian.PutUint32(prefix, uint32(len(data)))
+	return append(prefix, data...)
+}
+
+func testPlain(values ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			binary.Write(&buf, binary.LittleEndian, uint32(len(v)))
+			buf.WriteString(v)
+		default:
+			binary.Write(&buf, binary.LittleEndian, v)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func testCompress(compression int64, data []byte) []byte {
+	switch compression {
+	case parquetSnappy:
+		return testSnappy(data)
+	case parquetGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	case parquetZstd:
+		return testZstd(data)
+	}
+
+	return data
+}
+
+type testPage struct {
+	Type   int64
+	Header tf
+	Data   []byte
+}
+
+// v1 builds a data page, levels are given without their length prefix.
+func v1(count, encoding int64, rep, def, values []byte) testPage {
+	var data []byte
+	if rep != nil {
+		data = append(data, testPrefixed(rep)...)
+	}
+
+	if def != nil {
+		data = append(data, testPrefixed(def)...)
+	}
+
+	return testPage{
+		Type:   parquetDataPage,
+		Header: tf{5, thriftStruct, []tf{ti32(1, count), ti32(2, encoding), ti32(3, parquetRle), ti32(4, parquetRle)}},
+		Data:   append(data, values...),
+	}
+}
+
+type testChunk struct {
+	Path        []string
+	Type        int64
+	Compression int64
+	NumValues   int64
+	Pages       []testPage
+}
+
+// testParquetFile lays out the chunks of each row group followed by the footer.
+func testParquetFile(schema []tf, rowGroups [][]testChunk, rows []int64) []byte {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+
+	var groups []interface{}
+	for g, chunks := range rowGroups {
+		var columns []interface{}
+		for _, chunk := range chunks {
+			start := int64(file.Len())
+			dataOffset := start
+
+			for _, page := range chunk.Pages {
+				var compressed []byte
+				fields := []tf{ti32(1, page.Type), ti32(2, int64(len(page.Data)))}
+
+				if page.Type == parquetDataPageV2 {
+					// only the values of v2 pages are compressed
+					header := page.Header.Value.([]tf)
+					levels := header[4].Value.(int64) + header[5].Value.(int64)
+					compressed = append(append([]byte{}, page.Data[:levels]...), testCompress(chunk.Compression, page.Data[levels:])...)
+				} else {
+					compressed = testCompress(chunk.Compression, page.Data)
+				}
+
+				fields = append(fields, ti32(3, int64(len(compressed))), page.Header)
+				if page.Type == parquetDictionaryPage {
+					dataOffset = -1
+				} else if dataOffset < 0 {
+					dataOffset = int64(file.Len())
+				}
+
+				file.Write(thriftBytes(fields))
+				file.Write(compressed)
+			}
+
+			paths := []interface{}{}
+			for _, name := range chunk.Path {
+				paths = append(paths, name)
+			}
+
+			meta := []tf{
+				ti32(1, chunk.Type),
+				{2, thriftList, tl{thriftI32, []interface{}{int64(0)}}},
+				{3, thriftList, tl{thriftBinary, paths}},
+				ti32(4, chunk.Compression),
+				ti64(5, chunk.NumValues),
+				ti64(6, int64(file.Len())-start),
+				ti64(7, int64(file.Len())-start),
+				ti64(9, dataOffset),
+			}
+
+			if dataOffset != start {
+				meta = append(meta, ti64(11, start))
+			}
+
+			columns = append(columns, []tf{ti64(2, start), {3, thriftStruct, meta}})
+		}
+
+		groups = append(groups, []tf{
+			{1, thriftList, tl{thriftStruct, columns}},
+			ti64(2, 0),
+			ti64(3, rows[g]),
+		})
+	}
+
+	elements := []interface{}{}
+	for _, element := range schema {
+		elements = append(elements, element.Value)
+	}
+
+	footer := thriftBytes([]tf{
+		ti32(1, 1),
+		{2, thriftList, tl{thriftStruct, elements}},
+		ti64(3, 0),
+		{4, thriftList, tl{thriftStruct, groups}},
+	})
+
+	file.Write(footer)
+	binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	file.WriteString(parquetMagic)
+	return file.Bytes()
+}
+
+// schemaElement builds a schema element, the ID is unused.
+func schemaElement(name string, repetition int64, physical int64, children int64, extra ...tf) tf {
+	fields := []tf{}
+	if physical >= 0 {
+		fields = append(fields, ti32(1, physical))
+	}
+
+	fields = append(fields, ti32(3, repetition), tstr(4, name))
+	if children > 0 {
+		fields = append(fields, ti32(5, children))
+	}
+
+	return tf{0, thriftStruct, append(fields, extra...)}
+}
+
+var testParquetSchema = []tf{
+	schemaElement("schema", parquetRequired, -1, 7),
+	schemaElement("id", parquetRequired, parquetInt64, 0),
+	schemaElement("name", parquetOptional, parquetByteArray, 0, ti32(6, 0)),
+	schemaElement("ts", parquetOptional, parquetInt64, 0, tf{10, thriftStruct, []tf{
+		{8, thriftStruct, []tf{{1, thriftTrue, true}, {2, thriftStruct, []tf{{1, thriftStruct, []tf{}}}}}},
+	}}),
+	schemaElement("price", parquetRequired, parquetInt32, 0, ti32(6, 5), ti32(7, 2), ti32(8, 9)),
+	schemaElement("tags", parquetOptional, -1, 1, ti32(6, 3)),
+	schemaElement("list", parquetRepeated, -1, 1),
+	schemaElement("element", parquetOptional, parquetByteArray, 0, ti32(6, 0)),
+	schemaElement("attrs", parquetOptional, -1, 1, ti32(6, 1)),
+	schemaElement("key_value", parquetRepeated, -1, 2),
+	schemaElement("key", parquetRequired, parquetByteArray, 0, ti32(6, 0)),
+	schemaElement("value", parquetOptional, parquetInt32, 0),
+	schemaElement("point", parquetOptional, -1, 1),
+	schemaElement("x", parquetRequired, parquetDouble, 0),
+}
+
+// testParquetRowGroups holds two rows in separate row groups, each column is
+// encoded differently.
+var testParquetRowGroups = [][]testChunk{
+	{
+		{[]string{"id"}, parquetInt64, parquetSnappy, 1, []testPage{v1(1, parquetPlain, nil, nil, testPlain(int64(1)))}},
+		{[]string{"name"}, parquetByteArray, parquetGzip, 1, []testPage{{
+			Type:   parquetDataPageV2,
+			Header: tf{8, thriftStruct, []tf{ti32(1, 1), ti32(2, 0), ti32(3, 1), ti32(4, parquetPlain), ti32(5, 2), ti32(6, 0)}},
+			Data:   append(testLevels(1), testPlain("a")...),
+		}}},
+		{[]string{"ts"}, parquetInt64, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, nil, testLevels(1), testPlain(int64(1500000000123)))}},
+		{[]string{"price"}, parquetInt32, parquetZstd, 1, []testPage{v1(1, parquetPlain, nil, nil, testPlain(int32(12345)))}},
+		{[]string{"tags", "list", "element"}, parquetByteArray, parquetSnappy, 3, []testPage{
+			{Type: parquetDictionaryPage, Header: tf{7, thriftStruct, []tf{ti32(1, 2), ti32(2, parquetPlain)}}, Data: testPlain("x", "y")},
+			v1(3, parquetRleDictionary, testLevels(0, 1, 1), testLevels(3, 2, 3), append([]byte{1}, testLevels(0, 1)...)),
+		}},
+		{[]string{"attrs", "key_value", "key"}, parquetByteArray, parquetUncompressed, 2, []testPage{v1(2, parquetPlain, testLevels(0, 1), testLevels(2, 2), testPlain("k1", "k2"))}},
+		{[]string{"attrs", "key_value", "value"}, parquetInt32, parquetUncompressed, 2, []testPage{v1(2, parquetPlain, testLevels(0, 1), testLevels(3, 2), testPlain(int32(1)))}},
+		{[]string{"point", "x"}, parquetDouble, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, nil, testLevels(1), testPlain(7.5))}},
+	},
+	{
+		{[]string{"id"}, parquetInt64, parquetSnappy, 1, []testPage{v1(1, parquetPlain, nil, nil, testPlain(int64(2)))}},
+		{[]string{"name"}, parquetByteArray, parquetGzip, 1, []testPage{v1(1, parquetPlain, nil, testLevels(0), nil)}},
+		{[]string{"ts"}, parquetInt64, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, nil, testLevels(0), nil)}},
+		{[]string{"price"}, parquetInt32, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, nil, nil, testPlain(int32(-5)))}},
+		{[]string{"tags", "list", "element"}, parquetByteArray, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, testLevels(0), testLevels(0), nil)}},
+		{[]string{"attrs", "key_value", "key"}, parquetByteArray, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, testLevels(0), testLevels(1), nil)}},
+		{[]string{"attrs", "key_value", "value"}, parquetInt32, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, testLevels(0), testLevels(1), nil)}},
+		{[]string{"point", "x"}, parquetDouble, parquetUncompressed, 1, []testPage{v1(1, parquetPlain, nil, testLevels(0), nil)}},
+	},
+}
+
+// nonSeekable hides the io.ReaderAt of the wrapped reader, like a GCS object.
+type nonSeekable struct {
+	io.Reader
+}
+
+func TestParquetCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"empty column":     {"columns": []string{"id", " "}},
+		"missing temp dir": {"temp_dir": "/does/not/exist"},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewParquetCodec(testOptions(t, tc), "testfile", strings.NewReader(""))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestParquetCodecNextErr(t *testing.T) {
+	valid := testParquetFile(testParquetSchema, testParquetRowGroups, []int64{1, 1})
+
+	lzo := make([][]testChunk, 1)
+	lzo[0] = append([]testChunk{}, testParquetRowGroups[0]...)
+	lzo[0][0].Compression = 3
+
+	cases := map[string]struct {
+		Data      []byte
+		Options   map[string]interface{}
+		Length    int
+		ExpectErr bool
+	}{
+		"valid": {
+			Data:   valid,
+			Length: 2,
+		},
+		"no row groups": {
+			Data:   testParquetFile(testParquetSchema, nil, nil),
+			Length: 0,
+		},
+		"empty file": {
+			Data:      []byte{},
+			Length:    0,
+			ExpectErr: true,
+		},
+		"not parquet": {
+			Data:      []byte("PAR1 this is just text PAR2"),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"truncated": {
+			Data:      valid[:len(valid)-3],
+			Length:    0,
+			ExpectErr: true,
+		},
+		"unsupported compression": {
+			Data:      testParquetFile(testParquetSchema, lzo, []int64{1}),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"more rows than values": {
+			Data:      testParquetFile(testParquetSchema, testParquetRowGroups, []int64{2, 1}),
+			Length:    1,
+			ExpectErr: true,
+		},
+		"unknown column": {
+			Data:      valid,
+			Options:   map[string]interface{}{"columns": []string{"nope"}},
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewParquetCodec(testOptions(t, tc.Options), "testfile", bytes.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d rows, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestParquetCodecValue(t *testing.T) {
+	data := testParquetFile(testParquetSchema, testParquetRowGroups, []int64{1, 1})
+
+	cases := map[string]struct {
+		Options  map[string]interface{}
+		Expected []common.MapStr
+	}{
+		"all columns": {
+			Expected: []common.MapStr{
+				{
+					"id":    int64(1),
+					"name":  "a",
+					"ts":    time.Date(2017, 7, 14, 2, 40, 0, 123000000, time.UTC),
+					"price": "123.45",
+					"tags":  []interface{}{"x", nil, "y"},
+					"attrs": common.MapStr{"k1": int32(1), "k2": nil},
+					"point": common.MapStr{"x": 7.5},
+				},
+				{
+					"id":    int64(2),
+					"name":  nil,
+					"ts":    nil,
+					"price": "-0.05",
+					"tags":  nil,
+					"attrs": common.MapStr{},
+					"point": nil,
+				},
+			},
+		},
+		"projection": {
+			Options: map[string]interface{}{"columns": []string{"id", "tags", "point.x"}},
+			Expected: []common.MapStr{
+				{"id": int64(1), "tags": []interface{}{"x", nil, "y"}, "point": common.MapStr{"x": 7.5}},
+				{"id": int64(2), "tags": nil, "point": nil},
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewParquetCodec(testOptions(t, tc.Options), "testfile", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		for i, fields := range tc.Expected {
+			if !c.Next() {
+				t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+			}
+
+			expectedS := fmt.Sprintf("%v", common.MapStr{"parquet": fields, "file": "testfile", "line": i + 1})
+			actualS := fmt.Sprintf("%v", c.Value())
+			if expectedS != actualS {
+				t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+			}
+		}
+
+		if c.Next() || c.Err() != nil {
+			t.Errorf("%q | Expected the end of the file, got %v", tn, c.Err())
+		}
+	}
+}
+
+func TestParquetCodecTempFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	data := testParquetFile(testParquetSchema, testParquetRowGroups, []int64{1, 1})
+	options := testOptions(t, map[string]interface{}{"temp_dir": dir})
+	c, err := NewParquetCodec(options, "testfile", nonSeekable{bytes.NewReader(data)})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected the object to be copied to a temporary file, got %d files", len(files))
+	}
+
+	count := 0
+	for c.Next() {
+		count++
+	}
+
+	if c.Err() != nil || count != 2 {
+		t.Errorf("Expected 2 rows and no error, got %d and %v", count, c.Err())
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Expected the temporary file to be removed, got %d files", len(files))
+	}
+}
+
+func TestDecodeDeltaBinaryPacked(t *testing.T) {
+	cases := map[string]struct {
+		Data     []byte
+		Expected []int64
+		Read     int
+	}{
+		"constant delta": {
+			Data:     []byte{0x80, 0x01, 0x04, 0x05, 0x02, 0x02, 0, 0, 0, 0},
+			Expected: []int64{1, 2, 3, 4, 5},
+			Read:     10,
+		},
+		"packed deltas": {
+			Data:     []byte{0x80, 0x01, 0x04, 0x08, 0x0e, 0x03, 2, 0, 0, 0, 0xC0, 0xFF, 0, 0, 0, 0, 0, 0, 0xAA},
+			Expected: []int64{7, 5, 3, 1, 2, 3, 4, 5},
+			Read:     18,
+		},
+		"single value": {
+			Data:     []byte{0x80, 0x01, 0x04, 0x01, 0x03},
+			Expected: []int64{-2},
+			Read:     5,
+		},
+	}
+
+	for tn, tc := range cases {
+		values, read, err := decodeDeltaBinaryPacked(tc.Data)
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		if fmt.Sprintf("%v", values) != fmt.Sprintf("%v", tc.Expected) || read != tc.Read {
+			t.Errorf("%q | Expected %v reading %d bytes, got %v reading %d", tn, tc.Expected, tc.Read, values, read)
+		}
+	}
+}
+
+func TestConvertParquetValue(t *testing.T) {
+	int96 := make([]byte, 12)
+	binary.LittleEndian.PutUint64(int96, uint64(time.Hour))
+	binary.LittleEndian.PutUint32(int96[8:], julianUnixEpoch+1)
+
+	cases := map[string]struct {
+		Value          interface{}
+		Leaf           parquetNode
+		BinaryAsString bool
+		Expected       interface{}
+	}{
+		"int96 timestamp": {
+			Value:    int96,
+			Leaf:     parquetNode{physical: parquetInt96},
+			Expected: time.Date(1970, 1, 2, 1, 0, 0, 0, time.UTC),
+		},
+		"date": {
+			Value:    int32(1),
+			Leaf:     parquetNode{physical: parquetInt32, logical: "date"},
+			Expected: time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		"time micros": {
+			Value:    int64(3723000001),
+			Leaf:     parquetNode{physical: parquetInt64, logical: "time-micros"},
+			Expected: "01:02:03.000001",
+		},
+		"unsigned": {
+			Value:    int64(-1),
+			Leaf:     parquetNode{physical: parquetInt64, logical: "uint"},
+			Expected: uint64(math.MaxUint64),
+		},
+		"fixed decimal": {
+			Value:    []byte{0xFF, 0x38},
+			Leaf:     parquetNode{physical: parquetFixed, logical: "decimal", scale: 1},
+			Expected: "-20.0",
+		},
+		"uuid": {
+			Value:    []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00},
+			Leaf:     parquetNode{physical: parquetFixed, logical: "uuid"},
+			Expected: "123e4567-e89b-12d3-a456-426614174000",
+		},
+		"unannotated binary": {
+			Value:    []byte("abc"),
+			Leaf:     parquetNode{physical: parquetByteArray},
+			Expected: []byte("abc"),
+		},
+		"binary as string": {
+			Value:          []byte("abc"),
+			Leaf:           parquetNode{physical: parquetByteArray},
+			BinaryAsString: true,
+			Expected:       "abc",
+		},
+	}
+
+	for tn, tc := range cases {
+		actual := convertParquetValue(tc.Value, &tc.Leaf, tc.BinaryAsString)
+		if fmt.Sprintf("%#v", actual) != fmt.Sprintf("%#v", tc.Expected) {
+			t.Errorf("%q | Expected %#v, got %#v", tn, tc.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Parquet metadata is serialized with the Thrift compact protocol
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+// Rather than generating code for parquet.thrift, structs are decoded into
+// maps of field ID to value and only the fields we need are read from them.
+
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+
+	// maxThriftDepth stops corrupt metadata from recursing forever
+	maxThriftDepth = 64
+)
+
+// thriftFields is a decoded struct.
+type thriftFields map[int16]interface{}
+
+func (f thriftFields) i64(id int16) int64 {
+	v, _ := f[id].(int64)
+	return v
+}
+
+func (f thriftFields) has(id int16) bool {
+	_, ok := f[id]
+	return ok
+}
+
+func (f thriftFields) str(id int16) string {
+	v, _ := f[id].([]byte)
+	return string(v)
+}
+
+func (f thriftFields) boolean(id int16, fallback bool) bool {
+	v, ok := f[id].(bool)
+	if !ok {
+		return fallback
+	}
+
+	return v
+}
+
+func (f thriftFields) strct(id int16) thriftFields {
+	v, _ := f[id].(thriftFields)
+	return v
+}
+
+func (f thriftFields) structs(id int16) []thriftFields {
+	list, _ := f[id].([]interface{})
+
+	var out []thriftFields
+	for _, item := range list {
+		if s, ok := item.(thriftFields); ok {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
+
+func (f thriftFields) strs(id int16) []string {
+	list, _ := f[id].([]interface{})
+
+	var out []string
+	for _, item := range list {
+		if b, ok := item.([]byte); ok {
+			out = append(out, string(b))
+		}
+	}
+
+	return out
+}
+
+// thriftReader reads compact protocol values.
+type thriftReader struct {
+	input interface {
+		io.Reader
+		io.ByteReader
+	}
+	depth int
+}
+
+func (r *thriftReader) readStruct() (thriftFields, error) {
+	r.depth++
+	defer func() { r.depth-- }()
+
+	if r.depth > maxThriftDepth {
+		return nil, fmt.Errorf("thrift structs nested more than %d deep", maxThriftDepth)
+	}
+
+	fields := thriftFields{}
+	var lastID int16
+
+	for {
+		header, err := r.input.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if header == 0 {
+			return fields, nil
+		}
+
+		fieldType := header & 0x0f
+		if delta := int16(header >> 4); delta != 0 {
+			lastID += delta
+		} else {
+			id, err := binary.ReadVarint(r.input)
+			if err != nil {
+				return nil, err
+			}
+
+			lastID = int16(id)
+		}
+
+		// booleans are encoded in the field type
+		switch fieldType {
+		case thriftTrue:
+			fields[lastID] = true
+			continue
+		case thriftFalse:
+			fields[lastID] = false
+			continue
+		}
+
+		value, err := r.readValue(fieldType)
+		if err != nil {
+			return nil, err
+		}
+
+		fields[lastID] = value
+	}
+}
+
+func (r *thriftReader) readValue(valueType byte) (interface{}, error) {
+	switch valueType {
+	case thriftTrue, thriftFalse:
+		// booleans in collections take a byte
+		b, err := r.input.ReadByte()
+		return b == thriftTrue, err
+
+	case thriftByte:
+		b, err := r.input.ReadByte()
+		return int64(int8(b)), err
+
+	case thriftI16, thriftI32, thriftI64:
+		return binary.ReadVarint(r.input)
+
+	case thriftDouble:
+		var bits [8]byte
+		if _, err := io.ReadFull(r.input, bits[:]); err != nil {
+			return nil, err
+		}
+
+		return math.Float64frombits(binary.LittleEndian.Uint64(bits[:])), nil
+
+	case thriftBinary:
+		length, err := binary.ReadUvarint(r.input)
+		if err != nil {
+			return nil, err
+		}
+
+		if length > maxParquetAllocation {
+			return nil, fmt.Errorf("invalid thrift binary length %d", length)
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(r.input, data)
+		return data, err
+
+	case thriftList, thriftSet:
+		header, err := r.input.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = binary.ReadUvarint(r.input); err != nil {
+				return nil, err
+			}
+		}
+
+		var list []interface{}
+		for i := uint64(0); i < size; i++ {
+			item, err := r.readValue(header & 0x0f)
+			if err != nil {
+				return nil, err
+			}
+
+			list = append(list, item)
+		}
+
+		return list, nil
+
+	case thriftMap:
+		size, err := binary.ReadUvarint(r.input)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		types, err := r.input.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		// parquet only uses maps we don't need, so the entries are skipped
+		for i := uint64(0); i < size; i++ {
+			if _, err := r.readValue(types >> 4); err != nil {
+				return nil, err
+			}
+
+			if _, err := r.readValue(types & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+
+	case thriftStruct:
+		return r.readStruct()
+	}
+
+	return nil, fmt.Errorf("unknown thrift type %d", valueType)
+}
@@ -0,0 +1,851 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	// maxProtobufMessageSize protects against allocating huge buffers for
+	// corrupt length prefixes.
+	maxProtobufMessageSize = 64 * 1024 * 1024
+
+	// maxProtobufDepth stops deeply nested or recursive messages
+	maxProtobufDepth = 100
+
+	protoVarint          = 0
+	protoFixed64         = 1
+	protoLengthDelimited = 2
+	protoFixed32         = 5
+)
+
+// ProtobufConfig holds the options for the protobuf codec.
+type ProtobufConfig struct {
+	// DescriptorSet is the path to a FileDescriptorSet e.g. the output of
+	// protoc --include_imports --descriptor_set_out.
+	DescriptorSet string `config:"descriptor_set"`
+
+	// MessageType is the full name of the message in the file e.g. example.v1.Event.
+	MessageType string `config:"message_type"`
+
+	// UseProtoNames keeps the field names from the .proto file instead of
+	// converting them to lowerCamelCase.
+	UseProtoNames bool `config:"use_proto_names"`
+
+	// EmitDefaults includes fields that weren't set with their default value.
+	EmitDefaults bool `config:"emit_defaults"`
+}
+
+var defaultProtobufConfig = ProtobufConfig{
+	UseProtoNames: false,
+	EmitDefaults:  false,
+}
+
+func (c *ProtobufConfig) Validate() error {
+	if c.DescriptorSet == "" || c.MessageType == "" {
+		return errors.New("protobuf codec needs a descriptor_set and message_type")
+	}
+
+	_, err := loadProtobufTypes(c)
+	return err
+}
+
+func newProtobufConfig(options *common.Config) (*ProtobufConfig, error) {
+	config, err := unpackCodecConfig("protobuf", defaultProtobufConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*ProtobufConfig), nil
+}
+
+func init() {
+	Register(ProtobufCodecId, NewProtobufCodec, defaultProtobufConfig)
+}
+
+func NewProtobufCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newProtobufConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := loadProtobufTypes(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProtobufCodec{
+		input:  bufio.NewReader(input),
+		types:  types,
+		config: config,
+		path:   path,
+	}, nil
+}
+
+// ProtobufCodec reads messages each prefixed by their varint encoded length,
+// as written by Java's writeDelimitedTo, sending one event per message under
+// "protobuf" using the proto3 JSON mapping
+// https://developers.google.com/protocol-buffers/docs/proto3#json
+type ProtobufCodec struct {
+	input      *bufio.Reader
+	types      *protobufTypes
+	config     *ProtobufConfig
+	value      common.MapStr
+	err        error
+	lineNumber int
+	path       string
+}
+
+// protobufTypes indexes the messages and enums of a descriptor set by their
+// fully qualified name with a leading dot, as used in field type names.
+type protobufTypes struct {
+	messages map[string]*descriptor.DescriptorProto
+	enums    map[string]*descriptor.EnumDescriptorProto
+	root     string
+}
+
+func loadProtobufTypes(config *ProtobufConfig) (*protobufTypes, error) {
+	data, err := ioutil.ReadFile(config.DescriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("error reading protobuf descriptor set: %v", err)
+	}
+
+	set := &descriptor.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("error parsing protobuf descriptor set %q: %v", config.DescriptorSet, err)
+	}
+
+	types := &protobufTypes{
+		messages: make(map[string]*descriptor.DescriptorProto),
+		enums:    make(map[string]*descriptor.EnumDescriptorProto),
+	}
+
+	for _, file := range set.File {
+		prefix := ""
+		if file.GetPackage() != "" {
+			prefix = "." + file.GetPackage()
+		}
+
+		for _, enum := range file.EnumType {
+			types.enums[prefix+"."+enum.GetName()] = enum
+		}
+
+		for _, message := range file.MessageType {
+			types.addMessage(prefix, message)
+		}
+	}
+
+	types.root = "." + strings.TrimPrefix(config.MessageType, ".")
+	if _, ok := types.messages[types.root]; !ok {
+		return nil, fmt.Errorf("protobuf message type %q isn't in %q", config.MessageType, config.DescriptorSet)
+	}
+
+	return types, nil
+}
+
+func (types *protobufTypes) addMessage(prefix string, message *descriptor.DescriptorProto) {
+	name := prefix + "." + message.GetName()
+	types.messages[name] = message
+
+	for _, enum := range message.EnumType {
+		types.enums[name+"."+enum.GetName()] = enum
+	}
+
+	for _, nested := range message.NestedType {
+		types.addMessage(name, nested)
+	}
+}
+
+func (codec *ProtobufCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	length, err := binary.ReadUvarint(codec.input)
+	if err == io.EOF {
+		return false
+	}
+
+	if err != nil {
+		codec.err = fmt.Errorf("error reading length of message %d: %v", codec.lineNumber+1, err)
+		return false
+	}
+
+	if length > maxProtobufMessageSize {
+		codec.err = fmt.Errorf("message %d is %d bytes, more than the maximum %d", codec.lineNumber+1, length, maxProtobufMessageSize)
+		return false
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(codec.input, data); err != nil {
+		codec.err = fmt.Errorf("error reading message %d: %v", codec.lineNumber+1, err)
+		return false
+	}
+
+	fields, err := codec.decodeMessage(data, codec.types.root, 0)
+	if err != nil {
+		codec.err = fmt.Errorf("error decoding message %d: %v", codec.lineNumber+1, err)
+		return false
+	}
+
+	codec.lineNumber++
+	codec.value = common.MapStr{
+		"protobuf": fields,
+		"file":     codec.path,
+		"line":     codec.lineNumber,
+	}
+
+	return true
+}
+
+// readProtoFields calls handle with each field in a message. value holds
+// varint and fixed width values and payload length-delimited ones.
+func readProtoFields(data []byte, handle func(number int32, wireType int, value uint64, payload []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+
+		data = data[n:]
+		number, wireType := int32(key>>3), int(key&7)
+
+		var value uint64
+		var payload []byte
+
+		switch wireType {
+		case protoVarint:
+			if value, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("field %d: invalid varint", number)
+			}
+
+			data = data[n:]
+
+		case protoFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("field %d: truncated", number)
+			}
+
+			value, data = binary.LittleEndian.Uint64(data), data[8:]
+
+		case protoFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("field %d: truncated", number)
+			}
+
+			value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+
+		case protoLengthDelimited:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return fmt.Errorf("field %d: truncated", number)
+			}
+
+			payload, data = data[n:n+int(length)], data[n+int(length):]
+
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", number, wireType)
+		}
+
+		if err := handle(number, wireType, value, payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (codec *ProtobufCodec) decodeMessage(data []byte, typeName string, depth int) (interface{}, error) {
+	if depth > maxProtobufDepth {
+		return nil, fmt.Errorf("messages nested more than %d deep", maxProtobufDepth)
+	}
+
+	if _, ok := protobufWellKnownTypes[typeName]; ok {
+		return codec.decodeWellKnownType(data, typeName, depth)
+	}
+
+	message, ok := codec.types.messages[typeName]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %q", typeName)
+	}
+
+	byNumber := make(map[int32]*descriptor.FieldDescriptorProto)
+	for _, field := range message.Field {
+		byNumber[field.GetNumber()] = field
+	}
+
+	fields := common.MapStr{}
+	err := readProtoFields(data, func(number int32, wireType int, value uint64, payload []byte) error {
+		field, ok := byNumber[number]
+		if !ok {
+			// unknown fields are dropped, as they are by the JSON mapping
+			return nil
+		}
+
+		name := codec.fieldName(field)
+		if field.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+			decoded, err := codec.decodeField(field, wireType, value, payload, depth)
+			if err != nil {
+				return fmt.Errorf("field %q: %v", field.GetName(), err)
+			}
+
+			fields[name] = decoded
+			return nil
+		}
+
+		if entry, ok := codec.types.messages[field.GetTypeName()]; ok && entry.GetOptions().GetMapEntry() {
+			return codec.decodeMapEntry(fields, name, entry, field, payload, depth)
+		}
+
+		list, _ := fields[name].([]interface{})
+
+		// packed scalars are a length-delimited run of values
+		if wireType == protoLengthDelimited && protoPackable(field.GetType()) {
+			packed, err := decodePackedProto(field, payload)
+			if err != nil {
+				return fmt.Errorf("field %q: %v", field.GetName(), err)
+			}
+
+			for _, item := range packed {
+				list = append(list, codec.scalarValue(field, item, nil))
+			}
+
+			fields[name] = list
+			return nil
+		}
+
+		decoded, err := codec.decodeField(field, wireType, value, payload, depth)
+		if err != nil {
+			return fmt.Errorf("field %q: %v", field.GetName(), err)
+		}
+
+		fields[name] = append(list, decoded)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if codec.config.EmitDefaults {
+		codec.addDefaults(fields, message)
+	}
+
+	return fields, nil
+}
+
+func (codec *ProtobufCodec) decodeMapEntry(fields common.MapStr, name string, entry *descriptor.DescriptorProto, field *descriptor.FieldDescriptorProto, payload []byte, depth int) error {
+	var keyField, valueField *descriptor.FieldDescriptorProto
+	for _, f := range entry.Field {
+		switch f.GetNumber() {
+		case 1:
+			keyField = f
+		case 2:
+			valueField = f
+		}
+	}
+
+	if keyField == nil || valueField == nil {
+		return fmt.Errorf("field %q: invalid map entry", field.GetName())
+	}
+
+	var key, value interface{}
+	err := readProtoFields(payload, func(number int32, wireType int, raw uint64, data []byte) error {
+		var err error
+		switch number {
+		case 1:
+			key, err = codec.decodeField(keyField, wireType, raw, data, depth)
+		case 2:
+			value, err = codec.decodeField(valueField, wireType, raw, data, depth)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("field %q: %v", field.GetName(), err)
+	}
+
+	// missing keys and values are the type's default
+	if key == nil {
+		key = codec.defaultValue(keyField)
+	}
+
+	if value == nil {
+		value = codec.defaultValue(valueField)
+	}
+
+	entries, ok := fields[name].(common.MapStr)
+	if !ok {
+		entries = common.MapStr{}
+		fields[name] = entries
+	}
+
+	entries[fmt.Sprintf("%v", key)] = value
+	return nil
+}
+
+func (codec *ProtobufCodec) decodeField(field *descriptor.FieldDescriptorProto, wireType int, value uint64, payload []byte, depth int) (interface{}, error) {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		if wireType != protoLengthDelimited {
+			return nil, fmt.Errorf("wire type %d for a message", wireType)
+		}
+
+		return codec.decodeMessage(payload, field.GetTypeName(), depth+1)
+
+	case descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return nil, errors.New("groups aren't supported")
+
+	case descriptor.FieldDescriptorProto_TYPE_STRING, descriptor.FieldDescriptorProto_TYPE_BYTES:
+		if wireType != protoLengthDelimited {
+			return nil, fmt.Errorf("wire type %d for a string", wireType)
+		}
+	}
+
+	return codec.scalarValue(field, value, payload), nil
+}
+
+// protoPackable is true for the scalar types that can be packed.
+func protoPackable(fieldType descriptor.FieldDescriptorProto_Type) bool {
+	switch fieldType {
+	case descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+
+	return true
+}
+
+func decodePackedProto(field *descriptor.FieldDescriptorProto, payload []byte) ([]uint64, error) {
+	var values []uint64
+	for len(payload) > 0 {
+		switch field.GetType() {
+		case descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+			descriptor.FieldDescriptorProto_TYPE_FIXED64,
+			descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+			if len(payload) < 8 {
+				return nil, errors.New("truncated packed values")
+			}
+
+			values = append(values, binary.LittleEndian.Uint64(payload))
+			payload = payload[8:]
+
+		case descriptor.FieldDescriptorProto_TYPE_FLOAT,
+			descriptor.FieldDescriptorProto_TYPE_FIXED32,
+			descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+			if len(payload) < 4 {
+				return nil, errors.New("truncated packed values")
+			}
+
+			values = append(values, uint64(binary.LittleEndian.Uint32(payload)))
+			payload = payload[4:]
+
+		default:
+			value, n := binary.Uvarint(payload)
+			if n <= 0 {
+				return nil, errors.New("invalid packed varint")
+			}
+
+			values = append(values, value)
+			payload = payload[n:]
+		}
+	}
+
+	return values, nil
+}
+
+// scalarValue converts a wire value to its JSON mapping: 64 bit integers are
+// strings, bytes are base64 and enums are their names.
+func (codec *ProtobufCodec) scalarValue(field *descriptor.FieldDescriptorProto, value uint64, payload []byte) interface{} {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return protoFloat(math.Float64frombits(value))
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return protoFloat(float64(math.Float32frombits(uint32(value))))
+	case descriptor.FieldDescriptorProto_TYPE_INT64, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return strconv.FormatInt(int64(value), 10)
+	case descriptor.FieldDescriptorProto_TYPE_UINT64, descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		return strconv.FormatUint(value, 10)
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return strconv.FormatInt(int64(value>>1)^-int64(value&1), 10)
+	case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return int32(value)
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		return int32(uint32(value)>>1) ^ -int32(value&1)
+	case descriptor.FieldDescriptorProto_TYPE_UINT32, descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return uint32(value)
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return value != 0
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return string(payload)
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return base64.StdEncoding.EncodeToString(payload)
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		return codec.enumName(field.GetTypeName(), int32(value))
+	}
+
+	return nil
+}
+
+func (codec *ProtobufCodec) enumName(typeName string, number int32) interface{} {
+	if typeName == ".google.protobuf.NullValue" {
+		return nil
+	}
+
+	if enum, ok := codec.types.enums[typeName]; ok {
+		for _, value := range enum.Value {
+			if value.GetNumber() == number {
+				return value.GetName()
+			}
+		}
+	}
+
+	// unknown values are kept as numbers
+	return number
+}
+
+// protoFloat spells out the values JSON numbers can't hold.
+func protoFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+
+	return f
+}
+
+func (codec *ProtobufCodec) fieldName(field *descriptor.FieldDescriptorProto) string {
+	if codec.config.UseProtoNames {
+		return field.GetName()
+	}
+
+	if field.GetJsonName() != "" {
+		return field.GetJsonName()
+	}
+
+	return protoCamelCase(field.GetName())
+}
+
+// protoCamelCase converts snake_case to lowerCamelCase like protoc does for
+// json_name.
+func protoCamelCase(name string) string {
+	var out []byte
+	upper := false
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && 'a' <= c && c <= 'z':
+			out = append(out, c-'a'+'A')
+			upper = false
+		default:
+			out = append(out, c)
+			upper = false
+		}
+	}
+
+	return string(out)
+}
+
+func (codec *ProtobufCodec) addDefaults(fields common.MapStr, message *descriptor.DescriptorProto) {
+	for _, field := range message.Field {
+		name := codec.fieldName(field)
+		if _, ok := fields[name]; ok || field.OneofIndex != nil {
+			continue
+		}
+
+		fields[name] = codec.defaultValue(field)
+	}
+}
+
+func (codec *ProtobufCodec) defaultValue(field *descriptor.FieldDescriptorProto) interface{} {
+	if field.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		if entry, ok := codec.types.messages[field.GetTypeName()]; ok && entry.GetOptions().GetMapEntry() {
+			return common.MapStr{}
+		}
+
+		return []interface{}{}
+	}
+
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return nil
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		if enum, ok := codec.types.enums[field.GetTypeName()]; ok && len(enum.Value) > 0 {
+			return codec.enumName(field.GetTypeName(), enum.Value[0].GetNumber())
+		}
+
+		return int32(0)
+	}
+
+	return codec.scalarValue(field, 0, nil)
+}
+
+// protobufWellKnownTypes have a special JSON mapping, their value is the type
+// of the wrapped value for wrappers.
+var protobufWellKnownTypes = map[string]descriptor.FieldDescriptorProto_Type{
+	".google.protobuf.Timestamp":   0,
+	".google.protobuf.Duration":    0,
+	".google.protobuf.Struct":      0,
+	".google.protobuf.Value":       0,
+	".google.protobuf.ListValue":   0,
+	".google.protobuf.FieldMask":   0,
+	".google.protobuf.Empty":       0,
+	".google.protobuf.Any":         0,
+	".google.protobuf.DoubleValue": descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+	".google.protobuf.FloatValue":  descriptor.FieldDescriptorProto_TYPE_FLOAT,
+	".google.protobuf.Int64Value":  descriptor.FieldDescriptorProto_TYPE_INT64,
+	".google.protobuf.UInt64Value": descriptor.FieldDescriptorProto_TYPE_UINT64,
+	".google.protobuf.Int32Value":  descriptor.FieldDescriptorProto_TYPE_INT32,
+	".google.protobuf.UInt32Value": descriptor.FieldDescriptorProto_TYPE_UINT32,
+	".google.protobuf.BoolValue":   descriptor.FieldDescriptorProto_TYPE_BOOL,
+	".google.protobuf.StringValue": descriptor.FieldDescriptorProto_TYPE_STRING,
+	".google.protobuf.BytesValue":  descriptor.FieldDescriptorProto_TYPE_BYTES,
+}
+
+func (codec *ProtobufCodec) decodeWellKnownType(data []byte, typeName string, depth int) (interface{}, error) {
+	// the field layouts are fixed so they don't need to be in the descriptor set
+	var seconds, nanos int64
+	var strs []string
+	var values []interface{}
+	var wrapped interface{}
+	var payload []byte
+	structFields := common.MapStr{}
+
+	err := readProtoFields(data, func(number int32, wireType int, value uint64, field []byte) error {
+		switch typeName {
+		case ".google.protobuf.Timestamp", ".google.protobuf.Duration":
+			if number == 1 {
+				seconds = int64(value)
+			} else if number == 2 {
+				nanos = int64(int32(value))
+			}
+
+		case ".google.protobuf.FieldMask":
+			if number == 1 {
+				strs = append(strs, string(field))
+			}
+
+		case ".google.protobuf.Any":
+			if number == 1 {
+				strs = append(strs, string(field))
+			} else if number == 2 {
+				payload = field
+			}
+
+		case ".google.protobuf.Struct":
+			if number != 1 {
+				return nil
+			}
+
+			var key string
+			var entry interface{}
+			err := readProtoFields(field, func(number int32, _ int, _ uint64, field []byte) error {
+				var err error
+				if number == 1 {
+					key = string(field)
+				} else if number == 2 {
+					entry, err = codec.decodeWellKnownType(field, ".google.protobuf.Value", depth+1)
+				}
+
+				return err
+			})
+
+			if err != nil {
+				return err
+			}
+
+			structFields[key] = entry
+
+		case ".google.protobuf.ListValue":
+			if number != 1 {
+				return nil
+			}
+
+			item, err := codec.decodeWellKnownType(field, ".google.protobuf.Value", depth+1)
+			if err != nil {
+				return err
+			}
+
+			values = append(values, item)
+
+		case ".google.protobuf.Value":
+			var err error
+			switch number {
+			case 1:
+				wrapped = nil
+			case 2:
+				wrapped = protoFloat(math.Float64frombits(value))
+			case 3:
+				wrapped = string(field)
+			case 4:
+				wrapped = value != 0
+			case 5:
+				wrapped, err = codec.decodeWellKnownType(field, ".google.protobuf.Struct", depth+1)
+			case 6:
+				wrapped, err = codec.decodeWellKnownType(field, ".google.protobuf.ListValue", depth+1)
+			}
+
+			return err
+
+		default:
+			if number == 1 {
+				wrapped = codec.scalarValue(&descriptor.FieldDescriptorProto{Type: protobufWellKnownTypes[typeName].Enum()}, value, field)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch typeName {
+	case ".google.protobuf.Timestamp":
+		return formatProtoTimestamp(seconds, nanos), nil
+
+	case ".google.protobuf.Duration":
+		return formatProtoDuration(seconds, nanos), nil
+
+	case ".google.protobuf.FieldMask":
+		for i, path := range strs {
+			strs[i] = protoCamelCase(path)
+		}
+
+		return strings.Join(strs, ","), nil
+
+	case ".google.protobuf.Empty", ".google.protobuf.Struct":
+		return structFields, nil
+
+	case ".google.protobuf.ListValue":
+		if values == nil {
+			values = []interface{}{}
+		}
+
+		return values, nil
+
+	case ".google.protobuf.Any":
+		return codec.decodeAny(strs, payload, depth)
+	}
+
+	if wrapped == nil && typeName != ".google.protobuf.Value" {
+		// an unset wrapped value is the default
+		return codec.scalarValue(&descriptor.FieldDescriptorProto{Type: protobufWellKnownTypes[typeName].Enum()}, 0, nil), nil
+	}
+
+	return wrapped, nil
+}
+
+// decodeAny adds the "@type" to the embedded message, well-known types go
+// under "value".
+func (codec *ProtobufCodec) decodeAny(typeURL []string, payload []byte, depth int) (interface{}, error) {
+	if len(typeURL) == 0 {
+		return common.MapStr{}, nil
+	}
+
+	url := typeURL[len(typeURL)-1]
+	typeName := "." + url[strings.LastIndex(url, "/")+1:]
+
+	_, known := codec.types.messages[typeName]
+	_, wellKnown := protobufWellKnownTypes[typeName]
+	if !known && !wellKnown {
+		return nil, fmt.Errorf("Any holds %q which isn't in the descriptor set", url)
+	}
+
+	decoded, err := codec.decodeMessage(payload, typeName, depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, ok := decoded.(common.MapStr)
+	if wellKnown || !ok {
+		fields = common.MapStr{"value": decoded}
+	}
+
+	fields["@type"] = url
+	return fields, nil
+}
+
+// formatProtoTimestamp uses RFC 3339 in UTC with 0, 3, 6 or 9 fractional digits.
+func formatProtoTimestamp(seconds, nanos int64) string {
+	t := time.Unix(seconds, nanos).UTC()
+	layout := "2006-01-02T15:04:05"
+	switch {
+	case nanos == 0:
+	case nanos%1e6 == 0:
+		layout += ".000"
+	case nanos%1e3 == 0:
+		layout += ".000000"
+	default:
+		layout += ".000000000"
+	}
+
+	return t.Format(layout + "Z")
+}
+
+// formatProtoDuration formats seconds with 0, 3, 6 or 9 fractional digits and an s suffix.
+func formatProtoDuration(seconds, nanos int64) string {
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign = "-"
+		seconds, nanos = -seconds, -nanos
+	}
+
+	out := sign + strconv.FormatInt(seconds, 10)
+	switch {
+	case nanos == 0:
+	case nanos%1e6 == 0:
+		out += fmt.Sprintf(".%03d", nanos/1e6)
+	case nanos%1e3 == 0:
+		out += fmt.Sprintf(".%06d", nanos/1e3)
+	default:
+		out += fmt.Sprintf(".%09d", nanos)
+	}
+
+	return out + "s"
+}
+
+func (codec *ProtobufCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *ProtobufCodec) Err() error {
+	return codec.err
+}
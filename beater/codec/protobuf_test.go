@@ -0,0 +1,387 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func protoField(name string, number int32, fieldType descriptor.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptor.FieldDescriptorProto {
+	label := descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptor.FieldDescriptorProto_LABEL_REPEATED
+	}
+
+	field := &descriptor.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  label.Enum(),
+		Type:   fieldType.Enum(),
+	}
+
+	if typeName != "" {
+		field.TypeName = proto.String(typeName)
+	}
+
+	return field
+}
+
+// testDescriptorSet writes a descriptor set for
+//
+//	package example;
+//	enum Level { UNKNOWN = 0; INFO = 1; ERROR = 2; }
+//	message Event {
+//	  message Inner { string value = 1; }
+//	  string name = 1; int64 count = 2; repeated int32 codes = 3;
+//	  Level level = 4; map<string, int32> labels = 5;
+//	  google.protobuf.Timestamp time = 6; Inner inner = 7; bytes data = 8;
+//	  double score = 9; sint32 delta = 10; string user_id = 11;
+//	  google.protobuf.Duration took = 12; google.protobuf.Int64Value big = 13;
+//	}
+func testDescriptorSet(t *testing.T, dir string) string {
+	set := &descriptor.FileDescriptorSet{
+		File: []*descriptor.FileDescriptorProto{{
+			Name:    proto.String("example.proto"),
+			Package: proto.String("example"),
+			EnumType: []*descriptor.EnumDescriptorProto{{
+				Name: proto.String("Level"),
+				Value: []*descriptor.EnumValueDescriptorProto{
+					{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("INFO"), Number: proto.Int32(1)},
+					{Name: proto.String("ERROR"), Number: proto.Int32(2)},
+				},
+			}},
+			MessageType: []*descriptor.DescriptorProto{{
+				Name: proto.String("Event"),
+				Field: []*descriptor.FieldDescriptorProto{
+					protoField("name", 1, descriptor.FieldDescriptorProto_TYPE_STRING, "", false),
+					protoField("count", 2, descriptor.FieldDescriptorProto_TYPE_INT64, "", false),
+					protoField("codes", 3, descriptor.FieldDescriptorProto_TYPE_INT32, "", true),
+					protoField("level", 4, descriptor.FieldDescriptorProto_TYPE_ENUM, ".example.Level", false),
+					protoField("labels", 5, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".example.Event.LabelsEntry", true),
+					protoField("time", 6, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp", false),
+					protoField("inner", 7, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".example.Event.Inner", false),
+					protoField("data", 8, descriptor.FieldDescriptorProto_TYPE_BYTES, "", false),
+					protoField("score", 9, descriptor.FieldDescriptorProto_TYPE_DOUBLE, "", false),
+					protoField("delta", 10, descriptor.FieldDescriptorProto_TYPE_SINT32, "", false),
+					protoField("user_id", 11, descriptor.FieldDescriptorProto_TYPE_STRING, "", false),
+					protoField("took", 12, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Duration", false),
+					protoField("big", 13, descriptor.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Int64Value", false),
+				},
+				NestedType: []*descriptor.DescriptorProto{
+					{
+						Name: proto.String("LabelsEntry"),
+						Field: []*descriptor.FieldDescriptorProto{
+							protoField("key", 1, descriptor.FieldDescriptorProto_TYPE_STRING, "", false),
+							protoField("value", 2, descriptor.FieldDescriptorProto_TYPE_INT32, "", false),
+						},
+						Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+					{
+						Name: proto.String("Inner"),
+						Field: []*descriptor.FieldDescriptorProto{
+							protoField("value", 1, descriptor.FieldDescriptorProto_TYPE_STRING, "", false),
+						},
+					},
+				},
+			}},
+		}},
+	}
+
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "example.desc")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func protoVarintField(number int, value uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*2)
+	n := binary.PutUvarint(buf, uint64(number<<3|protoVarint))
+	n += binary.PutUvarint(buf[n:], value)
+	return buf[:n]
+}
+
+func protoBytesField(number int, value []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*2)
+	n := binary.PutUvarint(buf, uint64(number<<3|protoLengthDelimited))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	return append(buf[:n], value...)
+}
+
+// protoDelimited prefixes each message with its length.
+func protoDelimited(messages ...[]byte) []byte {
+	var out []byte
+	for _, message := range messages {
+		buf := make([]byte, binary.MaxVarintLen64)
+		out = append(out, buf[:binary.PutUvarint(buf, uint64(len(message)))]...)
+		out = append(out, message...)
+	}
+
+	return out
+}
+
+func TestProtobufCodecInvalidOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protobuf-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptorSet := testDescriptorSet(t, dir)
+	garbage := filepath.Join(dir, "garbage.desc")
+	if err := ioutil.WriteFile(garbage, []byte{0xff, 0xff}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]map[string]interface{}{
+		"no options":      nil,
+		"no message type": {"descriptor_set": descriptorSet},
+		"missing file":    {"descriptor_set": filepath.Join(dir, "missing"), "message_type": "example.Event"},
+		"unknown type":    {"descriptor_set": descriptorSet, "message_type": "example.Missing"},
+		"not a set":       {"descriptor_set": garbage, "message_type": "example.Event"},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewProtobufCodec(testOptions(t, tc), "testfile", bytes.NewReader(nil))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestProtobufCodecNextErr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protobuf-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := testOptions(t, map[string]interface{}{
+		"descriptor_set": testDescriptorSet(t, dir),
+		"message_type":   ".example.Event",
+	})
+
+	message := protoBytesField(1, []byte("a"))
+	cases := map[string]struct {
+		Data      []byte
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   nil,
+			Length: 0,
+		},
+		"messages": {
+			Data:   protoDelimited(message, nil, message),
+			Length: 3,
+		},
+		"truncated message": {
+			Data:      protoDelimited(message, message)[:5],
+			Length:    1,
+			ExpectErr: true,
+		},
+		"truncated field": {
+			Data:      protoDelimited(message[:2]),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"group": {
+			Data:      protoDelimited([]byte{1<<3 | 3}),
+			Length:    0,
+			ExpectErr: true,
+		},
+		"huge length": {
+			Data:      []byte{0xff, 0xff, 0xff, 0xff, 0x0f},
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewProtobufCodec(options, "testfile", bytes.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d messages, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestProtobufCodecValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protobuf-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	descriptorSet := testDescriptorSet(t, dir)
+
+	score := make([]byte, 9)
+	score[0] = 9<<3 | protoFixed64
+	binary.LittleEndian.PutUint64(score[1:], math.Float64bits(math.Inf(1)))
+
+	full := concat(
+		protoBytesField(1, []byte("login")),
+		protoVarintField(2, math.MaxUint64), // -1
+		protoBytesField(3, []byte{1, 2}),
+		protoVarintField(3, 3),
+		protoVarintField(4, 2),
+		protoBytesField(5, concat(protoBytesField(1, []byte("a")), protoVarintField(2, 1))),
+		protoBytesField(5, protoBytesField(1, []byte("b"))),
+		protoBytesField(6, concat(protoVarintField(1, 1500000000), protoVarintField(2, 5000000))),
+		protoBytesField(7, protoBytesField(1, []byte("x"))),
+		protoBytesField(8, []byte("hi")),
+		score,
+		protoVarintField(10, 3), // -2
+		protoBytesField(11, []byte("u1")),
+		protoBytesField(12, concat(protoVarintField(1, 1), protoVarintField(2, 500000000))),
+		protoBytesField(13, protoVarintField(1, 7)),
+		protoVarintField(99, 1), // unknown
+	)
+
+	cases := map[string]struct {
+		Data     []byte
+		Options  map[string]interface{}
+		Expected []common.MapStr
+	}{
+		"json names": {
+			Data: protoDelimited(full),
+			Expected: []common.MapStr{{
+				"name":   "login",
+				"count":  "-1",
+				"codes":  []interface{}{int32(1), int32(2), int32(3)},
+				"level":  "ERROR",
+				"labels": common.MapStr{"a": int32(1), "b": int32(0)},
+				"time":   "2017-07-14T02:40:00.005Z",
+				"inner":  common.MapStr{"value": "x"},
+				"data":   "aGk=",
+				"score":  "Infinity",
+				"delta":  int32(-2),
+				"userId": "u1",
+				"took":   "1.500s",
+				"big":    "7",
+			}},
+		},
+		"proto names and defaults": {
+			Data:    protoDelimited(protoBytesField(11, []byte("u2"))),
+			Options: map[string]interface{}{"use_proto_names": true, "emit_defaults": true},
+			Expected: []common.MapStr{{
+				"name":    "",
+				"count":   "0",
+				"codes":   []interface{}{},
+				"level":   "UNKNOWN",
+				"labels":  common.MapStr{},
+				"time":    nil,
+				"inner":   nil,
+				"data":    "",
+				"score":   float64(0),
+				"delta":   int32(0),
+				"user_id": "u2",
+				"took":    nil,
+				"big":     nil,
+			}},
+		},
+	}
+
+	for tn, tc := range cases {
+		options := map[string]interface{}{
+			"descriptor_set": descriptorSet,
+			"message_type":   "example.Event",
+		}
+
+		for k, v := range tc.Options {
+			options[k] = v
+		}
+
+		c, err := NewProtobufCodec(testOptions(t, options), "testfile", bytes.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		for i, expectedFields := range tc.Expected {
+			if !c.Next() {
+				t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+			}
+
+			expected := common.MapStr{
+				"protobuf": expectedFields,
+				"file":     "testfile",
+				"line":     i + 1,
+			}
+
+			expectedS := fmt.Sprintf("%v", expected)
+			actualS := fmt.Sprintf("%v", c.Value())
+			if expectedS != actualS {
+				t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+			}
+		}
+
+		if c.Next() || c.Err() != nil {
+			t.Errorf("%q | Expected the end of the file, got %v", tn, c.Err())
+		}
+	}
+}
+
+func TestFormatProtoDuration(t *testing.T) {
+	cases := map[string]struct {
+		Seconds  int64
+		Nanos    int64
+		Expected string
+	}{
+		"whole":    {Seconds: 3, Expected: "3s"},
+		"millis":   {Seconds: 1, Nanos: 10000000, Expected: "1.010s"},
+		"micros":   {Seconds: 0, Nanos: 1000, Expected: "0.000001s"},
+		"nanos":    {Seconds: 0, Nanos: 1, Expected: "0.000000001s"},
+		"negative": {Seconds: -1, Nanos: -500000000, Expected: "-1.500s"},
+	}
+
+	for tn, tc := range cases {
+		actual := formatProtoDuration(tc.Seconds, tc.Nanos)
+		if actual != tc.Expected {
+			t.Errorf("%q | Expected %q, got %q", tn, tc.Expected, actual)
+		}
+	}
+}
@@ -0,0 +1,585 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const (
+	SyslogFormatAuto    = "auto"
+	SyslogFormatRfc3164 = "rfc3164"
+	SyslogFormatRfc5424 = "rfc5424"
+
+	// the BSD timestamp has no year and pads single digit days with a space
+	bsdTimestampLayout = "Jan _2 15:04:05"
+
+	syslogNilValue = "-"
+
+	// maxSyslogFrameLength protects against allocating huge buffers for
+	// corrupt octet counts, larger frames are skipped.
+	maxSyslogFrameLength = 1024 * 1024
+)
+
+var (
+	syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+	syslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+		"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+)
+
+// SyslogConfig holds the options for the syslog codec.
+type SyslogConfig struct {
+	// Format forces the message format, by default it's detected per message.
+	Format string `config:"format"`
+
+	// Timezone is the location of RFC 3164 timestamps which don't include an offset.
+	Timezone string `config:"timezone"`
+
+	// Year is used for RFC 3164 timestamps which don't include one. If zero the
+	// most recent year that doesn't put the timestamp in the future is used.
+	Year int `config:"year"`
+}
+
+var defaultSyslogConfig = SyslogConfig{
+	Format:   SyslogFormatAuto,
+	Timezone: "UTC",
+}
+
+func (c *SyslogConfig) Validate() error {
+	switch c.Format {
+	case SyslogFormatAuto, SyslogFormatRfc3164, SyslogFormatRfc5424:
+	default:
+		return fmt.Errorf("syslog format must be one of %q, %q or %q, got %q",
+			SyslogFormatAuto, SyslogFormatRfc3164, SyslogFormatRfc5424, c.Format)
+	}
+
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("invalid syslog timezone: %v", err)
+	}
+
+	if c.Year < 0 {
+		return fmt.Errorf("syslog year must not be negative, got %d", c.Year)
+	}
+
+	return nil
+}
+
+func newSyslogConfig(options *common.Config) (*SyslogConfig, error) {
+	config, err := unpackCodecConfig("syslog", defaultSyslogConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*SyslogConfig), nil
+}
+
+func init() {
+	Register(SyslogCodecId, NewSyslogCodec, defaultSyslogConfig)
+}
+
+func NewSyslogCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newSyslogConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SyslogCodec{
+		reader:   bufio.NewReader(input),
+		config:   config,
+		location: location,
+		now:      time.Now,
+		nextLine: 1,
+		path:     path,
+	}, nil
+}
+
+// SyslogCodec reads RFC 3164 (BSD) and RFC 5424 syslog messages. Messages
+// may be newline delimited or use octet-counted framing as described in
+// RFC 6587. The line is the line of the file the message starts on.
+type SyslogCodec struct {
+	reader   *bufio.Reader
+	config   *SyslogConfig
+	location *time.Location
+	now      func() time.Time
+	value    common.MapStr
+	err      error
+	bad      []byte
+	badLine  int
+	nextLine int
+	path     string
+}
+
+func (codec *SyslogCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	lineNumber, message, err := codec.readMessage()
+	if err != nil {
+		codec.err = err
+		return false
+	}
+
+	fields, err := codec.parse(message)
+	if err != nil {
+		codec.err = fmt.Errorf("line %d: %v", lineNumber, err)
+		codec.bad, codec.badLine = []byte(message), lineNumber
+		return false
+	}
+
+	codec.value = common.MapStr{
+		"syslog": fields,
+		"event":  message,
+		"file":   codec.path,
+		"line":   lineNumber,
+	}
+
+	return true
+}
+
+// readMessage reads the next frame skipping blank lines.
+func (codec *SyslogCodec) readMessage() (int, string, error) {
+	for {
+		next, err := codec.reader.Peek(1)
+		if err != nil {
+			return 0, "", err
+		}
+
+		if next[0] != '\n' && next[0] != '\r' {
+			break
+		}
+
+		codec.reader.ReadByte()
+		if next[0] == '\n' {
+			codec.nextLine++
+		}
+	}
+
+	lineNumber := codec.nextLine
+
+	if length, headerLength, ok := codec.peekOctetCount(); ok {
+		codec.reader.Discard(headerLength)
+
+		if length > maxSyslogFrameLength {
+			return 0, "", codec.skipFrame(lineNumber, length)
+		}
+
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(codec.reader, frame); err != nil {
+			return 0, "", fmt.Errorf("line %d: truncated message, expected %d bytes: %v", lineNumber, length, err)
+		}
+
+		codec.nextLine += bytes.Count(frame, []byte("\n"))
+		return lineNumber, strings.TrimRight(string(frame), "\r\n"), nil
+	}
+
+	line, err := codec.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+
+	if strings.HasSuffix(line, "\n") {
+		codec.nextLine++
+	}
+
+	return lineNumber, strings.TrimRight(line, "\r\n"), nil
+}
+
+// skipFrame discards an octet-counted frame that's too large to read, the
+// start of it is kept so it can be recovered as an error event.
+func (codec *SyslogCodec) skipFrame(lineNumber, length int) error {
+	codec.bad, codec.badLine = []byte{}, lineNumber
+
+	for remaining := length; remaining > 0; {
+		size := remaining
+		if size > codec.reader.Size() {
+			size = codec.reader.Size()
+		}
+
+		chunk, err := codec.reader.Peek(size)
+		if len(codec.bad) < maxErrorRawLength {
+			codec.bad = append(codec.bad, chunk...)
+		}
+
+		codec.reader.Discard(len(chunk))
+		codec.nextLine += bytes.Count(chunk, []byte("\n"))
+		remaining -= len(chunk)
+
+		// the file ending early is reported as the frame being too large
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			codec.bad = nil
+			return err
+		}
+	}
+
+	return fmt.Errorf("line %d: message of %d bytes is larger than the %d byte limit",
+		lineNumber, length, maxSyslogFrameLength)
+}
+
+// peekOctetCount checks whether the next frame starts with a message length
+// e.g. "52 <34>1 ...", the length is only trusted if a PRI follows it.
+func (codec *SyslogCodec) peekOctetCount() (length int, headerLength int, ok bool) {
+	// Peek returns what it can alongside the error if there are fewer bytes
+	header, _ := codec.reader.Peek(12)
+
+	digits := 0
+	for digits < len(header) && header[digits] >= '0' && header[digits] <= '9' {
+		digits++
+	}
+
+	if digits == 0 || digits+1 >= len(header) || header[digits] != ' ' || header[digits+1] != '<' {
+		return 0, 0, false
+	}
+
+	length, err := strconv.Atoi(string(header[:digits]))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return length, digits + 1, true
+}
+
+func (codec *SyslogCodec) parse(message string) (common.MapStr, error) {
+	fields := common.MapStr{}
+
+	rest, err := parseSyslogPriority(message, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	format := codec.config.Format
+	if format == SyslogFormatAuto {
+		format = detectSyslogFormat(rest)
+	}
+
+	if format == SyslogFormatRfc5424 {
+		err = parseRfc5424(rest, fields)
+	} else {
+		err = codec.parseRfc3164(rest, fields)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// parseSyslogPriority reads an optional <PRI> header and returns the remainder
+// of the message. Messages written to files by syslog daemons usually omit it.
+func parseSyslogPriority(message string, fields common.MapStr) (string, error) {
+	if !strings.HasPrefix(message, "<") {
+		return message, nil
+	}
+
+	end := strings.IndexByte(message, '>')
+	if end < 2 || end > 4 {
+		return "", errors.New("invalid priority")
+	}
+
+	priority, err := strconv.Atoi(message[1:end])
+	if err != nil || priority < 0 || priority > 191 {
+		return "", fmt.Errorf("invalid priority %q", message[1:end])
+	}
+
+	facility := priority / 8
+	severity := priority % 8
+
+	fields["priority"] = priority
+	fields["facility"] = facility
+	fields["facility_label"] = syslogFacilities[facility]
+	fields["severity"] = severity
+	fields["severity_label"] = syslogSeverities[severity]
+
+	return message[end+1:], nil
+}
+
+// detectSyslogFormat checks for the RFC 5424 version number after the PRI.
+func detectSyslogFormat(rest string) string {
+	space := strings.IndexByte(rest, ' ')
+	if space <= 0 || space > 2 {
+		return SyslogFormatRfc3164
+	}
+
+	for _, c := range rest[:space] {
+		if c < '0' || c > '9' {
+			return SyslogFormatRfc3164
+		}
+	}
+
+	return SyslogFormatRfc5424
+}
+
+// nextSyslogToken splits off the next space delimited token.
+func nextSyslogToken(s string) (token, rest string) {
+	if space := strings.IndexByte(s, ' '); space >= 0 {
+		return s[:space], s[space+1:]
+	}
+
+	return s, ""
+}
+
+func putSyslogToken(fields common.MapStr, key, token string) {
+	if token != syslogNilValue && token != "" {
+		fields[key] = token
+	}
+}
+
+// parseRfc5424 parses:
+// VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func parseRfc5424(rest string, fields common.MapStr) error {
+	var version, timestamp, hostname, appName, procID, msgID string
+	version, rest = nextSyslogToken(rest)
+	timestamp, rest = nextSyslogToken(rest)
+	hostname, rest = nextSyslogToken(rest)
+	appName, rest = nextSyslogToken(rest)
+	procID, rest = nextSyslogToken(rest)
+	msgID, rest = nextSyslogToken(rest)
+
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return fmt.Errorf("invalid version %q", version)
+	}
+
+	fields["version"] = v
+
+	if timestamp != syslogNilValue {
+		ts, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+
+		fields["timestamp"] = ts
+	}
+
+	putSyslogToken(fields, "hostname", hostname)
+	putSyslogToken(fields, "app_name", appName)
+	putSyslogToken(fields, "procid", procID)
+	putSyslogToken(fields, "msgid", msgID)
+
+	if rest == "" {
+		return errors.New("missing structured data")
+	}
+
+	data, rest, err := parseStructuredData(rest)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		fields["structured_data"] = data
+	}
+
+	if rest != "" {
+		if rest[0] != ' ' {
+			return errors.New("expected a space after the structured data")
+		}
+
+		// the message may be marked as UTF-8 with a byte order mark
+		rest = strings.TrimPrefix(rest[1:], "\xEF\xBB\xBF")
+		if rest != "" {
+			fields["message"] = rest
+		}
+	}
+
+	return nil
+}
+
+// parseStructuredData parses either the nil value or a sequence of elements
+// like [id param="value" ...] returning them keyed by element then param ID.
+func parseStructuredData(s string) (common.MapStr, string, error) {
+	data := common.MapStr{}
+	if strings.HasPrefix(s, syslogNilValue) {
+		return data, s[1:], nil
+	}
+
+	for strings.HasPrefix(s, "[") {
+		end := strings.IndexAny(s, " ]")
+		if end < 0 {
+			return nil, "", errors.New("unterminated structured data element")
+		}
+
+		id := s[1:end]
+		if id == "" {
+			return nil, "", errors.New("structured data element is missing an ID")
+		}
+
+		params := common.MapStr{}
+		s = s[end:]
+
+		for strings.HasPrefix(s, " ") {
+			eq := strings.Index(s, `="`)
+			if eq < 0 {
+				return nil, "", fmt.Errorf("invalid parameter in structured data element %q", id)
+			}
+
+			name := s[1:eq]
+			value, rest, err := parseSyslogParamValue(s[eq+2:])
+			if err != nil {
+				return nil, "", fmt.Errorf("structured data element %q: %v", id, err)
+			}
+
+			params[name] = value
+			s = rest
+		}
+
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("unterminated structured data element %q", id)
+		}
+
+		data[id] = params
+		s = s[1:]
+	}
+
+	if len(data) == 0 {
+		return nil, "", errors.New("invalid structured data")
+	}
+
+	return data, s, nil
+}
+
+// parseSyslogParamValue reads a quoted value, the opening quote has already
+// been consumed. The characters ", \ and ] may be escaped with a backslash.
+func parseSyslogParamValue(s string) (string, string, error) {
+	var value bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return value.String(), s[i+1:], nil
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+			}
+		}
+
+		value.WriteByte(s[i])
+	}
+
+	return "", "", errors.New("unterminated parameter value")
+}
+
+// parseRfc3164 parses: TIMESTAMP SP [HOSTNAME SP] TAG[PID]: MSG
+// where the timestamp is either in BSD format or RFC 3339 as written by rsyslog.
+func (codec *SyslogCodec) parseRfc3164(rest string, fields common.MapStr) error {
+	if len(rest) >= len(bsdTimestampLayout) {
+		if ts, err := time.ParseInLocation(bsdTimestampLayout, rest[:len(bsdTimestampLayout)], codec.location); err == nil {
+			fields["timestamp"] = codec.withYear(ts)
+			rest = strings.TrimPrefix(rest[len(bsdTimestampLayout):], " ")
+		}
+	}
+
+	if _, ok := fields["timestamp"]; !ok {
+		token, remainder := nextSyslogToken(rest)
+		ts, err := time.Parse(time.RFC3339Nano, token)
+		if err != nil {
+			return errors.New("missing timestamp")
+		}
+
+		fields["timestamp"] = ts
+		rest = remainder
+	}
+
+	// the hostname is optional, if the first token looks like a tag there isn't one
+	token, remainder := nextSyslogToken(rest)
+	if !strings.HasSuffix(token, ":") && !strings.Contains(token, "[") {
+		putSyslogToken(fields, "hostname", token)
+		rest = remainder
+	}
+
+	token, remainder = nextSyslogToken(rest)
+	if strings.HasSuffix(token, ":") {
+		tag := strings.TrimSuffix(token, ":")
+
+		if open := strings.IndexByte(tag, '['); open >= 0 && strings.HasSuffix(tag, "]") {
+			fields["procid"] = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+
+		putSyslogToken(fields, "app_name", tag)
+		rest = remainder
+	}
+
+	if rest != "" {
+		fields["message"] = rest
+	}
+
+	return nil
+}
+
+// withYear sets the year of a BSD timestamp. Without a configured year the
+// current one is used unless that would put the message more than a day in
+// the future, in which case it's assumed to be from the previous year.
+func (codec *SyslogCodec) withYear(ts time.Time) time.Time {
+	year := codec.config.Year
+	if year == 0 {
+		now := codec.now().In(codec.location)
+		year = now.Year()
+
+		if setSyslogYear(ts, year).After(now.Add(24 * time.Hour)) {
+			year--
+		}
+	}
+
+	return setSyslogYear(ts, year)
+}
+
+func setSyslogYear(ts time.Time, year int) time.Time {
+	return time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), ts.Nanosecond(), ts.Location())
+}
+
+func (codec *SyslogCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *SyslogCodec) Err() error {
+	// EOF is the expected end of the file
+	if codec.err == io.EOF {
+		return nil
+	}
+
+	return codec.err
+}
+
+// Recover skips the line that couldn't be parsed.
+func (codec *SyslogCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.badLine, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
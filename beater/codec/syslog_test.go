@@ -0,0 +1,256 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestSyslogCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"unknown format":   {"format": "rfc9999"},
+		"unknown timezone": {"timezone": "Mars/Olympus_Mons"},
+		"negative year":    {"year": -1},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewSyslogCodec(testOptions(t, tc), "testfile", strings.NewReader(""))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestSyslogCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Options   map[string]interface{}
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   "",
+			Length: 0,
+		},
+		"blank lines": {
+			Data:   "\n\r\nJan  2 15:04:05 host app: one\n\nJan  2 15:04:05 host app: two\n",
+			Length: 2,
+		},
+		"mixed formats": {
+			Data:   "<34>Oct 11 22:14:15 mymachine su: 'su root' failed\n<165>1 2003-10-11T22:14:15.003Z host app - - - hi\n",
+			Length: 2,
+		},
+		"octet counted": {
+			Data:   "33 <13>1 - host app 1 - - multi\nline29 <13>1 - host app 2 - - second\n",
+			Length: 2,
+		},
+		"truncated frame": {
+			Data:      "99 <13>1 - host app 1 - - short",
+			Length:    0,
+			ExpectErr: true,
+		},
+		"bad priority": {
+			Data:      "<192>Oct 11 22:14:15 host app: msg",
+			Length:    0,
+			ExpectErr: true,
+		},
+		"missing timestamp": {
+			Data:      "Jan  2 15:04:05 host app: ok\nnot syslog at all",
+			Length:    1,
+			ExpectErr: true,
+		},
+		"forced format": {
+			Data:      "<34>Oct 11 22:14:15 mymachine su: 'su root' failed\n",
+			Options:   map[string]interface{}{"format": "rfc5424"},
+			Length:    0,
+			ExpectErr: true,
+		},
+		"unterminated structured data": {
+			Data:      `<165>1 2003-10-11T22:14:15.003Z host app - - [id a="b"`,
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewSyslogCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d messages, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestSyslogCodecValue(t *testing.T) {
+	now := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	cases := map[string]struct {
+		Data     string
+		Options  map[string]interface{}
+		Expected common.MapStr
+	}{
+		"rfc5424": {
+			Data: `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Appl\"ication\]"][examplePriority@32473 class="high"] ` + "\xEF\xBB\xBF" + `An application event`,
+			Expected: common.MapStr{
+				"priority":       165,
+				"facility":       20,
+				"facility_label": "local4",
+				"severity":       5,
+				"severity_label": "notice",
+				"version":        1,
+				"timestamp":      time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC),
+				"hostname":       "mymachine.example.com",
+				"app_name":       "evntslog",
+				"msgid":          "ID47",
+				"structured_data": common.MapStr{
+					"exampleSDID@32473":     common.MapStr{"iut": "3", "eventSource": `Appl"ication]`},
+					"examplePriority@32473": common.MapStr{"class": "high"},
+				},
+				"message": "An application event",
+			},
+		},
+		"rfc5424 nil values": {
+			Data: `<13>1 - - - - - -`,
+			Expected: common.MapStr{
+				"priority":       13,
+				"facility":       1,
+				"facility_label": "user",
+				"severity":       5,
+				"severity_label": "notice",
+				"version":        1,
+			},
+		},
+		"rfc3164": {
+			Data: `<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8`,
+			Expected: common.MapStr{
+				"priority":       34,
+				"facility":       4,
+				"facility_label": "auth",
+				"severity":       2,
+				"severity_label": "crit",
+				"timestamp":      time.Date(2017, 10, 11, 22, 14, 15, 0, time.UTC),
+				"hostname":       "mymachine",
+				"app_name":       "su",
+				"procid":         "123",
+				"message":        "'su root' failed for lonvick on /dev/pts/8",
+			},
+		},
+		"rfc3164 without pri or hostname": {
+			Data: `Jan  1 09:00:00 kernel: boot`,
+			Expected: common.MapStr{
+				"timestamp": time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC),
+				"app_name":  "kernel",
+				"message":   "boot",
+			},
+		},
+		"rfc3164 with year and timezone": {
+			Data:    `Jun  1 09:00:00 host cron[1]: job`,
+			Options: map[string]interface{}{"year": 2016, "timezone": "America/New_York"},
+			Expected: common.MapStr{
+				"timestamp": time.Date(2016, 6, 1, 9, 0, 0, 0, newYork),
+				"hostname":  "host",
+				"app_name":  "cron",
+				"procid":    "1",
+				"message":   "job",
+			},
+		},
+		"rsyslog high precision": {
+			Data: `2018-06-01T12:30:00.5+02:00 host sshd[9]: Accepted publickey`,
+			Expected: common.MapStr{
+				"timestamp": time.Date(2018, 6, 1, 10, 30, 0, 500000000, time.UTC),
+				"hostname":  "host",
+				"app_name":  "sshd",
+				"procid":    "9",
+				"message":   "Accepted publickey",
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewSyslogCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		c.(*SyslogCodec).now = func() time.Time { return now }
+
+		if !c.Next() {
+			t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+		}
+
+		actual := c.Value()["syslog"].(common.MapStr)
+		if ts, ok := actual["timestamp"].(time.Time); ok {
+			expected := tc.Expected["timestamp"].(time.Time)
+			if !ts.Equal(expected) {
+				t.Errorf("%q | Expected timestamp %v, got %v", tn, expected, ts)
+			}
+
+			delete(actual, "timestamp")
+			delete(tc.Expected, "timestamp")
+		}
+
+		expectedS := fmt.Sprintf("%v", tc.Expected)
+		actualS := fmt.Sprintf("%v", actual)
+		if expectedS != actualS {
+			t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+		}
+	}
+}
+
+func TestSyslogCodecLineNumbers(t *testing.T) {
+	data := "\n26 <13>1 - h a - - - one\ntwo\n<13>1 - h a - - - three\n\n<13>1 - h a - - - four"
+	expected := map[int]string{2: "one\ntwo", 4: "three", 6: "four"}
+
+	c, _ := NewSyslogCodec(nil, "testfile", strings.NewReader(data))
+
+	count := 0
+	for c.Next() {
+		count++
+		val := c.Value()
+
+		line := val["line"].(int)
+		message, _ := val.GetValue("syslog.message")
+		if expected[line] != message {
+			t.Errorf("Expected line %d to be %q, got %q", line, expected[line], message)
+		}
+
+		if val["file"] != "testfile" {
+			t.Errorf("Expected file to be 'testfile', got %q", val["file"])
+		}
+	}
+
+	if c.Err() != nil || count != len(expected) {
+		t.Errorf("Expected %d messages and no error, got %d and %v", len(expected), count, c.Err())
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// XmlConfig holds the options for the xml codec.
+type XmlConfig struct {
+	// RecordPath is the slash separated list of element names from the document
+	// root to the repeating record element e.g. "feed/records/record". A * matches
+	// any element name.
+	RecordPath string `config:"record_path"`
+
+	// AttributePrefix is prepended to attribute names to tell them apart from
+	// child elements.
+	AttributePrefix string `config:"attribute_prefix"`
+
+	// TextKey holds the text of elements that also have attributes or children.
+	TextKey string `config:"text_key"`
+}
+
+var defaultXmlConfig = XmlConfig{
+	AttributePrefix: "",
+	TextKey:         "text",
+}
+
+func (c *XmlConfig) Validate() error {
+	if len(c.recordPath()) == 0 {
+		return errors.New("xml codec needs a record_path")
+	}
+
+	for _, name := range c.recordPath() {
+		if name == "" {
+			return fmt.Errorf("xml record_path %q has an empty element name", c.RecordPath)
+		}
+	}
+
+	if c.TextKey == "" {
+		return errors.New("xml text_key must not be empty")
+	}
+
+	return nil
+}
+
+func (c *XmlConfig) recordPath() []string {
+	path := strings.Trim(strings.TrimSpace(c.RecordPath), "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+func newXmlConfig(options *common.Config) (*XmlConfig, error) {
+	config, err := unpackCodecConfig("xml", defaultXmlConfig, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return config.(*XmlConfig), nil
+}
+
+func init() {
+	Register(XmlCodecId, NewXmlCodec, defaultXmlConfig)
+}
+
+func NewXmlCodec(options *common.Config, path string, input io.Reader) (Codec, error) {
+	config, err := newXmlConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := xml.NewDecoder(bufio.NewReader(input))
+	decoder.CharsetReader = xmlCharsetReader
+
+	return &XmlCodec{
+		decoder:    decoder,
+		config:     config,
+		recordPath: config.recordPath(),
+		path:       path,
+	}, nil
+}
+
+// XmlCodec streams a document, sending one event per element found at the
+// record path with its attributes and children as a nested map under "xml".
+// Elements that repeat become arrays and elements with nothing but text
+// become strings.
+type XmlCodec struct {
+	decoder    *xml.Decoder
+	config     *XmlConfig
+	recordPath []string
+	stack      []string
+	value      common.MapStr
+	err        error
+	lineNumber int
+	path       string
+}
+
+func (codec *XmlCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for {
+		token, err := codec.decoder.Token()
+		if err == io.EOF {
+			return false
+		}
+
+		if err != nil {
+			codec.err = err
+			return false
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			codec.stack = append(codec.stack, t.Name.Local)
+			if !codec.atRecord() {
+				continue
+			}
+
+			// the decoder consumes the matching end element
+			codec.stack = codec.stack[:len(codec.stack)-1]
+
+			record, err := codec.decodeElement(t)
+			if err != nil {
+				codec.err = fmt.Errorf("record %d: %v", codec.lineNumber+1, err)
+				return false
+			}
+
+			fields, ok := record.(common.MapStr)
+			if !ok {
+				fields = common.MapStr{codec.config.TextKey: record}
+			}
+
+			codec.lineNumber++
+			codec.value = common.MapStr{
+				"xml":  fields,
+				"file": codec.path,
+				"line": codec.lineNumber,
+			}
+
+			return true
+
+		case xml.EndElement:
+			codec.stack = codec.stack[:len(codec.stack)-1]
+		}
+	}
+}
+
+func (codec *XmlCodec) atRecord() bool {
+	if len(codec.stack) != len(codec.recordPath) {
+		return false
+	}
+
+	for i, name := range codec.recordPath {
+		if name != "*" && name != codec.stack[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decodeElement reads the contents of start up to its end element. It returns
+// a string for elements with only text and a map otherwise.
+func (codec *XmlCodec) decodeElement(start xml.StartElement) (interface{}, error) {
+	fields := common.MapStr{}
+	for _, attr := range start.Attr {
+		// namespace declarations aren't data
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+
+		addXmlValue(fields, codec.config.AttributePrefix+attr.Name.Local, attr.Value)
+	}
+
+	var text []string
+	for {
+		token, err := codec.decoder.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("element %q isn't closed", start.Name.Local)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := codec.decodeElement(t)
+			if err != nil {
+				return nil, err
+			}
+
+			addXmlValue(fields, t.Name.Local, child)
+
+		case xml.CharData:
+			if s := strings.TrimSpace(string(t)); s != "" {
+				text = append(text, s)
+			}
+
+		case xml.EndElement:
+			joined := strings.Join(text, " ")
+			if len(fields) == 0 {
+				return joined, nil
+			}
+
+			if joined != "" {
+				addXmlValue(fields, codec.config.TextKey, joined)
+			}
+
+			return fields, nil
+		}
+	}
+}
+
+// addXmlValue sets key to value, turning it into an array if it's repeated.
+func addXmlValue(fields common.MapStr, key string, value interface{}) {
+	existing, found := fields[key]
+	if !found {
+		fields[key] = value
+		return
+	}
+
+	if values, ok := existing.([]interface{}); ok {
+		fields[key] = append(values, value)
+	} else {
+		fields[key] = []interface{}{existing, value}
+	}
+}
+
+// xmlCharsetReader handles the single byte encodings commonly declared by
+// older feeds, encoding/xml only reads UTF-8 by itself.
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "latin-1":
+		return &latin1Reader{input: bufio.NewReader(input)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported XML encoding %q", charset)
+}
+
+// latin1Reader converts ISO-8859-1 to UTF-8, each byte is the code point.
+type latin1Reader struct {
+	input   io.ByteReader
+	buf     [utf8.UTFMax]byte
+	pending []byte
+}
+
+func (r *latin1Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.pending) > 0 {
+			copied := copy(p[n:], r.pending)
+			r.pending = r.pending[copied:]
+			n += copied
+			continue
+		}
+
+		b, err := r.input.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+
+			return 0, err
+		}
+
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+
+		r.pending = r.buf[:utf8.EncodeRune(r.buf[:], rune(b))]
+	}
+
+	return n, nil
+}
+
+func (codec *XmlCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *XmlCodec) Err() error {
+	return codec.err
+}
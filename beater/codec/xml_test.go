@@ -0,0 +1,183 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+func TestXmlCodecInvalidOptions(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"no options":        nil,
+		"blank record path": {"record_path": " / "},
+		"empty element":     {"record_path": "feed//record"},
+		"empty text key":    {"record_path": "feed/record", "text_key": ""},
+	}
+
+	for tn, tc := range cases {
+		_, err := NewXmlCodec(testOptions(t, tc), "testfile", strings.NewReader(""))
+		if err == nil {
+			t.Errorf("%q | Expected an error", tn)
+		}
+	}
+}
+
+func TestXmlCodecNextErr(t *testing.T) {
+	cases := map[string]struct {
+		Data      string
+		Length    int
+		ExpectErr bool
+	}{
+		"empty file": {
+			Data:   "",
+			Length: 0,
+		},
+		"no records": {
+			Data:   `<?xml version="1.0"?><feed><meta/></feed>`,
+			Length: 0,
+		},
+		"records": {
+			Data:   `<feed><record/><other><record/></other><record>x</record></feed>`,
+			Length: 2,
+		},
+		"truncated record": {
+			Data:      `<feed><record><a>1</a></record><record><a>2</a>`,
+			Length:    1,
+			ExpectErr: true,
+		},
+		"malformed": {
+			Data:      `<feed><record><a>1</b></record></feed>`,
+			Length:    0,
+			ExpectErr: true,
+		},
+		"unsupported encoding": {
+			Data:      `<?xml version="1.0" encoding="EBCDIC"?><feed><record/></feed>`,
+			Length:    0,
+			ExpectErr: true,
+		},
+	}
+
+	for tn, tc := range cases {
+		options := testOptions(t, map[string]interface{}{"record_path": "feed/record"})
+		c, err := NewXmlCodec(options, "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		counter := 0
+		for c.Next() {
+			counter++
+		}
+
+		hasErr := c.Err() != nil
+		if hasErr != tc.ExpectErr {
+			t.Errorf("%q | Got error %v, expected? %v", tn, c.Err(), tc.ExpectErr)
+		}
+
+		if counter != tc.Length {
+			t.Errorf("%q | Expected to decode %d records, got %d", tn, tc.Length, counter)
+		}
+	}
+}
+
+func TestXmlCodecValue(t *testing.T) {
+	cases := map[string]struct {
+		Data     string
+		Options  map[string]interface{}
+		Expected []common.MapStr
+	}{
+		"nested": {
+			Data: `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://example.com/feed">
+  <records>
+    <record id="1" xmlns:p="http://example.com/p">
+      <name>Widget</name>
+      <p:price currency="USD">9.99</p:price>
+      <tag>a</tag>
+      <tag>b</tag>
+      <empty/>
+    </record>
+    <record id="2">Text <b>bold</b> tail</record>
+  </records>
+</feed>`,
+			Options: map[string]interface{}{"record_path": "/feed/records/record"},
+			Expected: []common.MapStr{
+				{
+					"id":    "1",
+					"name":  "Widget",
+					"price": common.MapStr{"currency": "USD", "text": "9.99"},
+					"tag":   []interface{}{"a", "b"},
+					"empty": "",
+				},
+				{
+					"id":   "2",
+					"b":    "bold",
+					"text": "Text tail",
+				},
+			},
+		},
+		"text only record": {
+			Data:     `<list><item>one</item><item>two</item></list>`,
+			Options:  map[string]interface{}{"record_path": "list/item", "text_key": "value"},
+			Expected: []common.MapStr{{"value": "one"}, {"value": "two"}},
+		},
+		"wildcard and prefix": {
+			Data:    `<root><a k="1"><k>2</k></a><b k="3"/></root>`,
+			Options: map[string]interface{}{"record_path": "root/*", "attribute_prefix": "@"},
+			Expected: []common.MapStr{
+				{"@k": "1", "k": "2"},
+				{"@k": "3"},
+			},
+		},
+		"latin1": {
+			Data:     "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><r><e>caf\xe9</e></r>",
+			Options:  map[string]interface{}{"record_path": "r/e"},
+			Expected: []common.MapStr{{"text": "café"}},
+		},
+	}
+
+	for tn, tc := range cases {
+		c, err := NewXmlCodec(testOptions(t, tc.Options), "testfile", strings.NewReader(tc.Data))
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		for i, expectedFields := range tc.Expected {
+			if !c.Next() {
+				t.Fatalf("%q | Quit too early: %v", tn, c.Err())
+			}
+
+			expected := common.MapStr{
+				"xml":  expectedFields,
+				"file": "testfile",
+				"line": i + 1,
+			}
+
+			expectedS := fmt.Sprintf("%v", expected)
+			actualS := fmt.Sprintf("%v", c.Value())
+			if expectedS != actualS {
+				t.Errorf("%q | Expected %v, got %v", tn, expectedS, actualS)
+			}
+		}
+
+		if c.Next() || c.Err() != nil {
+			t.Errorf("%q | Expected the end of the document, got %v", tn, c.Err())
+		}
+	}
+}
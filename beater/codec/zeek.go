@@ -0,0 +1,355 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// zeekHeader holds the directives at the top of a Zeek log, a log can start
+// a new header part way through e.g. when files are concatenated.
+type zeekHeader struct {
+	separator    string
+	setSeparator string
+	emptyField   string
+	unsetField   string
+	path         string
+	fields       []string
+	types        []string
+}
+
+var defaultZeekHeader = zeekHeader{
+	separator:    "\t",
+	setSeparator: ",",
+	emptyField:   "(empty)",
+	unsetField:   "-",
+}
+
+func init() {
+	Register(ZeekCodecId, withoutOptions(NewZeekCodec), nil)
+}
+
+func NewZeekCodec(objectPath string, input io.Reader) Codec {
+	header := defaultZeekHeader
+
+	// logs without a #path directive are named after the file e.g. conn.log
+	header.path = strings.SplitN(path.Base(objectPath), ".", 2)[0]
+
+	return &ZeekCodec{
+		scanner: bufio.NewScanner(input),
+		header:  header,
+		path:    objectPath,
+	}
+}
+
+// ZeekCodec reads the tab separated logs written by Zeek (Bro). The columns
+// are named and typed by the #fields and #types directives and rows are sent
+// under zeek.<path> e.g. zeek.conn, with the ts column as the timestamp.
+// Unset fields are left out.
+type ZeekCodec struct {
+	scanner    *bufio.Scanner
+	header     zeekHeader
+	value      common.MapStr
+	err        error
+	bad        []byte
+	lineNumber int
+	path       string
+}
+
+func (codec *ZeekCodec) Next() bool {
+	if codec.err != nil {
+		return false
+	}
+
+	for codec.scanner.Scan() {
+		codec.lineNumber++
+		line := codec.scanner.Text()
+
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if err := codec.header.parseDirective(line); err != nil {
+				return codec.fail(line, err)
+			}
+
+			continue
+		}
+
+		if err := codec.parseRow(line); err != nil {
+			return codec.fail(line, err)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (codec *ZeekCodec) fail(line string, err error) bool {
+	codec.err = fmt.Errorf("line %d: %v", codec.lineNumber, err)
+	codec.bad = []byte(line)
+	return false
+}
+
+// parseDirective updates the header. Directives other than the ones naming
+// and typing the columns, e.g. #open and #close, are ignored.
+func (header *zeekHeader) parseDirective(line string) error {
+	// the separator is always given after a space, the others after it
+	if strings.HasPrefix(line, "#separator ") {
+		separator := unescapeZeek(strings.TrimPrefix(line, "#separator "))
+		if separator == "" {
+			return fmt.Errorf("%q is an empty separator", line)
+		}
+
+		header.separator = separator
+		return nil
+	}
+
+	parts := strings.Split(line, header.separator)
+	values := parts[1:]
+
+	switch parts[0] {
+	case "#set_separator", "#empty_field", "#unset_field", "#path":
+		if len(values) != 1 {
+			return fmt.Errorf("%s needs one value, got %d", parts[0], len(values))
+		}
+	}
+
+	switch parts[0] {
+	case "#set_separator":
+		header.setSeparator = unescapeZeek(values[0])
+	case "#empty_field":
+		header.emptyField = unescapeZeek(values[0])
+	case "#unset_field":
+		header.unsetField = unescapeZeek(values[0])
+	case "#path":
+		header.path = unescapeZeek(values[0])
+	case "#fields":
+		header.fields = values
+		header.types = nil
+	case "#types":
+		header.types = values
+	}
+
+	return nil
+}
+
+func (codec *ZeekCodec) parseRow(line string) error {
+	header := &codec.header
+	if header.fields == nil {
+		return errors.New("row before the #fields directive")
+	}
+
+	if header.types != nil && len(header.types) != len(header.fields) {
+		return fmt.Errorf("#types has %d columns but #fields has %d", len(header.types), len(header.fields))
+	}
+
+	values := strings.Split(line, header.separator)
+	if len(values) != len(header.fields) {
+		return fmt.Errorf("expected %d columns, got %d", len(header.fields), len(values))
+	}
+
+	fields := common.MapStr{}
+	codec.value = common.MapStr{
+		"zeek": common.MapStr{header.path: fields},
+		"file": codec.path,
+		"line": codec.lineNumber,
+	}
+
+	for i, raw := range values {
+		if raw == header.unsetField {
+			continue
+		}
+
+		columnType := "string"
+		if header.types != nil {
+			columnType = header.types[i]
+		}
+
+		value, err := header.convert(columnType, raw)
+		if err != nil {
+			return fmt.Errorf("column %q: %v", header.fields[i], err)
+		}
+
+		// names like id.orig_h become nested objects
+		fields.Put(header.fields[i], value)
+
+		if header.fields[i] == "ts" && columnType == "time" {
+			codec.value["@timestamp"] = value
+		}
+	}
+
+	return nil
+}
+
+// convert decodes a value of one of Zeek's types. Unknown types are kept as
+// strings.
+func (header *zeekHeader) convert(columnType string, raw string) (interface{}, error) {
+	if strings.HasSuffix(columnType, "]") {
+		if i := strings.Index(columnType, "["); i > 0 {
+			return header.convertContainer(columnType[i+1:len(columnType)-1], raw)
+		}
+	}
+
+	switch columnType {
+	case "bool":
+		switch raw {
+		case "T":
+			return true, nil
+		case "F":
+			return false, nil
+		}
+
+		return nil, fmt.Errorf("%q isn't T or F", raw)
+
+	case "count", "port":
+		return strconv.ParseUint(raw, 10, 64)
+
+	case "int":
+		return strconv.ParseInt(raw, 10, 64)
+
+	case "double", "interval":
+		// intervals are in seconds
+		return strconv.ParseFloat(raw, 64)
+
+	case "time":
+		return parseZeekTime(raw)
+
+	case "addr":
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil, fmt.Errorf("%q isn't an IP address", raw)
+		}
+
+		return ip.String(), nil
+
+	case "subnet":
+		_, subnet, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a subnet", raw)
+		}
+
+		return subnet.String(), nil
+	}
+
+	if raw == header.emptyField {
+		return "", nil
+	}
+
+	return unescapeZeek(raw), nil
+}
+
+// convertContainer decodes the elements of a set or vector.
+func (header *zeekHeader) convertContainer(elementType string, raw string) (interface{}, error) {
+	values := []interface{}{}
+	if raw == header.emptyField {
+		return values, nil
+	}
+
+	for _, element := range strings.Split(raw, header.setSeparator) {
+		value, err := header.convert(elementType, element)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// parseZeekTime reads seconds since the epoch with up to nanosecond
+// precision e.g. 1525176184.123456, without the rounding of a float.
+func parseZeekTime(raw string) (time.Time, error) {
+	parts := strings.SplitN(raw, ".", 2)
+
+	seconds, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q isn't a time", raw)
+	}
+
+	var nanos int64
+	if len(parts) == 2 {
+		fraction := parts[1]
+		if len(fraction) > 9 {
+			fraction = fraction[:9]
+		}
+
+		nanos, err = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+		if err != nil || nanos < 0 {
+			return time.Time{}, fmt.Errorf("%q isn't a time", raw)
+		}
+	}
+
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+// unescapeZeek replaces the \xHH escapes Zeek writes for separators and
+// non-printable characters.
+func unescapeZeek(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if b, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				out = append(out, byte(b))
+				i += 3
+				continue
+			}
+		}
+
+		out = append(out, s[i])
+	}
+
+	return string(out)
+}
+
+func (codec *ZeekCodec) Value() common.MapStr {
+	return codec.value
+}
+
+func (codec *ZeekCodec) Err() error {
+	if codec.err != nil {
+		return codec.err
+	}
+
+	return codec.scanner.Err()
+}
+
+// Recover skips the line that couldn't be parsed.
+func (codec *ZeekCodec) Recover() (common.MapStr, error) {
+	if codec.bad == nil {
+		return nil, errNotRecoverable
+	}
+
+	event := newErrorEvent(codec.path, codec.lineNumber, codec.bad, codec.err)
+	codec.err, codec.bad = nil, nil
+	return event, nil
+}
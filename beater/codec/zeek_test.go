@@ -0,0 +1,232 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package codec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+const testZeekConnLog = `#separator \x09
+#set_separator	,
+#empty_field	(empty)
+#unset_field	-
+#path	conn
+#open	2018-05-01-12-00-00
+#fields	ts	uid	id.orig_h	id.orig_p	id.resp_h	id.resp_p	proto	duration	orig_bytes	local_orig	tunnel_parents	history
+#types	time	string	addr	port	addr	port	enum	interval	count	bool	set[string]	string
+1525176184.123456	CHhAvVGS1DHFjwGM9	10.0.0.1	49152	2001:db8::0001	443	tcp	1.500000	1024	T	(empty)	ShADad
+1525176185.000001	C4J4Th3PJpwUYZZ6gc	10.0.0.2	53	10.0.0.3	53	udp	-	-	F	a,b	(empty)
+#close	2018-05-01-13-00-00
+`
+
+func TestZeekCodecValue(t *testing.T) {
+	expected := []common.MapStr{
+		{
+			"@timestamp": time.Date(2018, 5, 1, 12, 3, 4, 123456000, time.UTC),
+			"zeek": common.MapStr{
+				"conn": common.MapStr{
+					"ts":  time.Date(2018, 5, 1, 12, 3, 4, 123456000, time.UTC),
+					"uid": "CHhAvVGS1DHFjwGM9",
+					"id": common.MapStr{
+						"orig_h": "10.0.0.1",
+						"orig_p": uint64(49152),
+						"resp_h": "2001:db8::1",
+						"resp_p": uint64(443),
+					},
+					"proto":          "tcp",
+					"duration":       1.5,
+					"orig_bytes":     uint64(1024),
+					"local_orig":     true,
+					"tunnel_parents": []interface{}{},
+					"history":        "ShADad",
+				},
+			},
+			"file": "conn.log",
+			"line": 9,
+		},
+		{
+			"@timestamp": time.Date(2018, 5, 1, 12, 3, 5, 1000, time.UTC),
+			"zeek": common.MapStr{
+				"conn": common.MapStr{
+					"ts":  time.Date(2018, 5, 1, 12, 3, 5, 1000, time.UTC),
+					"uid": "C4J4Th3PJpwUYZZ6gc",
+					"id": common.MapStr{
+						"orig_h": "10.0.0.2",
+						"orig_p": uint64(53),
+						"resp_h": "10.0.0.3",
+						"resp_p": uint64(53),
+					},
+					"proto":          "udp",
+					"local_orig":     false,
+					"tunnel_parents": []interface{}{"a", "b"},
+					"history":        "",
+				},
+			},
+			"file": "conn.log",
+			"line": 10,
+		},
+	}
+
+	c := NewZeekCodec("conn.log", strings.NewReader(testZeekConnLog))
+	for i, expectedFields := range expected {
+		if !c.Next() {
+			t.Fatalf("Quit too early: %v", c.Err())
+		}
+
+		expectedS := fmt.Sprintf("%v", expectedFields)
+		actualS := fmt.Sprintf("%v", c.Value())
+		if expectedS != actualS {
+			t.Errorf("%d | Expected %v, got %v", i, expectedS, actualS)
+		}
+	}
+
+	if c.Next() || c.Err() != nil {
+		t.Errorf("Expected the end of the file, got %v", c.Err())
+	}
+}
+
+func TestZeekCodecHeaders(t *testing.T) {
+	cases := map[string]struct {
+		Path string
+		Data string
+		// Expected holds the log path and the fields of each row
+		Expected []string
+	}{
+		"new header mid-file": {
+			Path: "logs.log",
+			Data: "#fields\tts\tuid\n#types\ttime\tstring\n#path\tconn\n1.5\ta\n#close\tx\n" +
+				"#path\tdns\n#fields\tquery\tqtype\n#types\tstring\tcount\nexample.com\t1\n",
+			Expected: []string{`conn {"ts":"1970-01-01T00:00:01.5Z","uid":"a"}`, `dns {"qtype":1,"query":"example.com"}`},
+		},
+		"path from the file name": {
+			Path:     "logs/http.12:00:00-13:00:00.log.gz",
+			Data:     "#fields\thost\n#types\tstring\nexample.com\n",
+			Expected: []string{`http {"host":"example.com"}`},
+		},
+		"no types": {
+			Path:     "weird.log",
+			Data:     "#fields\tname\tpeer\nbad_TCP_checksum\tzeek\n",
+			Expected: []string{`weird {"name":"bad_TCP_checksum","peer":"zeek"}`},
+		},
+		"other separators": {
+			Path: "x.log",
+			Data: "#separator \\x7c\n#set_separator|;\n#empty_field|EMPTY\n#unset_field|NONE\n#path|x\n" +
+				"#fields|a|b|c|d\n#types|vector[count]|string|string|vector[string]\n1;2|NONE|EMPTY|EMPTY\n",
+			Expected: []string{`x {"a":[1,2],"c":"","d":[]}`},
+		},
+		"escapes": {
+			Path:     "x.log",
+			Data:     "#path\tx\n#fields\ta\tb\n#types\tstring\tset[string]\ntab\\x09here\tcomma\\x2c,b\n",
+			Expected: []string{`x {"a":"tab\there","b":["comma,","b"]}`},
+		},
+		"subnet and double": {
+			Path:     "x.log",
+			Data:     "#path\tx\n#fields\tnet\tscore\tn\n#types\tsubnet\tdouble\tint\n10.1.2.3/8\t-0.25\t-3\n",
+			Expected: []string{`x {"n":-3,"net":"10.0.0.0/8","score":-0.25}`},
+		},
+	}
+
+	for tn, tc := range cases {
+		c := NewZeekCodec(tc.Path, strings.NewReader(tc.Data))
+
+		var actual []string
+		for c.Next() {
+			for path, fields := range c.Value()["zeek"].(common.MapStr) {
+				actual = append(actual, fmt.Sprintf("%s %v", path, fields))
+			}
+		}
+
+		if c.Err() != nil {
+			t.Errorf("%q | Unexpected error %v", tn, c.Err())
+		}
+
+		if fmt.Sprintf("%q", actual) != fmt.Sprintf("%q", tc.Expected) {
+			t.Errorf("%q | Expected %q, got %q", tn, tc.Expected, actual)
+		}
+	}
+}
+
+func TestZeekCodecRecover(t *testing.T) {
+	header := "#fields\tts\tip\tn\tok\n#types\ttime\taddr\tcount\tbool\n"
+
+	cases := map[string]struct {
+		Data string
+		// Expected holds the ip of each event, or the line of error events
+		Expected []interface{}
+	}{
+		"bad addr":      {Data: header + "1\tnope\t1\tT\n1\t10.0.0.1\t1\tT\n", Expected: []interface{}{3, "10.0.0.1"}},
+		"bad count":     {Data: header + "1\t10.0.0.1\t-1\tT\n1\t10.0.0.2\t1\tT\n", Expected: []interface{}{3, "10.0.0.2"}},
+		"bad bool":      {Data: header + "1\t10.0.0.1\t1\ttrue\n", Expected: []interface{}{3}},
+		"bad time":      {Data: header + "noon\t10.0.0.1\t1\tT\n", Expected: []interface{}{3}},
+		"short row":     {Data: header + "1\t10.0.0.1\n1\t10.0.0.2\t1\tT\n", Expected: []interface{}{3, "10.0.0.2"}},
+		"no fields":     {Data: "1\t10.0.0.1\t1\tT\n", Expected: []interface{}{1}},
+		"types too few": {Data: "#fields\ta\tb\n#types\tstring\nx\ty\n", Expected: []interface{}{3}},
+		"bad directive": {Data: "#path\n" + header + "1\t10.0.0.1\t1\tT\n", Expected: []interface{}{1, "10.0.0.1"}},
+	}
+
+	for tn, tc := range cases {
+		c := NewZeekCodec("x.log", strings.NewReader(tc.Data))
+
+		var actual []interface{}
+		for {
+			for c.Next() {
+				ip, _ := c.Value().GetValue("zeek.x.ip")
+				actual = append(actual, ip)
+			}
+
+			if c.Err() == nil {
+				break
+			}
+
+			event, err := c.(RecoverableCodec).Recover()
+			if err != nil {
+				t.Fatalf("%q | Unexpected error recovering from %v: %v", tn, c.Err(), err)
+			}
+
+			actual = append(actual, event["line"])
+		}
+
+		if fmt.Sprintf("%v", actual) != fmt.Sprintf("%v", tc.Expected) {
+			t.Errorf("%q | Expected %v, got %v", tn, tc.Expected, actual)
+		}
+	}
+}
+
+func TestParseZeekTime(t *testing.T) {
+	cases := map[string]string{
+		"1525176184":             "2018-05-01 12:03:04 +0000 UTC",
+		"1525176184.1":           "2018-05-01 12:03:04.1 +0000 UTC",
+		"1525176184.123456789":   "2018-05-01 12:03:04.123456789 +0000 UTC",
+		"1525176184.12345678999": "2018-05-01 12:03:04.123456789 +0000 UTC",
+		"1525176184.":            "2018-05-01 12:03:04 +0000 UTC",
+		"x.5":                    "error",
+	}
+
+	for raw, expected := range cases {
+		actual := "error"
+		if parsed, err := parseZeekTime(raw); err == nil {
+			actual = parsed.String()
+		}
+
+		if actual != expected {
+			t.Errorf("%q | Expected %s, got %s", raw, expected, actual)
+		}
+	}
+}
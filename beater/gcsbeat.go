@@ -17,10 +17,12 @@ package beater
 import (
 	"compress/gzip"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/module"
 	"github.com/GoogleCloudPlatform/gcsbeat/beater/storage"
 	"github.com/GoogleCloudPlatform/gcsbeat/config"
 	mapset "github.com/deckarep/golang-set"
@@ -29,6 +31,7 @@ import (
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/outputs/elasticsearch"
 )
 
 type Gcpstoragebeat struct {
@@ -60,9 +63,43 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 		logger:        logp.NewLogger("GCS:" + c.BucketId),
 	}
 
+	// the pipelines are loaded by setup and whenever the output connects
+	if modules := c.EnabledModules(); len(modules) > 0 && b.Config.Output.Name() == "elasticsearch" {
+		elasticsearch.RegisterConnectCallback(func(esClient *elasticsearch.Client) error {
+			return module.LoadPipelines(esClient, modules)
+		})
+	}
+
 	return bt, nil
 }
 
+// SetupPipelines loads the ingest pipelines of the enabled modules. The setup
+// command's Elasticsearch client never runs the connect callbacks, so setup
+// calls this itself.
+func SetupPipelines(b *beat.Beat) error {
+	c, err := config.GetAndValidateConfig(b.BeatConfig)
+	if err != nil {
+		return err
+	}
+
+	modules := c.EnabledModules()
+	if len(modules) == 0 {
+		return nil
+	}
+
+	if b.Config.Output.Name() != "elasticsearch" {
+		return fmt.Errorf("Pipeline loading requested but the Elasticsearch output is not configured/enabled")
+	}
+
+	esClient, err := elasticsearch.NewConnectedClient(b.Config.Output.Config())
+	if err != nil {
+		return err
+	}
+	defer esClient.Close()
+
+	return module.LoadPipelines(esClient, modules)
+}
+
 func (bt *Gcpstoragebeat) Run(b *beat.Beat) error {
 	bt.logger.Info("GCP storage beat is running! Hit CTRL-C to stop it.")
 	bt.logger.Infof("Version: %q", storage.GetUserAgent())
@@ -128,6 +165,12 @@ func (bt *Gcpstoragebeat) fileChangeWatcher() {
 			return !excluded, nil
 		})
 
+		if len(bt.config.Rules) > 0 {
+			storage.ExplainChoice("rule", files, func(path string) string {
+				return bt.config.RuleFor(path).String()
+			})
+		}
+
 		bt.logger.Infof("Added %d files to queue", len(files))
 		for _, path := range files {
 			bt.logger.Debugf(" - %q", path)
@@ -160,7 +203,8 @@ func (bt *Gcpstoragebeat) downloadFile(path string) {
 
 	defer input.Close()
 
-	if bt.config.UnpackGzip && strings.HasSuffix(path, ".gz") {
+	rule := bt.config.RuleFor(path)
+	if *rule.UnpackGzip && strings.HasSuffix(path, ".gz") {
 		gzReader, err := gzip.NewReader(input)
 
 		if err != nil {
@@ -172,27 +216,123 @@ func (bt *Gcpstoragebeat) downloadFile(path string) {
 		input = gzReader
 	}
 
-	codec, err := codec.NewCodec(bt.config.Codec, path, input)
+	var codecInput io.Reader = input
+	if rule.Codec.Type == codec.AutoCodecId {
+		// without the attributes the format is still detected from the content
+		var contentType string
+		var metadata map[string]string
+		if attrs, err := bt.bucket.Attributes(path); err != nil {
+			bt.logger.Errorf("Error reading the attributes of %q, detecting its codec from the content: %v", path, err)
+		} else {
+			contentType, metadata = attrs.ContentType, attrs.Metadata
+		}
+
+		codecInput = codec.WithObjectAttributes(input, contentType, metadata)
+	}
+
+	codec, err := codec.NewCodec(rule.Codec.Type, rule.Codec.Options, path, codecInput)
 	if err != nil {
 		bt.logger.Errorf("Error parsing file %q: %v", path, err)
 		return
 	}
 
-	for codec.Next() {
-		event := beat.Event{
-			Timestamp: time.Now(),
-			Fields:    codec.Value(),
+	for {
+		for codec.Next() {
+			bt.publish(rule, codec.Value())
+		}
+
+		err := codec.Err()
+		if err == nil {
+			break
 		}
 
-		bt.client.Publish(event)
+		if !bt.skipRecord(path, rule, codec, err) {
+			return
+		}
 	}
 
-	if err := codec.Err(); err != nil {
+	bt.closeOutFile(path)
+}
+
+func (bt *Gcpstoragebeat) publish(rule *config.RuleConfig, fields common.MapStr) {
+	event := beat.Event{
+		Timestamp: time.Now(),
+		Fields:    fields,
+	}
+
+	liftMetadata(&event)
+
+	common.MergeFields(event.Fields, rule.Fields, rule.FieldsUnderRoot)
+	common.AddTags(event.Fields, rule.Tags)
+
+	// the elasticsearch output reads the destination from the metadata, one
+	// the codec chose for the event wins over the rule's
+	if event.Meta == nil && (rule.Index != "" || rule.Pipeline != "") {
+		event.Meta = common.MapStr{}
+	}
+
+	if _, ok := event.Meta["index"]; rule.Index != "" && !ok {
+		event.Meta["index"] = rule.Index
+	}
+
+	if _, ok := event.Meta["pipeline"]; rule.Pipeline != "" && !ok {
+		event.Meta["pipeline"] = rule.Pipeline
+	}
+
+	bt.client.Publish(event)
+}
+
+// liftMetadata moves the @metadata and @timestamp fields a codec set to the
+// event's metadata and timestamp so the outputs use them. Timestamps that
+// can't be parsed are left in the fields.
+func liftMetadata(event *beat.Event) {
+	switch meta := event.Fields["@metadata"].(type) {
+	case common.MapStr:
+		event.Meta = meta
+		delete(event.Fields, "@metadata")
+	case map[string]interface{}:
+		event.Meta = common.MapStr(meta)
+		delete(event.Fields, "@metadata")
+	}
+
+	switch timestamp := event.Fields["@timestamp"].(type) {
+	case time.Time:
+		event.Timestamp = timestamp
+		delete(event.Fields, "@timestamp")
+	case string:
+		if parsed, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+			event.Timestamp = parsed
+			delete(event.Fields, "@timestamp")
+		}
+	}
+}
+
+// skipRecord applies the on_error policy after the codec stopped with err.
+// It returns false if the rest of the file has to be abandoned.
+func (bt *Gcpstoragebeat) skipRecord(path string, rule *config.RuleConfig, c codec.Codec, err error) bool {
+	if bt.config.OnError == config.OnErrorAbort {
 		bt.logger.Errorf("Error parsing file %q: %v", path, err)
-		return
+		return false
 	}
 
-	bt.closeOutFile(path)
+	recoverable, ok := c.(codec.RecoverableCodec)
+	if !ok {
+		bt.logger.Errorf("Error parsing file %q, the %s codec can't skip bad records: %v", path, rule.Codec.Type, err)
+		return false
+	}
+
+	event, recoverErr := recoverable.Recover()
+	if recoverErr != nil {
+		bt.logger.Errorf("Error parsing file %q: %v (%v)", path, err, recoverErr)
+		return false
+	}
+
+	bt.logger.Warnf("Skipping bad record in %q: %v", path, err)
+	if bt.config.OnError == config.OnErrorEmit {
+		bt.publish(rule, event)
+	}
+
+	return true
 }
 
 func (bt *Gcpstoragebeat) closeOutFile(path string) error {
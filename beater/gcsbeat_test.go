@@ -0,0 +1,129 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !integration
+
+package beater
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/module"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// fakeElasticsearch answers the requests setup makes and records the
+// pipelines it loads.
+type fakeElasticsearch struct {
+	mutex     sync.Mutex
+	pipelines map[string]bool
+	loaded    []string
+}
+
+func (es *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	if r.URL.Path == "/" {
+		w.Write([]byte(`{"version": {"number": "6.4.0"}}`))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/_ingest/pipeline/")
+	switch {
+	case id == r.URL.Path:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == "GET" && !es.pipelines[id]:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == "PUT":
+		es.pipelines[id] = true
+		es.loaded = append(es.loaded, id)
+		w.Write([]byte(`{"acknowledged": true}`))
+	default:
+		w.Write([]byte(`{}`))
+	}
+}
+
+func TestSetupPipelines(t *testing.T) {
+	vpcflow := module.Get(module.VpcFlowModuleName).PipelineId()
+	audit := module.Get(module.AuditModuleName).PipelineId()
+
+	cases := map[string]struct {
+		Modules   []string
+		Output    string
+		Existing  []string
+		Expected  []string
+		ExpectErr bool
+	}{
+		"modules":                          {Modules: []string{"vpcflow", "audit"}, Output: "elasticsearch", Expected: []string{audit, vpcflow}},
+		"already loaded":                   {Modules: []string{"vpcflow", "audit"}, Output: "elasticsearch", Existing: []string{vpcflow}, Expected: []string{audit}},
+		"no modules":                       {Output: "elasticsearch"},
+		"no modules without elasticsearch": {Output: "console"},
+		"other output":                     {Modules: []string{"vpcflow"}, Output: "console", ExpectErr: true},
+	}
+
+	for name, tc := range cases {
+		es := &fakeElasticsearch{pipelines: make(map[string]bool)}
+		for _, id := range tc.Existing {
+			es.pipelines[id] = true
+		}
+
+		server := httptest.NewServer(es)
+
+		var modules []map[string]interface{}
+		for _, m := range tc.Modules {
+			modules = append(modules, map[string]interface{}{"module": m})
+		}
+
+		output, err := common.NewConfigFrom(map[string]interface{}{
+			"output." + tc.Output + ".hosts": []string{server.URL},
+		})
+		if err != nil {
+			t.Fatalf("%q | Invalid output: %v", name, err)
+		}
+
+		cfg, err := common.NewConfigFrom(map[string]interface{}{
+			"bucket_id": "my-bucket",
+			"modules":   modules,
+		})
+		if err != nil {
+			t.Fatalf("%q | Invalid config: %v", name, err)
+		}
+
+		b := &beat.Beat{Config: &beat.BeatConfig{}, BeatConfig: cfg}
+		if err := output.Unpack(b.Config); err != nil {
+			t.Fatalf("%q | Invalid output: %v", name, err)
+		}
+
+		err = SetupPipelines(b)
+		server.Close()
+
+		if (err != nil) != tc.ExpectErr {
+			t.Errorf("%q | Expected error: %v, got: %v", name, tc.ExpectErr, err)
+		}
+
+		sort.Strings(es.loaded)
+		if !reflect.DeepEqual(es.loaded, tc.Expected) {
+			t.Errorf("%q | Expected the pipelines %v to be loaded, got %v", name, tc.Expected, es.loaded)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package module
+
+import (
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+)
+
+// auditPipeline moves the common fields of the AuditLog in the entry's proto
+// payload to audit and reads the kind of audit log from the log ID.
+const auditPipeline = `{
+  "description": "Pipeline for Cloud Audit logs exported by a Cloud Logging sink",
+  "processors": [
+    {"grok": {
+      "field": "cloud_logging.log_id",
+      "patterns": ["^cloudaudit\\.googleapis\\.com/%{GREEDYDATA:audit.log_type}$"],
+      "ignore_missing": true,
+      "ignore_failure": true
+    }},
+    {"rename": {"field": "cloud_logging.proto_payload.serviceName", "target_field": "audit.service_name", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.methodName", "target_field": "audit.method_name", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.resourceName", "target_field": "audit.resource_name", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.authenticationInfo.principalEmail", "target_field": "audit.principal_email", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.requestMetadata.callerIp", "target_field": "audit.caller_ip", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.requestMetadata.callerSuppliedUserAgent", "target_field": "audit.caller_user_agent", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.status.code", "target_field": "audit.status.code", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.status.message", "target_field": "audit.status.message", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.proto_payload.numResponseItems", "target_field": "audit.num_response_items", "ignore_missing": true}},
+    {"convert": {"field": "audit.num_response_items", "type": "long", "ignore_missing": true}}
+  ],
+  "on_failure": [
+    {"set": {"field": "error.message", "value": "{{ _ingest.on_failure_message }}"}}
+  ]
+}`
+
+func init() {
+	Register(&Module{
+		Name:     AuditModuleName,
+		Match:    "cloudaudit.googleapis.com/*.json",
+		Codec:    codec.CloudLoggingCodecId,
+		Pipeline: auditPipeline,
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package module
+
+import (
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+)
+
+// cloudLoggingPipeline moves the entry's message to message.
+const cloudLoggingPipeline = `{
+  "description": "Pipeline for Cloud Logging entries exported by a sink",
+  "processors": [
+    {"rename": {"field": "cloud_logging.message", "target_field": "message", "ignore_missing": true}}
+  ],
+  "on_failure": [
+    {"set": {"field": "error.message", "value": "{{ _ingest.on_failure_message }}"}}
+  ]
+}`
+
+func init() {
+	Register(&Module{
+		Name:     CloudLoggingModuleName,
+		Match:    "*.json",
+		Codec:    codec.CloudLoggingCodecId,
+		Pipeline: cloudLoggingPipeline,
+	})
+}
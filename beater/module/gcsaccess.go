@@ -0,0 +1,42 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package module
+
+import (
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+)
+
+// gcsAccessPipeline summarizes usage log requests in message.
+const gcsAccessPipeline = `{
+  "description": "Pipeline for Cloud Storage usage and storage logs",
+  "processors": [
+    {"script": {
+      "lang": "painless",
+      "source": "if (ctx.gcs_usage != null) { ctx.message = ctx.gcs_usage.cs_method + ' ' + ctx.gcs_usage.cs_uri + ' ' + ctx.gcs_usage.sc_status }"
+    }}
+  ],
+  "on_failure": [
+    {"set": {"field": "error.message", "value": "{{ _ingest.on_failure_message }}"}}
+  ]
+}`
+
+func init() {
+	Register(&Module{
+		Name:     GcsAccessModuleName,
+		Match:    "*_{usage,storage}_*_v*",
+		Codec:    codec.GcsAccessLogCodecId,
+		Pipeline: gcsAccessPipeline,
+	})
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/version"
+)
+
+const (
+	CloudLoggingModuleName = "cloud_logging"
+	GcsAccessModuleName    = "gcs_access"
+	VpcFlowModuleName      = "vpcflow"
+	AuditModuleName        = "audit"
+)
+
+// Module bundles the settings for reading a known source: which objects to
+// read and how to decode them, and the ingest pipeline for the events. The
+// fields the pipeline adds are documented in fields.yml.
+type Module struct {
+	Name string
+
+	// Match is the glob for the objects the source writes
+	Match      string
+	Codec      string
+	UnpackGzip bool
+
+	// Pipeline is the JSON definition of the ingest pipeline
+	Pipeline string
+}
+
+// PipelineId is the ID the module's pipeline is loaded under. It includes the
+// version so upgrades load the new pipelines.
+func (m *Module) PipelineId() string {
+	return fmt.Sprintf("gcsbeat-%s-%s-pipeline", version.GetDefaultVersion(), m.Name)
+}
+
+var registry = make(map[string]*Module)
+
+// Register makes a module available under its name.
+func Register(m *Module) {
+	if _, exists := registry[m.Name]; exists {
+		panic(fmt.Sprintf("module %q is registered twice", m.Name))
+	}
+
+	registry[m.Name] = m
+}
+
+// Get returns the module with the given name, or nil if there isn't one.
+func Get(name string) *Module {
+	return registry[name]
+}
+
+// ValidModules lists the registered modules in alphabetical order.
+func ValidModules() []string {
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// PipelineLoader is the part of the Elasticsearch client used to load
+// pipelines.
+type PipelineLoader interface {
+	LoadJSON(path string, json map[string]interface{}) ([]byte, error)
+	Request(method, path string, pipeline string, params map[string]string, body interface{}) (int, []byte, error)
+}
+
+// LoadPipelines loads the pipelines of the modules that aren't loaded yet.
+func LoadPipelines(esClient PipelineLoader, modules []*Module) error {
+	for _, m := range modules {
+		path := "/_ingest/pipeline/" + m.PipelineId()
+
+		status, _, _ := esClient.Request("GET", path, "", nil, nil)
+		if status == 200 {
+			logp.Debug("modules", "Pipeline %s is already loaded", m.PipelineId())
+			continue
+		}
+
+		var pipeline map[string]interface{}
+		if err := json.Unmarshal([]byte(m.Pipeline), &pipeline); err != nil {
+			return fmt.Errorf("the %s module's pipeline is invalid: %v", m.Name, err)
+		}
+
+		if body, err := esClient.LoadJSON(path, pipeline); err != nil {
+			return fmt.Errorf("error loading pipeline %s: %v %s", m.PipelineId(), err, body)
+		}
+
+		logp.Info("Loaded pipeline %s", m.PipelineId())
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+	"github.com/gobwas/glob"
+)
+
+func TestModules(t *testing.T) {
+	for _, name := range ValidModules() {
+		m := Get(name)
+
+		if _, err := glob.Compile(m.Match); err != nil {
+			t.Errorf("%q | Invalid match glob: %v", name, err)
+		}
+
+		if !codec.IsValidCodec(m.Codec) {
+			t.Errorf("%q | Invalid codec %q", name, m.Codec)
+		}
+
+		var pipeline struct {
+			Description string
+			Processors  []map[string]interface{}
+		}
+
+		if err := json.Unmarshal([]byte(m.Pipeline), &pipeline); err != nil {
+			t.Errorf("%q | Invalid pipeline: %v", name, err)
+		}
+
+		if pipeline.Description == "" || len(pipeline.Processors) == 0 {
+			t.Errorf("%q | Expected a description and processors, got %+v", name, pipeline)
+		}
+	}
+}
+
+func TestModuleMatches(t *testing.T) {
+	cases := map[string]string{
+		"syslog/2018/05/01/12:00:00_12:59:59_S0.json":                                   CloudLoggingModuleName,
+		"my-bucket_usage_2018_05_01_12_00_00_00a4f3d2_v0":                               GcsAccessModuleName,
+		"my-bucket_storage_2018_05_01_07_00_00_1234abcd_v0":                             GcsAccessModuleName,
+		"compute.googleapis.com/vpc_flows/2018/05/01/12:00:00_12:59:59_S0.json":         VpcFlowModuleName,
+		"cloudaudit.googleapis.com/activity/2018/05/01/12:00:00_12:59:59_S0.json":       AuditModuleName,
+		"cloudaudit.googleapis.com/data_access/2018/05/01/12:00:00_12:59:59_S0.json":    AuditModuleName,
+		"cloudaudit.googleapis.com/system_event/2018/05/01/12:00:00_12:59:59_S0.json":   AuditModuleName,
+		"compute.googleapis.com/vpc_flows/2018/05/01/12:00:00_12:59:59_S0.json.partial": "",
+	}
+
+	// the cloud_logging module also matches the others' objects
+	for path, expected := range cases {
+		var matches []string
+		for _, name := range []string{VpcFlowModuleName, AuditModuleName, GcsAccessModuleName, CloudLoggingModuleName} {
+			if glob.MustCompile(Get(name).Match).Match(path) {
+				matches = append(matches, name)
+			}
+		}
+
+		actual := ""
+		if len(matches) > 0 {
+			actual = matches[0]
+		}
+
+		if actual != expected {
+			t.Errorf("%q | Expected the %q module, got %v", path, expected, matches)
+		}
+	}
+}
+
+type fakeLoader struct {
+	existing map[string]bool
+	loaded   []string
+}
+
+func (f *fakeLoader) LoadJSON(path string, json map[string]interface{}) ([]byte, error) {
+	f.loaded = append(f.loaded, path)
+	return nil, nil
+}
+
+func (f *fakeLoader) Request(method, path string, pipeline string, params map[string]string, body interface{}) (int, []byte, error) {
+	if f.existing[path] {
+		return 200, nil, nil
+	}
+
+	return 404, nil, nil
+}
+
+func TestLoadPipelines(t *testing.T) {
+	audit, vpcflow := Get(AuditModuleName), Get(VpcFlowModuleName)
+
+	loader := &fakeLoader{existing: map[string]bool{
+		"/_ingest/pipeline/" + audit.PipelineId(): true,
+	}}
+
+	if err := LoadPipelines(loader, []*Module{audit, vpcflow}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf("[/_ingest/pipeline/%s]", vpcflow.PipelineId())
+	if actual := fmt.Sprintf("%v", loader.loaded); actual != expected {
+		t.Errorf("Expected only the missing pipeline to be loaded %v, got %v", expected, actual)
+	}
+
+	bad := &Module{Name: "bad", Pipeline: "{"}
+	if err := LoadPipelines(&fakeLoader{}, []*Module{bad}); err == nil {
+		t.Error("Expected an error loading an invalid pipeline")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package module
+
+import (
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+)
+
+// vpcFlowPipeline moves the flow record in the entry's JSON payload to
+// vpcflow and converts the counters, which are strings in JSON.
+const vpcFlowPipeline = `{
+  "description": "Pipeline for VPC flow logs exported by a Cloud Logging sink",
+  "processors": [
+    {"rename": {"field": "cloud_logging.json_payload.connection.src_ip", "target_field": "vpcflow.src_ip", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.connection.src_port", "target_field": "vpcflow.src_port", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.connection.dest_ip", "target_field": "vpcflow.dest_ip", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.connection.dest_port", "target_field": "vpcflow.dest_port", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.connection.protocol", "target_field": "vpcflow.protocol", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.bytes_sent", "target_field": "vpcflow.bytes_sent", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.packets_sent", "target_field": "vpcflow.packets_sent", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.rtt_msec", "target_field": "vpcflow.rtt_msec", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.start_time", "target_field": "vpcflow.start_time", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.end_time", "target_field": "vpcflow.end_time", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.reporter", "target_field": "vpcflow.reporter", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.src_instance", "target_field": "vpcflow.src_instance", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.dest_instance", "target_field": "vpcflow.dest_instance", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.src_vpc", "target_field": "vpcflow.src_vpc", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.dest_vpc", "target_field": "vpcflow.dest_vpc", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.src_location", "target_field": "vpcflow.src_location", "ignore_missing": true}},
+    {"rename": {"field": "cloud_logging.json_payload.dest_location", "target_field": "vpcflow.dest_location", "ignore_missing": true}},
+    {"convert": {"field": "vpcflow.bytes_sent", "type": "long", "ignore_missing": true}},
+    {"convert": {"field": "vpcflow.packets_sent", "type": "long", "ignore_missing": true}},
+    {"convert": {"field": "vpcflow.rtt_msec", "type": "long", "ignore_missing": true}}
+  ],
+  "on_failure": [
+    {"set": {"field": "error.message", "value": "{{ _ingest.on_failure_message }}"}}
+  ]
+}`
+
+func init() {
+	Register(&Module{
+		Name:     VpcFlowModuleName,
+		Match:    "compute.googleapis.com/vpc_flows/*.json",
+		Codec:    codec.CloudLoggingCodecId,
+		Pipeline: vpcFlowPipeline,
+	})
+}
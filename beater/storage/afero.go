@@ -72,3 +72,13 @@ func (asp *aferoStorageProvider) MarkProcessed(path string) error {
 	asp.processed[path] = true
 	return nil
 }
+
+// Attributes is empty for local files which don't have a content type or
+// metadata.
+func (asp *aferoStorageProvider) Attributes(path string) (*ObjectAttributes, error) {
+	if _, err := asp.fs.Stat(path); err != nil {
+		return nil, err
+	}
+
+	return &ObjectAttributes{}, nil
+}
@@ -53,6 +53,14 @@ func FilterAndExplain(filterName string, files []string, filter Filter) (filtere
 	return out, nil
 }
 
+// ExplainChoice logs which option chooser picks for each of the files.
+func ExplainChoice(choiceName string, files []string, chooser func(path string) string) {
+	explainLogger.Debugf("Choose: %s", choiceName)
+	for _, filename := range files {
+		explainLogger.Debugf(" - %q (%s)", filename, chooser(filename))
+	}
+}
+
 func explainFoundFiles(source string, files []string) []string {
 	explainLogger.Infof("Source %q found %d files", source, len(files))
 
@@ -88,6 +88,18 @@ func (gsp *gcpStorageProvider) Remove(path string) error {
 	return gsp.getObject(path).Delete(gsp.ctx)
 }
 
+func (gsp *gcpStorageProvider) Attributes(path string) (*ObjectAttributes, error) {
+	attrs, err := gsp.getAttrs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectAttributes{
+		ContentType: attrs.ContentType,
+		Metadata:    attrs.Metadata,
+	}, nil
+}
+
 func isMarkedAsProcessed(metadata map[string]string, metadataKey string) bool {
 	if metadata == nil {
 		return false
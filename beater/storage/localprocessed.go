@@ -113,3 +113,7 @@ func (middleware *localProcessedMiddleware) MarkProcessed(path string) error {
 		return b.Put([]byte(path), []byte(ProcessedMetadataValue))
 	})
 }
+
+func (middleware *localProcessedMiddleware) Attributes(path string) (*ObjectAttributes, error) {
+	return middleware.wrapped.Attributes(path)
+}
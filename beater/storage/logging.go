@@ -91,3 +91,13 @@ func (lsp *loggingStorageProvider) MarkProcessed(path string) error {
 
 	return err
 }
+
+func (lsp *loggingStorageProvider) Attributes(path string) (*ObjectAttributes, error) {
+	attrs, err := lsp.wrapped.Attributes(path)
+
+	if err != nil {
+		lsp.logger.Errorf("Error getting the attributes of file %q: %v", path, err)
+	}
+
+	return attrs, err
+}
@@ -27,6 +27,13 @@ type StorageProvider interface {
 	Remove(path string) error
 	WasProcessed(path string) (bool, error)
 	MarkProcessed(path string) error
+	Attributes(path string) (*ObjectAttributes, error)
+}
+
+// ObjectAttributes are the properties of an object that describe its content.
+type ObjectAttributes struct {
+	ContentType string
+	Metadata    map[string]string
 }
 
 func NewStorageProvider(cfg *config.Config) (StorageProvider, error) {
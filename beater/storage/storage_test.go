@@ -95,6 +95,20 @@ func TestStorageProviderRead(t *testing.T) {
 	}
 }
 
+func TestStorageProviderAttributes(t *testing.T) {
+	for _, sp := range setupSpTestCases() {
+		t.Run(sp.name, func(t *testing.T) {
+			if attrs, err := sp.provider.Attributes("exists.log"); err != nil || attrs == nil {
+				t.Errorf("Expected no error %v and non-nil attributes %v", err, attrs)
+			}
+
+			if _, err := sp.provider.Attributes("does/not/exist.log"); err == nil {
+				t.Errorf("Expected error getting the attributes of a file that does not exist")
+			}
+		})
+	}
+}
+
 func TestStorageProviderRemove(t *testing.T) {
 	for _, sp := range setupSpTestCases() {
 		t.Run(sp.name, func(t *testing.T) {
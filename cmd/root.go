@@ -15,13 +15,72 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/GoogleCloudPlatform/gcsbeat/beater"
 
+	"github.com/elastic/beats/libbeat/beat"
 	cmd "github.com/elastic/beats/libbeat/cmd"
+	"github.com/elastic/beats/libbeat/common"
 )
 
 // Name of this beat
 var Name = "gcsbeat"
 
 // RootCmd to handle beats cli
-var RootCmd = cmd.GenRootCmd(Name, "", beater.New)
+var RootCmd = cmd.GenRootCmd(Name, "", newBeater)
+
+// setupFlags are the flags of the setup command. pflag is vendored inside
+// libbeat, so the flag set is kept behind the one method used.
+var setupFlags interface {
+	GetBool(name string) (bool, error)
+}
+
+func init() {
+	setup := RootCmd.SetupCmd
+	setup.Short = "Setup index template, ingest pipelines, dashboards and ML jobs"
+	setup.Long = `This command does initial setup of the environment:
+
+ * Index mapping template in Elasticsearch to ensure fields are mapped.
+ * Ingest pipelines of the enabled modules.
+ * Kibana dashboards (where available).
+ * ML jobs (where available).
+`
+	setup.Flags().Bool("pipelines", false, "Setup ingest pipelines only")
+	setupFlags = setup.Flags()
+}
+
+// newBeater creates the beater and, in the setup command, loads the module
+// pipelines. libbeat's setup connects to Elasticsearch without running the
+// output's connect callbacks, so it never loads them itself.
+func newBeater(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
+	bt, err := beater.New(b, cfg)
+	if err != nil || !b.InSetupCmd {
+		return bt, err
+	}
+
+	template, _ := setupFlags.GetBool("template")
+	dashboards, _ := setupFlags.GetBool("dashboards")
+	machineLearning, _ := setupFlags.GetBool("machine-learning")
+	pipelines, _ := setupFlags.GetBool("pipelines")
+	others := template || dashboards || machineLearning
+
+	// Only libbeat's steps were asked for
+	if others && !pipelines {
+		return bt, nil
+	}
+
+	if err := beater.SetupPipelines(b); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Loaded ingest pipelines")
+
+	// libbeat sets everything up when none of its flags are given, so stop
+	// here when only the pipelines were asked for.
+	if pipelines && !others {
+		return nil, beat.GracefulExit
+	}
+
+	return bt, nil
+}
@@ -21,22 +21,87 @@ import (
 	"time"
 
 	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/module"
 	"github.com/gobwas/glob"
 
 	"github.com/elastic/beats/libbeat/common"
 )
 
+const (
+	// OnErrorAbort stops reading a file at the first bad record, the file
+	// isn't marked so it's read again
+	OnErrorAbort = "abort"
+
+	// OnErrorSkip logs bad records and continues with the next one
+	OnErrorSkip = "skip"
+
+	// OnErrorEmit publishes bad records as events with an error field
+	OnErrorEmit = "emit"
+)
+
+// CodecConfig is the codec setting. It's either the ID of a codec or a block
+// with the ID under type and the codec's options e.g.
+//
+//	codec: {type: csv, separator: ";"}
+type CodecConfig struct {
+	Type    string
+	Options *common.Config
+}
+
+func (c *CodecConfig) Unpack(in interface{}) error {
+	switch v := in.(type) {
+	case string:
+		c.Type = v
+		c.Options = nil
+		return nil
+
+	case map[string]interface{}:
+		codecType, ok := v["type"].(string)
+		if !ok {
+			return errors.New("codec blocks need the codec's type")
+		}
+
+		options := make(map[string]interface{})
+		for key, value := range v {
+			if key != "type" {
+				options[key] = value
+			}
+		}
+
+		cfg, err := common.NewConfigFrom(options)
+		if err != nil {
+			return err
+		}
+
+		c.Type = codecType
+		c.Options = cfg
+		return nil
+	}
+
+	return fmt.Errorf("codec must be a codec's ID or a block, got %v", in)
+}
+
 type Config struct {
-	Interval        time.Duration `config:"interval"`
-	BucketId        string        `config:"bucket_id" validate:"required"`
-	JsonKeyFile     string        `config:"json_key_file"`
-	Delete          bool          `config:"delete"`
-	Match           string        `config:"file_matches"`
-	Exclude         string        `config:"file_exclude"`
-	MetadataKey     string        `config:"metadata_key"`
-	Codec           string        `config:"codec"`
-	UnpackGzip      bool          `config:"unpack_gzip"`
-	ProcessedDbPath string        `config:"processed_db_path"`
+	Interval        time.Duration  `config:"interval"`
+	BucketId        string         `config:"bucket_id" validate:"required"`
+	JsonKeyFile     string         `config:"json_key_file"`
+	Delete          bool           `config:"delete"`
+	Match           string         `config:"file_matches"`
+	Exclude         string         `config:"file_exclude"`
+	MetadataKey     string         `config:"metadata_key"`
+	Codec           CodecConfig    `config:"codec"`
+	CodecOptions    *common.Config `config:"codec_options"`
+	UnpackGzip      bool           `config:"unpack_gzip"`
+	ProcessedDbPath string         `config:"processed_db_path"`
+	OnError         string         `config:"on_error"`
+	Rules           []RuleConfig   `config:"rules"`
+	Modules         []ModuleConfig `config:"modules"`
+
+	// defaultRule holds the top level settings for objects no rule matches
+	defaultRule RuleConfig
+
+	// modules are the enabled modules, their rules follow the configured ones
+	modules []*module.Module
 }
 
 var DefaultConfig = Config{
@@ -47,8 +112,9 @@ var DefaultConfig = Config{
 	Match:       "*",
 	Exclude:     "",
 	MetadataKey: "x-goog-meta-gcsbeat",
-	Codec:       "text",
+	Codec:       CodecConfig{Type: "text"},
 	UnpackGzip:  false,
+	OnError:     OnErrorAbort,
 }
 
 func GetAndValidateConfig(cfg *common.Config) (*Config, error) {
@@ -78,10 +144,41 @@ func GetAndValidateConfig(cfg *common.Config) (*Config, error) {
 		return nil, errors.New("The metadata key must not be blank.")
 	}
 
-	if !codec.IsValidCodec(c.Codec) {
-		msg := fmt.Sprintf("%q is an invalid codec. Use one of: %v", c.Codec, codec.ValidCodecs())
+	if !codec.IsValidCodec(c.Codec.Type) {
+		msg := fmt.Sprintf("%q is an invalid codec. Use one of: %v", c.Codec.Type, codec.ValidCodecs())
 		return nil, errors.New(msg)
 	}
 
+	// codec_options is kept for configs from before codec blocks
+	if c.Codec.Options == nil {
+		c.Codec.Options = c.CodecOptions
+	} else if c.CodecOptions != nil {
+		return nil, errors.New("Set the codec's options in either the codec block or codec_options, not both.")
+	}
+
+	if err := codec.ValidateCodecOptions(c.Codec.Type, c.Codec.Options); err != nil {
+		return nil, err
+	}
+
+	switch c.OnError {
+	case OnErrorAbort, OnErrorSkip, OnErrorEmit:
+	default:
+		return nil, fmt.Errorf("%q is an invalid on_error policy. Use one of: %v", c.OnError, []string{OnErrorAbort, OnErrorSkip, OnErrorEmit})
+	}
+
+	for i := range c.Rules {
+		if err := c.Rules[i].compile(i + 1); err != nil {
+			return nil, fmt.Errorf("Rule %d is invalid: %v", i+1, err)
+		}
+
+		c.Rules[i].inherit(&c)
+	}
+
+	if err := c.addModuleRules(); err != nil {
+		return nil, err
+	}
+
+	c.defaultRule.inherit(&c)
+
 	return &c, nil
 }
@@ -17,8 +17,11 @@
 package config
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/module"
+
 	"github.com/elastic/beats/libbeat/common"
 )
 
@@ -82,6 +85,90 @@ func TestGetAndValidateInvalidProps(t *testing.T) {
 		configure("codec text", false, map[string]interface{}{"codec": "text"}),
 		configure("codec json array", false, map[string]interface{}{"codec": "json-array"}),
 		configure("codec json stream", false, map[string]interface{}{"codec": "json-stream"}),
+		configure("codec csv", false, map[string]interface{}{"codec": "csv"}),
+
+		// codec options
+		configure("csv options", false, map[string]interface{}{
+			"codec":         "csv",
+			"codec_options": map[string]interface{}{"separator": ";", "header": true},
+		}),
+		configure("bad csv options", true, map[string]interface{}{
+			"codec":         "csv",
+			"codec_options": map[string]interface{}{"separator": ";;"},
+		}),
+		configure("options unused by codec", false, map[string]interface{}{
+			"codec":         "text",
+			"codec_options": map[string]interface{}{"separator": ";;"},
+		}),
+
+		configure("codec block", false, map[string]interface{}{
+			"codec": map[string]interface{}{"type": "csv", "separator": ";", "header": true},
+		}),
+		configure("codec block without options", false, map[string]interface{}{
+			"codec": map[string]interface{}{"type": "json-stream"},
+		}),
+		configure("bad codec block options", true, map[string]interface{}{
+			"codec": map[string]interface{}{"type": "csv", "separator": ";;"},
+		}),
+		configure("codec block unknown type", true, map[string]interface{}{
+			"codec": map[string]interface{}{"type": "foo"},
+		}),
+		configure("codec block without type", true, map[string]interface{}{
+			"codec": map[string]interface{}{"separator": ";"},
+		}),
+		configure("codec block and codec options", true, map[string]interface{}{
+			"codec":         map[string]interface{}{"type": "csv", "separator": ";"},
+			"codec_options": map[string]interface{}{"header": true},
+		}),
+		configure("codec list", true, map[string]interface{}{"codec": []string{"csv"}}),
+
+		// error policies
+		configure("on error skip", false, map[string]interface{}{"on_error": "skip"}),
+		configure("on error emit", false, map[string]interface{}{"on_error": "emit"}),
+		configure("on error unknown", true, map[string]interface{}{"on_error": "retry"}),
+
+		// rules
+		configure("glob rule", false, map[string]interface{}{
+			"rules": []map[string]interface{}{{"match": "*.csv", "codec": "csv"}},
+		}),
+		configure("regex rule", false, map[string]interface{}{
+			"rules": []map[string]interface{}{{"regex": `\.json$`, "codec": map[string]interface{}{"type": "json-stream"}}},
+		}),
+		configure("rule without pattern", true, map[string]interface{}{
+			"rules": []map[string]interface{}{{"codec": "csv"}},
+		}),
+		configure("rule with glob and regex", true, map[string]interface{}{
+			"rules": []map[string]interface{}{{"match": "*.csv", "regex": "csv$"}},
+		}),
+		configure("rule bad glob", true, map[string]interface{}{
+			"rules": []map[string]interface{}{{"match": "[a-z"}},
+		}),
+		configure("rule bad regex", true, map[string]interface{}{
+			"rules": []map[string]interface{}{{"regex": "(csv"}},
+		}),
+		configure("rule unknown codec", true, map[string]interface{}{
+			"rules": []map[string]interface{}{{"match": "*.csv", "codec": "foo"}},
+		}),
+		configure("rule bad codec options", true, map[string]interface{}{
+			"rules": []map[string]interface{}{{"match": "*.csv", "codec": map[string]interface{}{"type": "csv", "separator": ";;"}}},
+		}),
+
+		// modules
+		configure("module", false, map[string]interface{}{
+			"modules": []map[string]interface{}{{"module": "audit"}},
+		}),
+		configure("module with match", false, map[string]interface{}{
+			"modules": []map[string]interface{}{{"module": "vpcflow", "match": "flows/*.json"}},
+		}),
+		configure("unknown module", true, map[string]interface{}{
+			"modules": []map[string]interface{}{{"module": "nginx"}},
+		}),
+		configure("module without name", true, map[string]interface{}{
+			"modules": []map[string]interface{}{{"match": "*.json"}},
+		}),
+		configure("module bad glob", true, map[string]interface{}{
+			"modules": []map[string]interface{}{{"module": "audit", "match": "[a-z"}},
+		}),
 	}
 
 	for _, testCase := range tests {
@@ -93,3 +180,141 @@ func TestGetAndValidateInvalidProps(t *testing.T) {
 		}
 	}
 }
+
+func TestGetAndValidateCodecBlock(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"block": {
+			"codec": map[string]interface{}{"type": "csv", "separator": ";"},
+		},
+		"codec options": {
+			"codec":         "csv",
+			"codec_options": map[string]interface{}{"separator": ";"},
+		},
+	}
+
+	for tn, props := range cases {
+		c, err := GetAndValidateConfig(configure(tn, false, props).Config)
+		if err != nil {
+			t.Fatalf("%q | Unexpected error %v", tn, err)
+		}
+
+		if c.Codec.Type != "csv" {
+			t.Errorf("%q | Expected the csv codec, got %q", tn, c.Codec.Type)
+		}
+
+		separator, err := c.Codec.Options.String("separator", -1)
+		if err != nil || separator != ";" {
+			t.Errorf("%q | Expected the separator option, got %q (%v)", tn, separator, err)
+		}
+
+		if c.Codec.Options.HasField("type") {
+			t.Errorf("%q | Expected type to be removed from the options", tn)
+		}
+	}
+}
+
+func TestRuleFor(t *testing.T) {
+	c, err := GetAndValidateConfig(configure("rules", false, map[string]interface{}{
+		"codec":       "json-stream",
+		"unpack_gzip": true,
+		"rules": []map[string]interface{}{
+			{"match": "*.csv*", "codec": "csv", "unpack_gzip": false, "index": "billing"},
+			{"regex": `^logs/.*\.log$`, "name": "app logs", "tags": []string{"app"}},
+			{"match": "*.log", "codec": "syslog"},
+		},
+	}).Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		Rule       string
+		Codec      string
+		UnpackGzip bool
+	}{
+		"bill.csv.gz":    {Rule: `rule 1 matching "*.csv*"`, Codec: "csv", UnpackGzip: false},
+		"logs/app.log":   {Rule: `rule 2 "app logs"`, Codec: "json-stream", UnpackGzip: true},
+		"other/app.log":  {Rule: `rule 3 matching "*.log"`, Codec: "syslog", UnpackGzip: true},
+		"data.json.gz":   {Rule: "no rule", Codec: "json-stream", UnpackGzip: true},
+		"logs/notes.txt": {Rule: "no rule", Codec: "json-stream", UnpackGzip: true},
+	}
+
+	for path, tc := range cases {
+		rule := c.RuleFor(path)
+
+		if rule.String() != tc.Rule {
+			t.Errorf("%q | Expected %s, got %s", path, tc.Rule, rule)
+		}
+
+		if rule.Codec.Type != tc.Codec {
+			t.Errorf("%q | Expected the %s codec, got %s", path, tc.Codec, rule.Codec.Type)
+		}
+
+		if *rule.UnpackGzip != tc.UnpackGzip {
+			t.Errorf("%q | Expected unpack_gzip %v, got %v", path, tc.UnpackGzip, *rule.UnpackGzip)
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	c, err := GetAndValidateConfig(configure("modules", false, map[string]interface{}{
+		"rules": []map[string]interface{}{
+			{"match": "cloudaudit.googleapis.com/activity/*", "codec": "json-stream"},
+		},
+		"modules": []map[string]interface{}{
+			{"module": "audit", "tags": []string{"audit"}},
+			{"module": "vpcflow", "enabled": false},
+			{"module": "cloud_logging"},
+		},
+	}).Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var enabled []string
+	for _, m := range c.EnabledModules() {
+		enabled = append(enabled, m.Name)
+	}
+
+	if fmt.Sprintf("%v", enabled) != "[audit cloud_logging]" {
+		t.Errorf("Expected the audit and cloud_logging modules, got %v", enabled)
+	}
+
+	cases := map[string]struct {
+		Rule     string
+		Codec    string
+		Pipeline string
+	}{
+		"cloudaudit.googleapis.com/activity/2018/05/01/12:00:00_12:59:59_S0.json": {
+			Rule: `rule 1 matching "cloudaudit.googleapis.com/activity/*"`, Codec: "json-stream",
+		},
+		"cloudaudit.googleapis.com/data_access/2018/05/01/12:00:00_12:59:59_S0.json": {
+			Rule: `rule 2 "audit module"`, Codec: "cloud-logging", Pipeline: module.Get("audit").PipelineId(),
+		},
+		"compute.googleapis.com/vpc_flows/2018/05/01/12:00:00_12:59:59_S0.json": {
+			Rule: `rule 3 "cloud_logging module"`, Codec: "cloud-logging", Pipeline: module.Get("cloud_logging").PipelineId(),
+		},
+		"logs/app.log": {Rule: "no rule", Codec: "text"},
+	}
+
+	for path, tc := range cases {
+		rule := c.RuleFor(path)
+
+		if rule.String() != tc.Rule {
+			t.Errorf("%q | Expected %s, got %s", path, tc.Rule, rule)
+		}
+
+		if rule.Codec.Type != tc.Codec {
+			t.Errorf("%q | Expected the %s codec, got %s", path, tc.Codec, rule.Codec.Type)
+		}
+
+		if rule.Pipeline != tc.Pipeline {
+			t.Errorf("%q | Expected the %q pipeline, got %q", path, tc.Pipeline, rule.Pipeline)
+		}
+	}
+
+	rule := c.RuleFor("cloudaudit.googleapis.com/data_access/x.json")
+	if rule.Fields["module"] != "audit" || !rule.FieldsUnderRoot || fmt.Sprintf("%v", rule.Tags) != "[audit]" {
+		t.Errorf("Expected the module's name and tags, got %v %v", rule.Fields, rule.Tags)
+	}
+}
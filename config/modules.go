@@ -0,0 +1,90 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/module"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// ModuleConfig enables a bundled module. The module's match glob can be
+// overridden for buckets that keep the objects somewhere else.
+type ModuleConfig struct {
+	Module  string   `config:"module"`
+	Enabled *bool    `config:"enabled"`
+	Match   string   `config:"match"`
+	Tags    []string `config:"tags"`
+	Index   string   `config:"index"`
+}
+
+// rule turns the module into the rule for its objects, the events get the
+// module's name in module and go through its pipeline.
+func (m *ModuleConfig) rule() (RuleConfig, *module.Module, error) {
+	mod := module.Get(m.Module)
+	if mod == nil {
+		return RuleConfig{}, nil, fmt.Errorf("%q is an invalid module. Use one of: %v", m.Module, module.ValidModules())
+	}
+
+	match := m.Match
+	if match == "" {
+		match = mod.Match
+	}
+
+	unpackGzip := mod.UnpackGzip
+
+	return RuleConfig{
+		Name:            m.Module + " module",
+		Match:           match,
+		Codec:           &CodecConfig{Type: mod.Codec},
+		UnpackGzip:      &unpackGzip,
+		Fields:          common.MapStr{"module": mod.Name},
+		FieldsUnderRoot: true,
+		Tags:            m.Tags,
+		Index:           m.Index,
+		Pipeline:        mod.PipelineId(),
+	}, mod, nil
+}
+
+// addModuleRules appends the rules of the enabled modules after the
+// configured rules so the configured rules take precedence.
+func (c *Config) addModuleRules() error {
+	for i := range c.Modules {
+		if enabled := c.Modules[i].Enabled; enabled != nil && !*enabled {
+			continue
+		}
+
+		rule, mod, err := c.Modules[i].rule()
+		if err != nil {
+			return err
+		}
+
+		if err := rule.compile(len(c.Rules) + 1); err != nil {
+			return fmt.Errorf("The %s module is invalid: %v", mod.Name, err)
+		}
+
+		c.Rules = append(c.Rules, rule)
+		c.modules = append(c.modules, mod)
+	}
+
+	return nil
+}
+
+// EnabledModules lists the modules whose pipelines have to be loaded.
+func (c *Config) EnabledModules() []*module.Module {
+	return c.modules
+}
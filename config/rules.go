@@ -0,0 +1,123 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/GoogleCloudPlatform/gcsbeat/beater/codec"
+	"github.com/gobwas/glob"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// RuleConfig overrides the settings for objects whose names match a glob or
+// regular expression. Unset overrides fall back to the top level settings.
+type RuleConfig struct {
+	Name            string        `config:"name"`
+	Match           string        `config:"match"`
+	Regex           string        `config:"regex"`
+	Codec           *CodecConfig  `config:"codec"`
+	UnpackGzip      *bool         `config:"unpack_gzip"`
+	Fields          common.MapStr `config:"fields"`
+	FieldsUnderRoot bool          `config:"fields_under_root"`
+	Tags            []string      `config:"tags"`
+	Index           string        `config:"index"`
+	Pipeline        string        `config:"pipeline"`
+
+	number  int
+	matches func(path string) bool
+}
+
+// compile checks the rule and prepares its matcher, number is the rule's
+// position in the list starting at 1.
+func (r *RuleConfig) compile(number int) error {
+	r.number = number
+
+	switch {
+	case r.Match != "" && r.Regex != "":
+		return errors.New("set either match or regex, not both")
+
+	case r.Match != "":
+		matcher, err := glob.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid glob", r.Match)
+		}
+
+		r.matches = matcher.Match
+
+	case r.Regex != "":
+		matcher, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("%q is not a valid regex: %v", r.Regex, err)
+		}
+
+		r.matches = matcher.MatchString
+
+	default:
+		return errors.New("a match glob or regex is required")
+	}
+
+	if r.Codec != nil {
+		if !codec.IsValidCodec(r.Codec.Type) {
+			return fmt.Errorf("%q is an invalid codec. Use one of: %v", r.Codec.Type, codec.ValidCodecs())
+		}
+
+		if err := codec.ValidateCodecOptions(r.Codec.Type, r.Codec.Options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// inherit fills in the overrides the rule doesn't set from the top level.
+func (r *RuleConfig) inherit(c *Config) {
+	if r.Codec == nil {
+		r.Codec = &c.Codec
+	}
+
+	if r.UnpackGzip == nil {
+		r.UnpackGzip = &c.UnpackGzip
+	}
+}
+
+// String describes the rule for the explain log.
+func (r *RuleConfig) String() string {
+	switch {
+	case r.number == 0:
+		return "no rule"
+	case r.Name != "":
+		return fmt.Sprintf("rule %d %q", r.number, r.Name)
+	case r.Match != "":
+		return fmt.Sprintf("rule %d matching %q", r.number, r.Match)
+	default:
+		return fmt.Sprintf("rule %d matching regex %q", r.number, r.Regex)
+	}
+}
+
+// RuleFor returns the first rule matching path, or the top level settings
+// as a rule if none of them do.
+func (c *Config) RuleFor(path string) *RuleConfig {
+	for i := range c.Rules {
+		if c.Rules[i].matches(path) {
+			return &c.Rules[i]
+		}
+	}
+
+	return &c.defaultRule
+}